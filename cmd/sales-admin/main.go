@@ -2,20 +2,20 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/devisions/garagesale/internal/drawer"
 	"github.com/devisions/garagesale/internal/platform/auth"
 	"github.com/devisions/garagesale/internal/platform/conf"
 	"github.com/devisions/garagesale/internal/platform/database"
 	"github.com/devisions/garagesale/internal/schema"
 	"github.com/devisions/garagesale/internal/user"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
@@ -40,6 +40,28 @@ func run() error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:false"`
 		}
+		Password struct {
+			MinLength     int `conf:"default:8"`
+			RequireUpper  bool
+			RequireLower  bool
+			RequireDigit  bool
+			RequireSymbol bool
+			DenyListFile  string
+			Hasher        string `conf:"default:bcrypt,help:algorithm hashing passwords: bcrypt or argon2id"`
+			BcryptCost    int    `conf:"default:10"`
+			ArgonTime     uint32 `conf:"default:3,help:argon2id passes over the memory"`
+			ArgonMemory   uint32 `conf:"default:65536,help:argon2id memory in KiB"`
+			ArgonThreads  uint8  `conf:"default:4"`
+		}
+		Org   string `conf:"default:8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01,help:organization the drawer commands act on"`
+		Type  string `conf:"default:rsa,help:type of the keys generated: rsa (RS256) p256 (ES256) p384 (ES384) or ed25519 (EdDSA)"`
+		Authn struct {
+			Algorithm string `conf:"default:RS256,help:algorithm the API signs tokens with which rotated keys have to fit"`
+		}
+		Keys struct {
+			PublishDelay time.Duration `conf:"default:10m,help:time rotated keys are published before signing"`
+			RetireDelay  time.Duration `conf:"default:1h,help:time replaced keys keep verifying the tokens they signed"`
+		}
 		Args conf.Args
 	}
 
@@ -64,16 +86,42 @@ func run() error {
 		DisableTLS: cfg.DB.DisableTLS,
 	}
 
-	var err error
+	policy := user.Policy{
+		MinLength:     cfg.Password.MinLength,
+		RequireUpper:  cfg.Password.RequireUpper,
+		RequireLower:  cfg.Password.RequireLower,
+		RequireDigit:  cfg.Password.RequireDigit,
+		RequireSymbol: cfg.Password.RequireSymbol,
+	}
+	if cfg.Password.DenyListFile != "" {
+		list, err := user.ReadDenyList(cfg.Password.DenyListFile)
+		if err != nil {
+			return errors.Wrap(err, "setting up password policy")
+		}
+		policy.DenyList = list
+	}
+	hasher, err := user.NewPasswordHasher(cfg.Password.Hasher,
+		user.Bcrypt{Cost: cfg.Password.BcryptCost},
+		user.Argon2id{Time: cfg.Password.ArgonTime, Memory: cfg.Password.ArgonMemory, Threads: cfg.Password.ArgonThreads},
+	)
+	if err != nil {
+		return errors.Wrap(err, "setting up password policy")
+	}
+	policy.Hasher = hasher
+
 	switch cfg.Args.Num(0) {
 	case "migrate":
 		err = migrate(dbConfig)
 	case "seed":
 		err = seed(dbConfig)
 	case "useradd":
-		err = useradd(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2))
+		err = useradd(dbConfig, policy, cfg.Args.Num(1), cfg.Args.Num(2))
 	case "keygen":
-		err = keygen(cfg.Args.Num(1))
+		err = keygen(cfg.Args.Num(1), cfg.Type)
+	case "keys":
+		err = keysCmd(cfg.Args.Num(1), cfg.Args.Num(2), cfg.Type, cfg.Authn.Algorithm, cfg.Keys.PublishDelay, cfg.Keys.RetireDelay)
+	case "drawer":
+		err = drawerCmd(dbConfig, cfg.Org, cfg.Args.Num(1), cfg.Args.Num(2), cfg.Args.Num(3))
 	default:
 		err = errors.New("Must specify a command")
 	}
@@ -117,7 +165,7 @@ func seed(cfg database.Config) error {
 	return nil
 }
 
-func useradd(cfg database.Config, email, password string) error {
+func useradd(cfg database.Config, policy user.Policy, email, password string) error {
 
 	db, err := database.Open(cfg)
 	if err != nil {
@@ -129,7 +177,7 @@ func useradd(cfg database.Config, email, password string) error {
 		return errors.New("useradd command must be called with two additional arguments for email and password")
 	}
 
-	fmt.Printf("Admin user will be created with email %q and password %q\n", email, password)
+	fmt.Printf("Super admin user will be created with email %q and password %q\n", email, password)
 	fmt.Print("Continue? (1/0) ")
 
 	var confirm bool
@@ -148,10 +196,10 @@ func useradd(cfg database.Config, email, password string) error {
 		Email:           email,
 		Password:        password,
 		PasswordConfirm: password,
-		Roles:           []string{auth.RoleAdmin, auth.RoleUser},
+		Roles:           []string{auth.RoleSuperAdmin, auth.RoleAdmin, auth.RoleUser},
 	}
 
-	u, err := user.Create(ctx, db, nu, time.Now())
+	u, err := user.Create(ctx, db, policy, nu, time.Now())
 	if err != nil {
 		return err
 	}
@@ -160,34 +208,112 @@ func useradd(cfg database.Config, email, password string) error {
 	return nil
 }
 
+// drawerCmd opens, closes or shows the status of the cash drawer session of
+// an organization. Opening takes the float and closing takes the counted cash,
+// plus optional notes.
+func drawerCmd(cfg database.Config, orgID, action, amount, notes string) error {
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	claims := auth.Claims{OrgID: orgID}
+
+	switch action {
+	case "open":
+		float, err := strconv.Atoi(amount)
+		if err != nil {
+			return errors.New("drawer open command must be called with the float amount")
+		}
+		s, err := drawer.Open(ctx, db, claims, drawer.NewSession{Float: float}, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Drawer opened with a float of %d, session id: %s\n", s.Float, s.ID)
+
+	case "close":
+		counted, err := strconv.Atoi(amount)
+		if err != nil {
+			return errors.New("drawer close command must be called with the counted amount")
+		}
+		s, err := drawer.Current(ctx, db, claims, time.Now())
+		if err != nil {
+			return err
+		}
+		s, err = drawer.Close(ctx, db, claims, s.ID, drawer.CloseSession{Counted: counted, Notes: notes}, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Drawer closed: expected %d, counted %d, discrepancy %d\n", *s.Expected, *s.Counted, *s.Discrepancy)
+
+	case "status":
+		s, err := drawer.Current(ctx, db, claims, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Drawer open since %s with a float of %d, expected cash: %d\n", s.OpenedAt.Format(time.RFC3339), s.Float, *s.Expected)
+
+	default:
+		return errors.New("drawer command must be followed by one of: open, close, status")
+	}
+
+	return nil
+}
+
 // keygen creates an x509 private key for signing auth tokens.
-func keygen(path string) error {
+func keygen(path, keyType string) error {
 	if path == "" {
 		return errors.New("keygen missing argument for key path")
 	}
 
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := auth.GenerateKey(keyType)
 	if err != nil {
 		return errors.Wrap(err, "generating keys")
 	}
-
-	file, err := os.Create(path)
+	data, err := auth.EncodePrivateKey(key)
 	if err != nil {
-		return errors.Wrap(err, "creating private file")
+		return err
 	}
-	defer file.Close()
 
-	block := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return errors.Wrap(err, "writing private file")
 	}
 
-	if err := pem.Encode(file, &block); err != nil {
-		return errors.Wrap(err, "encoding to private file")
+	return nil
+}
+
+// keysCmd manages the directory of signing keys of the API. Rotating adds a
+// new key, published ahead of signing with it, and retires the current ones
+// once the tokens they signed expired. The API picks the change up on SIGHUP.
+// Keys not fitting the algorithm of the API are refused.
+func keysCmd(action, dir, keyType, algorithm string, publish, retire time.Duration) error {
+
+	if dir == "" {
+		return errors.New("keys missing argument for keys directory")
 	}
 
-	if err := file.Close(); err != nil {
-		return errors.Wrap(err, "closing private file")
+	switch action {
+	case "rotate":
+		key, err := auth.GenerateKey(keyType)
+		if err != nil {
+			return errors.Wrap(err, "generating keys")
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return errors.Wrap(err, "creating keys directory")
+		}
+
+		k, err := auth.RotateKeys(dir, algorithm, auth.SigningKey{ID: uuid.New().String(), PrivateKey: key}, time.Now(), publish, retire)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Key %s added, signing from %s\n", k.ID, k.NotBefore.Format(time.RFC3339))
+
+	default:
+		return errors.New("keys command must be followed by: rotate")
 	}
 
 	return nil
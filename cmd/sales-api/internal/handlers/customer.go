@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/customer"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// CustomerHandlers has handler methods for dealing with Customers.
+type CustomerHandlers struct {
+	db *sqlx.DB
+}
+
+// List gives all customers as a list.
+func (c *CustomerHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Customers.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := customer.List(ctx, c.db, claims)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve gives a single Customer.
+func (c *CustomerHandlers) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Customers.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+	cust, err := customer.Retrieve(ctx, c.db, claims, id)
+	if err != nil {
+		switch err {
+		case customer.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case customer.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for customer %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, cust, http.StatusOK)
+}
+
+// Create decodes a JSON from the POST request and create a new Customer.
+func (c *CustomerHandlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Customers.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var nc customer.NewCustomer
+	if err := web.Decode(r, &nc); err != nil {
+		return err
+	}
+
+	cust, err := customer.Create(ctx, c.db, claims, nc, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, cust, http.StatusCreated)
+}
+
+// Update decodes the body of a request to update an existing customer. The ID
+// of the customer is part of the request URL.
+func (c *CustomerHandlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Customers.Update")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var update customer.UpdateCustomer
+	if err := web.Decode(r, &update); err != nil {
+		return errors.Wrap(err, "decoding customer update")
+	}
+
+	if err := customer.Update(ctx, c.db, claims, id, update, time.Now()); err != nil {
+		switch err {
+		case customer.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case customer.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "updating customer %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes a single customer identified by an ID in the request URL.
+func (c *CustomerHandlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Customers.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := customer.Delete(ctx, c.db, claims, id); err != nil {
+		switch err {
+		case customer.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "deleting customer %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ListPurchases gets everything a particular customer bought.
+func (c *CustomerHandlers) ListPurchases(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Customers.ListPurchases")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	list, err := customer.ListPurchases(ctx, c.db, claims, id)
+	if err != nil {
+		switch err {
+		case customer.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case customer.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "getting purchases of customer %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
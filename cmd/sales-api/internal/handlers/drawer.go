@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/drawer"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// DrawerHandlers has handler methods for dealing with cash drawer sessions.
+type DrawerHandlers struct {
+	db *sqlx.DB
+}
+
+// Open starts a new cash drawer session. It looks for a JSON object with the
+// float in the request body.
+func (d *DrawerHandlers) Open(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Drawers.Open")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var ns drawer.NewSession
+	if err := web.Decode(r, &ns); err != nil {
+		return errors.Wrap(err, "decoding new drawer session")
+	}
+
+	s, err := drawer.Open(ctx, d.db, claims, ns, time.Now())
+	if err != nil {
+		return drawerError(err, "opening drawer")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusCreated)
+}
+
+// List gives all cash drawer sessions.
+func (d *DrawerHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Drawers.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := drawer.List(ctx, d.db, claims)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Current gives the open cash drawer session, with the cash expected so far.
+func (d *DrawerHandlers) Current(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Drawers.Current")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	s, err := drawer.Current(ctx, d.db, claims, time.Now())
+	if err != nil {
+		return drawerError(err, "looking for open drawer session")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusOK)
+}
+
+// Retrieve gives a single cash drawer session.
+func (d *DrawerHandlers) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Drawers.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	s, err := drawer.Retrieve(ctx, d.db, claims, chi.URLParam(r, "id"), time.Now())
+	if err != nil {
+		return drawerError(err, "looking for drawer session")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusOK)
+}
+
+// Close ends a cash drawer session. It looks for a JSON object with the
+// counted cash in the request body. The reconciled session is returned.
+func (d *DrawerHandlers) Close(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Drawers.Close")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var cs drawer.CloseSession
+	if err := web.Decode(r, &cs); err != nil {
+		return errors.Wrap(err, "decoding drawer session close")
+	}
+
+	s, err := drawer.Close(ctx, d.db, claims, chi.URLParam(r, "id"), cs, time.Now())
+	if err != nil {
+		return drawerError(err, "closing drawer session")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusOK)
+}
+
+// drawerError maps the errors of the drawer package to web request errors.
+func drawerError(err error, msg string) error {
+
+	switch err {
+	case drawer.ErrNotFound, drawer.ErrNotOpen:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case drawer.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case drawer.ErrAlreadyOpen, drawer.ErrAlreadyClosed:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
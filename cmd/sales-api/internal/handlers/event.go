@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/event"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/devisions/garagesale/internal/product"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// EventHandlers has handler methods for dealing with garage sale Events.
+type EventHandlers struct {
+	db *sqlx.DB
+}
+
+// List gives all events as a list.
+func (e *EventHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := event.List(ctx, e.db, claims)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve gives a single Event.
+func (e *EventHandlers) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+	ev, err := event.Retrieve(ctx, e.db, claims, id)
+	if err != nil {
+		return eventError(err, "looking for event")
+	}
+
+	return web.Respond(ctx, w, ev, http.StatusOK)
+}
+
+// Create decodes a JSON from the POST request and create a new Event.
+func (e *EventHandlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var ne event.NewEvent
+	if err := web.Decode(r, &ne); err != nil {
+		return err
+	}
+
+	ev, err := event.Create(ctx, e.db, claims, ne, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, ev, http.StatusCreated)
+}
+
+// Update decodes the body of a request to update an existing event. The ID of
+// the event is part of the request URL.
+func (e *EventHandlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.Update")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var update event.UpdateEvent
+	if err := web.Decode(r, &update); err != nil {
+		return errors.Wrap(err, "decoding event update")
+	}
+
+	if err := event.Update(ctx, e.db, claims, id, update, time.Now()); err != nil {
+		return eventError(err, "updating event")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes a single event identified by an ID in the request URL.
+func (e *EventHandlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := event.Delete(ctx, e.db, claims, id); err != nil {
+		return eventError(err, "deleting event")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ListProducts gets the products of the organization of the user assigned to
+// a particular event.
+func (e *EventHandlers) ListProducts(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.ListProducts")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	ev, err := event.Retrieve(ctx, e.db, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return eventError(err, "looking for event")
+	}
+
+	list, err := product.ListByEvent(ctx, e.db, claims, ev.ID)
+	if err != nil {
+		return errors.Wrap(err, "getting event products")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// ListSales gets the sales of the organization of the user attributed to a
+// particular event.
+func (e *EventHandlers) ListSales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.ListSales")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	ev, err := event.Retrieve(ctx, e.db, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return eventError(err, "looking for event")
+	}
+
+	list, err := product.ListEventSales(ctx, e.db, claims, ev.ID)
+	if err != nil {
+		return errors.Wrap(err, "getting event sales")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Summary gives the totals of a particular event.
+func (e *EventHandlers) Summary(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.Summary")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	s, err := event.Summarize(ctx, e.db, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return eventError(err, "summarizing event")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusOK)
+}
+
+// eventError maps the errors of the event package to web request errors.
+func eventError(err error, msg string) error {
+
+	switch err {
+	case event.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case event.ErrInvalidID, event.ErrInvalidDates:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
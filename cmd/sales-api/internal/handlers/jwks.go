@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// jwksMaxAge tells how long clients may cache the published keys. Keys have
+// to be published for at least as long before tokens are signed with them.
+const jwksMaxAge = 5 * time.Minute
+
+// KeySet publishes the keys our tokens are verified with.
+type KeySet struct {
+	authenticator *auth.Authenticator
+}
+
+// JWKS responds with the public keys of the tokens we issue, as a JSON Web
+// Key Set, so other services can verify them.
+func (ks *KeySet) JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.KeySet.JWKS")
+	defer span.End()
+
+	set, err := ks.authenticator.JWKS()
+	if err != nil {
+		return errors.Wrap(err, "publishing keys")
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	return web.Respond(ctx, w, set, http.StatusOK)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/apikey"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// KeyHandlers has handler methods for dealing with API keys.
+type KeyHandlers struct {
+	db *sqlx.DB
+}
+
+// Create generates an API key for the authenticated user. The response holds
+// the secret of the key, which is never shown again.
+func (k *KeyHandlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Keys.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var nk apikey.NewKey
+	if err := web.Decode(r, &nk); err != nil {
+		return err
+	}
+
+	c, err := apikey.Create(ctx, k.db, claims, nk, time.Now())
+	if err != nil {
+		return keyError(err, "creating api key")
+	}
+
+	return web.Respond(ctx, w, c, http.StatusCreated)
+}
+
+// List gives the API keys of the authenticated user.
+func (k *KeyHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Keys.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	keys, err := apikey.List(ctx, k.db, claims.Subject)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, keys, http.StatusOK)
+}
+
+// Revoke makes the specified API key unusable.
+func (k *KeyHandlers) Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Keys.Revoke")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	if err := apikey.Revoke(ctx, k.db, claims, chi.URLParam(r, "id"), time.Now()); err != nil {
+		return keyError(err, "revoking api key")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// keyError maps the errors of the apikey package to web request errors.
+func keyError(err error, msg string) error {
+
+	switch err {
+	case apikey.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case apikey.ErrInvalidID, apikey.ErrInvalidRoles:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case apikey.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
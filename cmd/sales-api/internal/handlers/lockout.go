@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/lockout"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// LockoutHandlers has handler methods for dealing with the lockouts caused by
+// failed authentication attempts.
+type LockoutHandlers struct {
+	db *sqlx.DB
+}
+
+// List gives the accounts and client IPs for which authentication attempts
+// are currently refused. Admins of an organization only see its accounts.
+func (l *LockoutHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Lockouts.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := lockout.List(ctx, l.db, claims, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Clear forgets the failed authentication attempts of an account or client
+// IP, allowing new attempts right away.
+func (l *LockoutHandlers) Clear(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Lockouts.Clear")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	k := lockout.Key{Kind: chi.URLParam(r, "kind"), Subject: chi.URLParam(r, "subject")}
+	if k.Kind == lockout.KindAccount {
+		k = lockout.Account(k.Subject)
+	}
+
+	if err := lockout.Clear(ctx, l.db, claims, k); err != nil {
+		switch err {
+		case lockout.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrap(err, "clearing lockout")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/oidc"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/devisions/garagesale/internal/user"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// oidcCookie is the cookie keeping the login in progress with the identity
+// provider.
+const oidcCookie = "oidc_login"
+
+// oidcLoginTTL tells how long users have to log in at the identity provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// OIDCLogin starts logging a user in through the identity provider, where
+// they are redirected to. The secrets of the login are kept in a cookie until
+// the provider sends them back to OIDCCallback.
+func (u *UserHandlers) OIDCLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.OIDCLogin")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	expires := v.Start.Add(oidcLoginTTL)
+	l, err := oidc.NewLogin(expires)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    l.Encode(u.cfg.VerifyKey),
+		Path:     "/v1/users/oidc",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(u.cfg.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	v.StatusCode = http.StatusFound
+	http.Redirect(w, r, u.cfg.OIDC.AuthCodeURL(l), http.StatusFound)
+	return nil
+}
+
+// OIDCCallback completes the login of a user the identity provider sent
+// back with an authorization code. The user is linked to a local one, by
+// verified email, or gets a new one the first time. The response is the one
+// of Token.
+func (u *UserHandlers) OIDCCallback(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.OIDCCallback")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		err := errors.Errorf("identity provider refused the login: %s", e)
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	}
+
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return web.NewRequestError(oidc.ErrInvalidLogin, http.StatusUnauthorized)
+	}
+	l, err := oidc.ParseLogin(u.cfg.VerifyKey, c.Value, v.Start)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	}
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(l.State)) != 1 {
+		return web.NewRequestError(oidc.ErrInvalidLogin, http.StatusUnauthorized)
+	}
+
+	// Whatever happens next, the login cannot be completed twice.
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/v1/users/oidc", MaxAge: -1})
+
+	id, err := u.cfg.OIDC.Exchange(ctx, l, q.Get("code"), v.Start)
+	if err != nil {
+		switch errors.Cause(err) {
+		case oidc.ErrInvalidToken, oidc.ErrCodeRefused:
+			return web.NewRequestError(errors.Cause(err), http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "exchanging authorization code")
+		}
+	}
+
+	claims, err := user.Federate(ctx, u.db, u.cfg.PasswordPolicy, id, v.Start)
+	if err != nil {
+		switch err {
+		case user.ErrIdentityNotVerified:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case user.ErrAccountNotVerified:
+			return web.NewRequestError(err, http.StatusConflict)
+		}
+		return userError(err, "federating identity")
+	}
+
+	usr, err := user.Retrieve(ctx, u.db, claims, claims.Subject)
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	return u.completeLogin(ctx, w, r, claims, usr.Email, v.Start)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// OrgHandlers has handler methods for dealing with organizations.
+type OrgHandlers struct {
+	db *sqlx.DB
+}
+
+// List gives all organizations.
+func (o *OrgHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.List")
+	defer span.End()
+
+	list, err := org.List(ctx, o.db)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve gives a single organization.
+func (o *OrgHandlers) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.Retrieve")
+	defer span.End()
+
+	og, err := org.Retrieve(ctx, o.db, chi.URLParam(r, "id"))
+	if err != nil {
+		return orgError(err, "looking for organization")
+	}
+
+	return web.Respond(ctx, w, og, http.StatusOK)
+}
+
+// Create adds an organization. It looks for a JSON object with the name of
+// the organization in the request body.
+func (o *OrgHandlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.Create")
+	defer span.End()
+
+	var no org.NewOrg
+	if err := web.Decode(r, &no); err != nil {
+		return err
+	}
+
+	og, err := org.Create(ctx, o.db, no, time.Now())
+	if err != nil {
+		return orgError(err, "creating organization")
+	}
+
+	return web.Respond(ctx, w, og, http.StatusCreated)
+}
+
+// orgError maps the errors of the org package to web request errors.
+func orgError(err error, msg string) error {
+
+	switch err {
+	case org.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case org.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case org.ErrExists:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/payment"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// PaymentHandlers has handler methods for dealing with the payments of sales.
+type PaymentHandlers struct {
+	db *sqlx.DB
+}
+
+// Add records a payment for a particular sale. It looks for a JSON object in
+// the request body.
+func (p *PaymentHandlers) Add(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Payments.Add")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var np payment.NewPayment
+	if err := web.Decode(r, &np); err != nil {
+		return errors.Wrap(err, "decoding new payment")
+	}
+
+	pay, err := payment.Add(ctx, p.db, claims, chi.URLParam(r, "id"), np, time.Now())
+	if err != nil {
+		return paymentError(err, "adding new payment")
+	}
+
+	return web.Respond(ctx, w, pay, http.StatusCreated)
+}
+
+// List gets all payments for a particular sale.
+func (p *PaymentHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Payments.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	// Retrieving the balance first tells apart a sale without payments from
+	// one that does not exist.
+	if _, err := payment.RetrieveBalance(ctx, p.db, claims, id); err != nil {
+		return paymentError(err, "looking for sale")
+	}
+
+	list, err := payment.List(ctx, p.db, claims, id)
+	if err != nil {
+		return paymentError(err, "getting payments list")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Balance tells how much of a particular sale is paid.
+func (p *PaymentHandlers) Balance(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Payments.Balance")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	b, err := payment.RetrieveBalance(ctx, p.db, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return paymentError(err, "getting sale balance")
+	}
+
+	return web.Respond(ctx, w, b, http.StatusOK)
+}
+
+// Unpaid gives the balances of all sales that are not fully paid yet.
+func (p *PaymentHandlers) Unpaid(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Payments.Unpaid")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := payment.Unpaid(ctx, p.db, claims)
+	if err != nil {
+		return errors.Wrap(err, "getting unpaid balances")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// paymentError maps the errors of the payment package to web request errors.
+func paymentError(err error, msg string) error {
+
+	switch err {
+	case payment.ErrSaleNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case payment.ErrInvalidID, payment.ErrInvalidAmount, payment.ErrOverpayment:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
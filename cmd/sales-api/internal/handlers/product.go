@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/devisions/garagesale/internal/payment"
 	"github.com/devisions/garagesale/internal/platform/auth"
 	"github.com/devisions/garagesale/internal/platform/web"
 	"github.com/devisions/garagesale/internal/product"
@@ -15,19 +16,33 @@ import (
 	"go.opencensus.io/trace"
 )
 
-// ProductHandlers has handler methods for dealing with Products.
+// ProductHandlers has handler methods for dealing with Products. The products
+// of users that are not active are hidden from listings if hideInactive is
+// set, except for those who manage products.
 type ProductHandlers struct {
-	db  *sqlx.DB
-	log *log.Logger
+	db           *sqlx.DB
+	log          *log.Logger
+	hideInactive bool
 }
 
-// ListProducts gives all products as a list
+// ListProducts gives all products of the organization of the user as a list
 func (p *ProductHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Product.List")
 	defer span.End()
 
-	list, err := product.List(ctx, p.db)
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var list []product.Product
+	var err error
+	if p.hideInactive && !claims.HasPermission(auth.PermProductManage) {
+		list, err = product.ListActive(ctx, p.db, claims, time.Now())
+	} else {
+		list, err = product.List(ctx, p.db, claims)
+	}
 	if err != nil {
 		return err
 	}
@@ -41,8 +56,13 @@ func (p *ProductHandlers) Retrieve(ctx context.Context, w http.ResponseWriter, r
 	ctx, span := trace.StartSpan(ctx, "handlers.Products.Retrieve")
 	defer span.End()
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
 	id := chi.URLParam(r, "id")
-	prod, err := product.Retrieve(ctx, p.db, id)
+	prod, err := product.Retrieve(ctx, p.db, claims, id)
 	if err != nil {
 		switch err {
 		case product.ErrNotFound:
@@ -74,7 +94,12 @@ func (p *ProductHandlers) Create(ctx context.Context, w http.ResponseWriter, r *
 
 	prod, err := product.Create(ctx, p.db, claims, np, time.Now())
 	if err != nil {
-		return err
+		switch err {
+		case product.ErrUnknownEvent:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "creating product")
+		}
 	}
 
 	return web.Respond(ctx, w, prod, http.StatusCreated)
@@ -103,7 +128,7 @@ func (p *ProductHandlers) Update(ctx context.Context, w http.ResponseWriter, r *
 		switch err {
 		case product.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
-		case product.ErrInvalidID:
+		case product.ErrInvalidID, product.ErrUnknownEvent:
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case product.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
@@ -121,9 +146,14 @@ func (p *ProductHandlers) Delete(ctx context.Context, w http.ResponseWriter, r *
 	ctx, span := trace.StartSpan(ctx, "handlers.Products.Delete")
 	defer span.End()
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
 	id := chi.URLParam(r, "id")
 
-	if err := product.Delete(ctx, p.db, id); err != nil {
+	if err := product.Delete(ctx, p.db, claims, id); err != nil {
 		switch err {
 		case product.ErrInvalidID:
 			return web.NewRequestError(err, http.StatusBadRequest)
@@ -142,6 +172,11 @@ func (p *ProductHandlers) AddSale(ctx context.Context, w http.ResponseWriter, r
 	ctx, span := trace.StartSpan(ctx, "handlers.Products.AddSale")
 	defer span.End()
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
 	var ns product.NewSale
 	if err := web.Decode(r, &ns); err != nil {
 		return errors.Wrap(err, "decoding new sale")
@@ -149,9 +184,16 @@ func (p *ProductHandlers) AddSale(ctx context.Context, w http.ResponseWriter, r
 
 	productID := chi.URLParam(r, "id")
 
-	sale, err := product.AddSale(ctx, p.db, ns, productID, time.Now())
+	sale, err := product.AddSale(ctx, p.db, claims, ns, productID, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "adding new sale")
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID, product.ErrUnknownCustomer, payment.ErrOverpayment:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "adding new sale")
+		}
 	}
 
 	return web.Respond(ctx, w, sale, http.StatusCreated)
@@ -163,12 +205,203 @@ func (p *ProductHandlers) ListSales(ctx context.Context, w http.ResponseWriter,
 	ctx, span := trace.StartSpan(ctx, "handlers.Products.ListSales")
 	defer span.End()
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
 	id := chi.URLParam(r, "id")
 
-	list, err := product.ListSales(ctx, p.db, id)
+	list, err := product.ListSales(ctx, p.db, claims, id)
 	if err != nil {
 		return errors.Wrap(err, "getting sales list")
 	}
 
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
+
+// ListMine gives the products the authenticated user is selling, with how
+// much of them was sold.
+func (p *ProductHandlers) ListMine(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.ListMine")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := product.ListByUser(ctx, p.db, claims)
+	if err != nil {
+		return errors.Wrap(err, "getting user products")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// ListMySales gives the sales of the products the authenticated user is
+// selling, latest first.
+func (p *ProductHandlers) ListMySales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.ListMySales")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := product.ListUserSales(ctx, p.db, claims)
+	if err != nil {
+		return errors.Wrap(err, "getting user sales")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// AddOffer records an offer of the authenticated user for a particular
+// product. It looks for a JSON object in the request body.
+func (p *ProductHandlers) AddOffer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.AddOffer")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var no product.NewOffer
+	if err := web.Decode(r, &no); err != nil {
+		return errors.Wrap(err, "decoding new offer")
+	}
+
+	productID := chi.URLParam(r, "id")
+
+	offer, err := product.AddOffer(ctx, p.db, claims, no, productID, time.Now())
+	if err != nil {
+		return offerError(err, "adding new offer")
+	}
+
+	return web.Respond(ctx, w, offer, http.StatusCreated)
+}
+
+// ListOffers gets the offers for a particular product that the authenticated
+// user is allowed to see.
+func (p *ProductHandlers) ListOffers(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.ListOffers")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := product.ListOffers(ctx, p.db, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return offerError(err, "getting offers list")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RetrieveOffer gives a single offer of a particular product.
+func (p *ProductHandlers) RetrieveOffer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.RetrieveOffer")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	offer, err := product.RetrieveOffer(ctx, p.db, claims, chi.URLParam(r, "id"), chi.URLParam(r, "offer_id"))
+	if err != nil {
+		return offerError(err, "looking for offer")
+	}
+
+	return web.Respond(ctx, w, offer, http.StatusOK)
+}
+
+// AcceptOffer accepts an offer on behalf of the party whose turn it is. The
+// resulting sale is returned to the caller.
+func (p *ProductHandlers) AcceptOffer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.AcceptOffer")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	sale, err := product.AcceptOffer(ctx, p.db, claims, chi.URLParam(r, "id"), chi.URLParam(r, "offer_id"), time.Now())
+	if err != nil {
+		return offerError(err, "accepting offer")
+	}
+
+	return web.Respond(ctx, w, sale, http.StatusCreated)
+}
+
+// RejectOffer rejects an offer on behalf of the party whose turn it is.
+func (p *ProductHandlers) RejectOffer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.RejectOffer")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	if err := product.RejectOffer(ctx, p.db, claims, chi.URLParam(r, "id"), chi.URLParam(r, "offer_id"), time.Now()); err != nil {
+		return offerError(err, "rejecting offer")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// CounterOffer puts a new amount on the table on behalf of the party whose
+// turn it is. It looks for a JSON object in the request body.
+func (p *ProductHandlers) CounterOffer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.CounterOffer")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var nc product.NewCounter
+	if err := web.Decode(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding counter offer")
+	}
+
+	offer, err := product.CounterOffer(ctx, p.db, claims, chi.URLParam(r, "id"), chi.URLParam(r, "offer_id"), nc, time.Now())
+	if err != nil {
+		return offerError(err, "countering offer")
+	}
+
+	return web.Respond(ctx, w, offer, http.StatusOK)
+}
+
+// offerError maps the errors of the offer workflow to web request errors.
+func offerError(err error, msg string) error {
+
+	switch err {
+	case product.ErrNotFound, product.ErrOfferNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case product.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case product.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case product.ErrOfferClosed:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
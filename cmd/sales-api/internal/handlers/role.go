@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/devisions/garagesale/internal/role"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// RoleHandlers has handler methods for dealing with roles and the permissions
+// they grant.
+type RoleHandlers struct {
+	db *sqlx.DB
+}
+
+// List gives all roles.
+func (rh *RoleHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Roles.List")
+	defer span.End()
+
+	list, err := role.List(ctx, rh.db)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve gives a single role.
+func (rh *RoleHandlers) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Roles.Retrieve")
+	defer span.End()
+
+	ro, err := role.Retrieve(ctx, rh.db, chi.URLParam(r, "name"))
+	if err != nil {
+		return roleError(err, "looking for role")
+	}
+
+	return web.Respond(ctx, w, ro, http.StatusOK)
+}
+
+// Create adds a role. It looks for a JSON object with the name, description
+// and permissions of the role in the request body.
+func (rh *RoleHandlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Roles.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var nr role.NewRole
+	if err := web.Decode(r, &nr); err != nil {
+		return err
+	}
+
+	ro, err := role.Create(ctx, rh.db, claims, nr, time.Now())
+	if err != nil {
+		return roleError(err, "creating role")
+	}
+
+	return web.Respond(ctx, w, ro, http.StatusCreated)
+}
+
+// Update modifies the description or the permissions of a role.
+func (rh *RoleHandlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Roles.Update")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var ur role.UpdateRole
+	if err := web.Decode(r, &ur); err != nil {
+		return err
+	}
+
+	if err := role.Update(ctx, rh.db, claims, chi.URLParam(r, "name"), ur, time.Now()); err != nil {
+		return roleError(err, "updating role")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes a role no user has.
+func (rh *RoleHandlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Roles.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	if err := role.Delete(ctx, rh.db, claims, chi.URLParam(r, "name")); err != nil {
+		return roleError(err, "deleting role")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// roleError maps the errors of the role package to web request errors.
+func roleError(err error, msg string) error {
+
+	switch err {
+	case role.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case role.ErrUnknownPermission:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case role.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case role.ErrExists, role.ErrInUse, role.ErrBuiltIn:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -1,18 +1,83 @@
 package handlers
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/devisions/garagesale/internal/activity"
+	"github.com/devisions/garagesale/internal/apikey"
+	"github.com/devisions/garagesale/internal/lockout"
 	"github.com/devisions/garagesale/internal/middleware"
 	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/mail"
+	"github.com/devisions/garagesale/internal/platform/oidc"
 	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/devisions/garagesale/internal/session"
+	"github.com/devisions/garagesale/internal/user"
 	"github.com/jmoiron/sqlx"
 )
 
+// Config holds the settings and collaborators of the API besides its
+// database, authenticator and logger.
+type Config struct {
+
+	// PublicURL is where clients reach the API. It is used to build the links
+	// sent by email.
+	PublicURL string
+
+	// Mailer sends the emails of the API.
+	Mailer mail.Mailer
+
+	// VerifyKey signs the email verification links and VerifyTTL tells how
+	// long they stay valid.
+	VerifyKey []byte
+	VerifyTTL time.Duration
+
+	// ResetTTL tells how long password reset tokens stay valid.
+	ResetTTL time.Duration
+
+	// PasswordPolicy holds the rules passwords have to follow.
+	PasswordPolicy user.Policy
+
+	// Lockout tells how failed authentication attempts are penalized.
+	Lockout lockout.Policy
+
+	// RefreshTTL tells how long refresh tokens stay valid.
+	RefreshTTL time.Duration
+
+	// TwoFactorIssuer names the API in authenticator apps. ChallengeTTL tells
+	// how long users have to give their second factor after their password,
+	// the challenge being signed with VerifyKey.
+	TwoFactorIssuer string
+	ChallengeTTL    time.Duration
+
+	// RequireAdminTwoFactor denies the roles granting admin permissions to
+	// users without two factor authentication enabled.
+	RequireAdminTwoFactor bool
+
+	// ImpersonationTTL tells how long the tokens of admins acting as another
+	// user stay valid.
+	ImpersonationTTL time.Duration
+
+	// StatusCacheTTL tells how long the status of a user is trusted before
+	// looking it up again, which is how long tokens of users that get
+	// suspended on another instance keep working.
+	StatusCacheTTL time.Duration
+
+	// HideInactiveProducts leaves the products of suspended or deactivated
+	// users out of listings.
+	HideInactiveProducts bool
+
+	// OIDC is the OpenID Connect provider users may log in with, if any. The
+	// logins in progress are signed with VerifyKey.
+	OIDC *oidc.Provider
+}
+
 // API constructs a handler that knows about all API routes.
-func API(db *sqlx.DB, authenticator *auth.Authenticator, logger *log.Logger, shutdown chan os.Signal) http.Handler {
+func API(db *sqlx.DB, authenticator *auth.Authenticator, logger *log.Logger, shutdown chan os.Signal, cfg Config) http.Handler {
 
 	app := web.NewApp(logger, shutdown,
 		middleware.RequestLogger(logger),
@@ -21,24 +86,169 @@ func API(db *sqlx.DB, authenticator *auth.Authenticator, logger *log.Logger, shu
 		middleware.Panics(),
 	)
 
+	// authenticate accepts the requests with a token that was not revoked, of
+	// a user still active, or with a valid API key, recording when their user
+	// was last seen unless someone else acts as them. API keys are restricted
+	// like tokens when admins need two factor authentication.
+	revoked := func(ctx context.Context, jti string) (bool, error) {
+		return session.Revoked(ctx, db, jti)
+	}
+	apiKey := func(ctx context.Context, key string) (auth.Claims, error) {
+		claims, err := apikey.Authenticate(ctx, db, key, time.Now())
+		if err == apikey.ErrInvalidKey {
+			return auth.Claims{}, web.NewRequestError(err, http.StatusUnauthorized)
+		}
+		if err != nil || !cfg.RequireAdminTwoFactor {
+			return claims, err
+		}
+		return user.RestrictClaims(ctx, db, claims)
+	}
+	seen := func(ctx context.Context, claims auth.Claims, r *http.Request) error {
+		if claims.Impersonated() {
+			return nil
+		}
+		return activity.Seen(ctx, db, claims.Subject, clientIP(r), time.Now())
+	}
+	statuses := user.NewStatusCache(db, cfg.StatusCacheTTL)
+	active := func(ctx context.Context, userID string) (bool, error) {
+		return statuses.Active(ctx, userID, time.Now())
+	}
+	authn, track := middleware.Authenticate(authenticator, revoked, active, apiKey), middleware.TrackSeen(seen)
+	authenticate := func(next web.AppHandler) web.AppHandler {
+		return authn(track(next))
+	}
+
 	hc := HealthCheck{DB: db}
 
 	app.Handle(http.MethodGet, "/v1/health", hc.Health)
 
-	phs := ProductHandlers{db: db, log: logger}
+	ks := KeySet{authenticator: authenticator}
+
+	app.Handle(http.MethodGet, "/.well-known/jwks.json", ks.JWKS)
+
+	phs := ProductHandlers{db: db, log: logger, hideInactive: cfg.HideInactiveProducts}
 
-	uhs := UserHandlers{db: db, authenticator: authenticator}
+	uhs := UserHandlers{db: db, authenticator: authenticator, log: logger, cfg: cfg, statuses: statuses}
 
 	app.Handle(http.MethodGet, "/v1/users/token", uhs.Token)
+	app.Handle(http.MethodPost, "/v1/users/token/refresh", uhs.Refresh)
+	app.Handle(http.MethodPost, "/v1/users/token/2fa", uhs.TokenTwoFactor)
+	app.Handle(http.MethodPost, "/v1/users/logout", uhs.Logout, authenticate)
+	if cfg.OIDC != nil {
+		app.Handle(http.MethodGet, "/v1/users/oidc/login", uhs.OIDCLogin)
+		app.Handle(http.MethodGet, "/v1/users/oidc/callback", uhs.OIDCCallback)
+	}
+	app.Handle(http.MethodPost, "/v1/users/register", uhs.Register)
+	app.Handle(http.MethodGet, "/v1/users/verify", uhs.Verify)
+	app.Handle(http.MethodPost, "/v1/users/password/forgot", uhs.PasswordForgot)
+	app.Handle(http.MethodPost, "/v1/users/password/reset", uhs.PasswordReset)
+	app.Handle(http.MethodGet, "/v1/users/me", uhs.Me, authenticate)
+	app.Handle(http.MethodPut, "/v1/users/me", uhs.UpdateMe, authenticate, middleware.NotImpersonated())
+	app.Handle(http.MethodGet, "/v1/users/me/products", phs.ListMine, authenticate)
+	app.Handle(http.MethodGet, "/v1/users/me/sales", phs.ListMySales, authenticate)
+	app.Handle(http.MethodGet, "/v1/users/me/activity", uhs.MyActivity, authenticate)
+	app.Handle(http.MethodPut, "/v1/users/me/password", uhs.ChangePassword, authenticate, middleware.NotImpersonated())
+	app.Handle(http.MethodPost, "/v1/users/me/2fa", uhs.EnrollTwoFactor, authenticate, middleware.NotImpersonated())
+	app.Handle(http.MethodPost, "/v1/users/me/2fa/confirm", uhs.ConfirmTwoFactor, authenticate, middleware.NotImpersonated())
+	app.Handle(http.MethodDelete, "/v1/users/me/2fa", uhs.DisableTwoFactor, authenticate, middleware.NotImpersonated())
+
+	app.Handle(http.MethodGet, "/v1/users", uhs.List, authenticate, middleware.RequirePermission(auth.PermUserRead))
+	app.Handle(http.MethodPost, "/v1/users", uhs.Create, authenticate, middleware.RequirePermission(auth.PermUserWrite))
+	app.Handle(http.MethodGet, "/v1/users/{id}", uhs.Retrieve, authenticate, middleware.RequirePermission(auth.PermUserRead))
+	app.Handle(http.MethodPut, "/v1/users/{id}", uhs.Update, authenticate, middleware.RequirePermission(auth.PermUserWrite))
+	app.Handle(http.MethodDelete, "/v1/users/{id}", uhs.Delete, authenticate, middleware.RequirePermission(auth.PermUserWrite))
+	app.Handle(http.MethodGet, "/v1/users/{id}/activity", uhs.Activity, authenticate, middleware.RequirePermission(auth.PermUserRead))
+	app.Handle(http.MethodPut, "/v1/users/{id}/status", uhs.SetStatus, authenticate, middleware.RequirePermission(auth.PermUserWrite))
+	app.Handle(http.MethodPost, "/v1/users/{id}/impersonate", uhs.Impersonate, authenticate, middleware.RequirePermission(auth.PermUserImpersonate))
+	app.Handle(http.MethodGet, "/v1/users/{id}/impersonations", uhs.ListImpersonations, authenticate, middleware.RequirePermission(auth.PermUserRead))
+	app.Handle(http.MethodPost, "/v1/users/{id}/revoke", uhs.Revoke, authenticate, middleware.RequirePermission(auth.PermUserWrite))
+
+	app.Handle(http.MethodGet, "/v1/products", phs.List, authenticate)
+	app.Handle(http.MethodPost, "/v1/products", phs.Create, authenticate)
+	app.Handle(http.MethodGet, "/v1/products/{id}", phs.Retrieve, authenticate)
+	app.Handle(http.MethodPut, "/v1/products/{id}", phs.Update, authenticate)
+	app.Handle(http.MethodDelete, "/v1/products/{id}", phs.Delete, authenticate, middleware.RequirePermission(auth.PermProductDelete))
+
+	app.Handle(http.MethodPost, "/v1/products/{id}/sales", phs.AddSale, authenticate, middleware.RequirePermission(auth.PermSaleCreate))
+	app.Handle(http.MethodGet, "/v1/products/{id}/sales", phs.ListSales, authenticate)
+
+	app.Handle(http.MethodPost, "/v1/products/{id}/offers", phs.AddOffer, authenticate)
+	app.Handle(http.MethodGet, "/v1/products/{id}/offers", phs.ListOffers, authenticate)
+	app.Handle(http.MethodGet, "/v1/products/{id}/offers/{offer_id}", phs.RetrieveOffer, authenticate)
+	app.Handle(http.MethodPost, "/v1/products/{id}/offers/{offer_id}/accept", phs.AcceptOffer, authenticate)
+	app.Handle(http.MethodPost, "/v1/products/{id}/offers/{offer_id}/reject", phs.RejectOffer, authenticate)
+	app.Handle(http.MethodPost, "/v1/products/{id}/offers/{offer_id}/counter", phs.CounterOffer, authenticate)
+
+	chs := CustomerHandlers{db: db}
+
+	app.Handle(http.MethodGet, "/v1/customers", chs.List, authenticate)
+	app.Handle(http.MethodPost, "/v1/customers", chs.Create, authenticate)
+	app.Handle(http.MethodGet, "/v1/customers/{id}", chs.Retrieve, authenticate)
+	app.Handle(http.MethodPut, "/v1/customers/{id}", chs.Update, authenticate)
+	app.Handle(http.MethodDelete, "/v1/customers/{id}", chs.Delete, authenticate, middleware.RequirePermission(auth.PermCustomerDelete))
+	app.Handle(http.MethodGet, "/v1/customers/{id}/purchases", chs.ListPurchases, authenticate)
+
+	ths := TaxHandlers{db: db}
+
+	app.Handle(http.MethodGet, "/v1/taxes", ths.List, authenticate)
+	app.Handle(http.MethodPost, "/v1/taxes", ths.Create, authenticate, middleware.RequirePermission(auth.PermTaxWrite))
+	app.Handle(http.MethodGet, "/v1/taxes/{id}", ths.Retrieve, authenticate)
+	app.Handle(http.MethodPut, "/v1/taxes/{id}", ths.Update, authenticate, middleware.RequirePermission(auth.PermTaxWrite))
+	app.Handle(http.MethodDelete, "/v1/taxes/{id}", ths.Delete, authenticate, middleware.RequirePermission(auth.PermTaxWrite))
+
+	app.Handle(http.MethodGet, "/v1/reports/tax", ths.Report, authenticate, middleware.RequirePermission(auth.PermReportRead))
+
+	pms := PaymentHandlers{db: db}
+
+	app.Handle(http.MethodPost, "/v1/sales/{id}/payments", pms.Add, authenticate, middleware.RequirePermission(auth.PermPaymentCreate))
+	app.Handle(http.MethodGet, "/v1/sales/{id}/payments", pms.List, authenticate)
+	app.Handle(http.MethodGet, "/v1/sales/{id}/balance", pms.Balance, authenticate)
+
+	app.Handle(http.MethodGet, "/v1/reports/unpaid", pms.Unpaid, authenticate, middleware.RequirePermission(auth.PermReportRead))
+
+	dhs := DrawerHandlers{db: db}
+
+	app.Handle(http.MethodPost, "/v1/drawers", dhs.Open, authenticate, middleware.RequirePermission(auth.PermDrawerManage))
+	app.Handle(http.MethodGet, "/v1/drawers", dhs.List, authenticate, middleware.RequirePermission(auth.PermDrawerManage))
+	app.Handle(http.MethodGet, "/v1/drawers/current", dhs.Current, authenticate, middleware.RequirePermission(auth.PermDrawerManage))
+	app.Handle(http.MethodGet, "/v1/drawers/{id}", dhs.Retrieve, authenticate, middleware.RequirePermission(auth.PermDrawerManage))
+	app.Handle(http.MethodPost, "/v1/drawers/{id}/close", dhs.Close, authenticate, middleware.RequirePermission(auth.PermDrawerManage))
+
+	khs := KeyHandlers{db: db}
+
+	app.Handle(http.MethodPost, "/v1/keys", khs.Create, authenticate, middleware.NotImpersonated(), middleware.NotAPIKey())
+	app.Handle(http.MethodGet, "/v1/keys", khs.List, authenticate)
+	app.Handle(http.MethodDelete, "/v1/keys/{id}", khs.Revoke, authenticate, middleware.NotImpersonated())
+
+	rhs := RoleHandlers{db: db}
+
+	app.Handle(http.MethodGet, "/v1/roles", rhs.List, authenticate, middleware.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodPost, "/v1/roles", rhs.Create, authenticate, middleware.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodGet, "/v1/roles/{name}", rhs.Retrieve, authenticate, middleware.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodPut, "/v1/roles/{name}", rhs.Update, authenticate, middleware.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodDelete, "/v1/roles/{name}", rhs.Delete, authenticate, middleware.RequirePermission(auth.PermRoleManage))
+
+	ohs := OrgHandlers{db: db}
+
+	app.Handle(http.MethodGet, "/v1/orgs", ohs.List, authenticate, middleware.RequirePermission(auth.PermOrgManage))
+	app.Handle(http.MethodPost, "/v1/orgs", ohs.Create, authenticate, middleware.RequirePermission(auth.PermOrgManage))
+	app.Handle(http.MethodGet, "/v1/orgs/{id}", ohs.Retrieve, authenticate, middleware.RequirePermission(auth.PermOrgManage))
+
+	lhs := LockoutHandlers{db: db}
+
+	app.Handle(http.MethodGet, "/v1/lockouts", lhs.List, authenticate, middleware.RequirePermission(auth.PermLockoutManage))
+	app.Handle(http.MethodDelete, "/v1/lockouts/{kind}/{subject}", lhs.Clear, authenticate, middleware.RequirePermission(auth.PermLockoutManage))
 
-	app.Handle(http.MethodGet, "/v1/products", phs.List, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodPost, "/v1/products", phs.Create, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodGet, "/v1/products/{id}", phs.Retrieve, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodPut, "/v1/products/{id}", phs.Update, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodDelete, "/v1/products/{id}", phs.Delete, middleware.Authenticate(authenticator), middleware.HasRole(auth.RoleAdmin))
+	ehs := EventHandlers{db: db}
 
-	app.Handle(http.MethodPost, "/v1/products/{id}/sales", phs.AddSale, middleware.Authenticate(authenticator), middleware.HasRole(auth.RoleAdmin))
-	app.Handle(http.MethodGet, "/v1/products/{id}/sales", phs.ListSales, middleware.Authenticate(authenticator))
+	app.Handle(http.MethodGet, "/v1/events", ehs.List, authenticate)
+	app.Handle(http.MethodPost, "/v1/events", ehs.Create, authenticate, middleware.RequirePermission(auth.PermEventWrite))
+	app.Handle(http.MethodGet, "/v1/events/{id}", ehs.Retrieve, authenticate)
+	app.Handle(http.MethodPut, "/v1/events/{id}", ehs.Update, authenticate, middleware.RequirePermission(auth.PermEventWrite))
+	app.Handle(http.MethodDelete, "/v1/events/{id}", ehs.Delete, authenticate, middleware.RequirePermission(auth.PermEventWrite))
+	app.Handle(http.MethodGet, "/v1/events/{id}/products", ehs.ListProducts, authenticate)
+	app.Handle(http.MethodGet, "/v1/events/{id}/sales", ehs.ListSales, authenticate)
+	app.Handle(http.MethodGet, "/v1/events/{id}/summary", ehs.Summary, authenticate)
 
 	return app
 }
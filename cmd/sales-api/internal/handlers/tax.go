@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/devisions/garagesale/internal/tax"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// TaxHandlers has handler methods for dealing with tax rates and reports.
+type TaxHandlers struct {
+	db *sqlx.DB
+}
+
+// List gives all tax rates as a list.
+func (t *TaxHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Taxes.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := tax.List(ctx, t.db, claims)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve gives a single tax rate.
+func (t *TaxHandlers) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Taxes.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+	rate, err := tax.Retrieve(ctx, t.db, claims, id)
+	if err != nil {
+		switch err {
+		case tax.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case tax.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for tax rate %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, rate, http.StatusOK)
+}
+
+// Create decodes a JSON from the POST request and create a new tax rate.
+func (t *TaxHandlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Taxes.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var nr tax.NewRate
+	if err := web.Decode(r, &nr); err != nil {
+		return err
+	}
+
+	rate, err := tax.Create(ctx, t.db, claims, nr, time.Now())
+	if err != nil {
+		switch err {
+		case tax.ErrUnknownEvent:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "creating tax rate")
+		}
+	}
+
+	return web.Respond(ctx, w, rate, http.StatusCreated)
+}
+
+// Update decodes the body of a request to update an existing tax rate. The ID
+// of the tax rate is part of the request URL.
+func (t *TaxHandlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Taxes.Update")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var update tax.UpdateRate
+	if err := web.Decode(r, &update); err != nil {
+		return errors.Wrap(err, "decoding tax rate update")
+	}
+
+	if err := tax.Update(ctx, t.db, claims, id, update, time.Now()); err != nil {
+		switch err {
+		case tax.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case tax.ErrInvalidID, tax.ErrUnknownEvent:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "updating tax rate %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes a single tax rate identified by an ID in the request URL.
+func (t *TaxHandlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Taxes.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := tax.Delete(ctx, t.db, claims, id); err != nil {
+		switch err {
+		case tax.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "deleting tax rate %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Report gives the tax collected per period. The optional `period` query
+// parameter is one of day (the default), week, month or year. The optional
+// `from` and `to` parameters are dates formatted as YYYY-MM-DD, both included,
+// and default to the last 30 days.
+func (t *TaxHandlers) Report(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Taxes.Report")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "day"
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -30)
+	if v := r.URL.Query().Get("from"); v != "" {
+		d, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return web.NewRequestError(errors.Wrap(err, "parsing from"), http.StatusBadRequest)
+		}
+		from = d
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		d, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return web.NewRequestError(errors.Wrap(err, "parsing to"), http.StatusBadRequest)
+		}
+
+		// The report ends before its upper bound, which is the day after.
+		to = d.AddDate(0, 0, 1)
+	}
+
+	totals, err := tax.Report(ctx, t.db, claims, period, from, to)
+	if err != nil {
+		switch err {
+		case tax.ErrInvalidPeriod:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "getting tax report")
+		}
+	}
+
+	return web.Respond(ctx, w, totals, http.StatusOK)
+}
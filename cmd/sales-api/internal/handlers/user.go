@@ -2,11 +2,25 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/devisions/garagesale/internal/activity"
+	"github.com/devisions/garagesale/internal/apikey"
+	"github.com/devisions/garagesale/internal/lockout"
 	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/mail"
 	"github.com/devisions/garagesale/internal/platform/web"
+	"github.com/devisions/garagesale/internal/role"
+	"github.com/devisions/garagesale/internal/session"
 	"github.com/devisions/garagesale/internal/user"
+	"github.com/go-chi/chi"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
@@ -16,11 +30,18 @@ import (
 type UserHandlers struct {
 	db            *sqlx.DB
 	authenticator *auth.Authenticator
+	log           *log.Logger
+	cfg           Config
+	statuses      *user.StatusCache
 }
 
 // Token generates an authentication token for a user. The client must include
 // an email and password for the request using HTTP Basic Auth. The user will
-// be identified by email and authenticated by their password.
+// be identified by email and authenticated by their password. Failed attempts
+// delay, and eventually lock out, further ones for the same account or from
+// the same client IP. Users with two factor authentication enabled get a
+// challenge instead, to be completed using TokenTwoFactor. Every attempt is
+// recorded in the activity of the account.
 func (u *UserHandlers) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Users.Token")
@@ -37,23 +58,1018 @@ func (u *UserHandlers) Token(ctx context.Context, w http.ResponseWriter, r *http
 		return web.NewRequestError(err, http.StatusUnauthorized)
 	}
 
-	claims, err := user.Authenticate(ctx, u.db, v.Start, email, pass)
+	// The attempt counts as failed until the password is known to be right.
+	keys := []lockout.Key{lockout.Account(email), lockout.IP(clientIP(r))}
+	if err := u.attempt(ctx, w, keys, v.Start); err != nil {
+		return u.recordFailedLogin(ctx, r, email, err, activity.ReasonLockedOut, v.Start)
+	}
+
+	claims, err := user.Authenticate(ctx, u.db, u.cfg.PasswordPolicy, v.Start, email, pass)
+	switch err {
+	case user.ErrAuthenticationFailure:
+		if err := lockout.Fail(ctx, u.db, u.cfg.Lockout, v.Start, keys...); err != nil {
+			return errors.Wrap(err, "recording failed attempt")
+		}
+		err = web.NewRequestError(err, http.StatusUnauthorized)
+		return u.recordFailedLogin(ctx, r, email, err, activity.ReasonInvalidCredentials, v.Start)
+	case nil, user.ErrNotVerified, user.ErrInactive:
+
+		// The password is right, even when the user cannot get in.
+		if err := lockout.Succeed(ctx, u.db, u.cfg.Lockout, v.Start, keys...); err != nil {
+			return errors.Wrap(err, "clearing failed attempts")
+		}
+	default:
+		return errors.Wrap(err, "authenticating")
+	}
+
+	switch err {
+	case user.ErrNotVerified:
+		err = web.NewRequestError(err, http.StatusForbidden)
+		return u.recordFailedLogin(ctx, r, email, err, activity.ReasonNotVerified, v.Start)
+	case user.ErrInactive:
+		err = web.NewRequestError(err, http.StatusForbidden)
+		return u.recordFailedLogin(ctx, r, email, err, activity.ReasonInactive, v.Start)
+	}
+
+	return u.completeLogin(ctx, w, r, claims, email, v.Start)
+}
+
+// completeLogin responds to a user who proved who they are with tokens, or
+// with a challenge to be completed using TokenTwoFactor when they have two
+// factor authentication enabled.
+func (u *UserHandlers) completeLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, claims auth.Claims, email string, now time.Time) error {
+
+	enabled, err := user.TwoFactorEnabled(ctx, u.db, claims.Subject)
+	if err != nil {
+		return errors.Wrap(err, "checking two factor authentication")
+	}
+	if enabled {
+		var ch struct {
+			Challenge string `json:"challenge"`
+		}
+		ch.Challenge = user.ChallengeToken(u.cfg.VerifyKey, claims.Subject, now.Add(u.cfg.ChallengeTTL))
+		return web.Respond(ctx, w, ch, http.StatusAccepted)
+	}
+
+	claims, err = u.restrictClaims(ctx, claims)
+	if err != nil {
+		return err
+	}
+
+	if err := u.recordLogin(ctx, r, email, "", now); err != nil {
+		return err
+	}
+
+	return u.respondTokens(ctx, w, claims, "", now)
+}
+
+// TokenTwoFactor completes the authentication of a user with two factor
+// authentication enabled. It looks for the challenge given by Token and a
+// TOTP or recovery code in the request body.
+func (u *UserHandlers) TokenTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.TokenTwoFactor")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	var ch user.Challenge
+	if err := web.Decode(r, &ch); err != nil {
+		return err
+	}
+
+	id, err := user.ParseChallenge(u.cfg.VerifyKey, ch.Token, v.Start)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	}
+	// The challenge proves who the user is, so they can get their claims.
+	claims, err := user.RefreshClaims(ctx, u.db, v.Start, id)
 	if err != nil {
+		return userError(err, "creating claims")
+	}
+	usr, err := user.Retrieve(ctx, u.db, claims, id)
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	// The attempt counts as failed until the code is known to be right.
+	keys := []lockout.Key{lockout.Account(usr.Email), lockout.IP(clientIP(r))}
+	if err := u.attempt(ctx, w, keys, v.Start); err != nil {
+		return u.recordFailedLogin(ctx, r, usr.Email, err, activity.ReasonLockedOut, v.Start)
+	}
+
+	if err := user.CheckSecondFactor(ctx, u.db, usr.ID, ch.Code, v.Start); err != nil {
 		switch err {
-		case user.ErrAuthenticationFailure:
+		case user.ErrInvalidCode:
+			if err := lockout.Fail(ctx, u.db, u.cfg.Lockout, v.Start, keys...); err != nil {
+				return errors.Wrap(err, "recording failed attempt")
+			}
+			err = web.NewRequestError(err, http.StatusUnauthorized)
+			return u.recordFailedLogin(ctx, r, usr.Email, err, activity.ReasonInvalidCode, v.Start)
+		default:
+			return userError(err, "checking second factor")
+		}
+	}
+
+	if err := lockout.Succeed(ctx, u.db, u.cfg.Lockout, v.Start, keys...); err != nil {
+		return errors.Wrap(err, "clearing failed attempts")
+	}
+
+	if err := u.recordLogin(ctx, r, usr.Email, "", v.Start); err != nil {
+		return err
+	}
+
+	return u.respondTokens(ctx, w, claims, "", v.Start)
+}
+
+// recordLogin adds a login attempt to the activity of the account with the
+// email, a successful one without a reason.
+func (u *UserHandlers) recordLogin(ctx context.Context, r *http.Request, email, reason string, now time.Time) error {
+
+	nl := activity.NewLogin{
+		Email:     email,
+		Reason:    reason,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if err := activity.RecordLogin(ctx, u.db, nl, now); err != nil {
+		return errors.Wrap(err, "recording login")
+	}
+	return nil
+}
+
+// recordFailedLogin records a login attempt refused with the request error
+// refusal, which it gives back. Other errors are returned as they are.
+func (u *UserHandlers) recordFailedLogin(ctx context.Context, r *http.Request, email string, refusal error, reason string, now time.Time) error {
+
+	if _, ok := errors.Cause(refusal).(*web.RequestError); !ok {
+		return refusal
+	}
+	if err := u.recordLogin(ctx, r, email, reason, now); err != nil {
+		return err
+	}
+	return refusal
+}
+
+// restrictClaims removes the roles granting admin permissions from the claims
+// of a user without two factor authentication, when the configuration
+// requires it for admins. Such users can still enroll and authenticate again.
+func (u *UserHandlers) restrictClaims(ctx context.Context, claims auth.Claims) (auth.Claims, error) {
+
+	if !u.cfg.RequireAdminTwoFactor {
+		return claims, nil
+	}
+	return user.RestrictClaims(ctx, u.db, claims)
+}
+
+// EnrollTwoFactor starts the two factor authentication enrollment of the
+// authenticated user. The response holds the TOTP secret and its
+// provisioning URI, along with recovery codes that are never shown again.
+func (u *UserHandlers) EnrollTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.EnrollTwoFactor")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	e, err := user.EnrollTwoFactor(ctx, u.db, claims.Subject, u.cfg.TwoFactorIssuer, time.Now())
+	if err != nil {
+		return userError(err, "enrolling in two factor authentication")
+	}
+
+	return web.Respond(ctx, w, e, http.StatusCreated)
+}
+
+// ConfirmTwoFactor enables two factor authentication for the authenticated
+// user, who must give a code of the secret they enrolled. Wrong codes count as
+// failed attempts, as they do in TokenTwoFactor.
+func (u *UserHandlers) ConfirmTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.ConfirmTwoFactor")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var sf user.SecondFactor
+	if err := web.Decode(r, &sf); err != nil {
+		return err
+	}
+
+	usr, err := user.Retrieve(ctx, u.db, claims, claims.Subject)
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	// The attempt counts as failed until the code is known to be right.
+	keys := []lockout.Key{lockout.Account(usr.Email), lockout.IP(clientIP(r))}
+	if err := u.attempt(ctx, w, keys, v.Start); err != nil {
+		return err
+	}
+
+	if err := user.ConfirmTwoFactor(ctx, u.db, usr.ID, sf.Code, v.Start); err != nil {
+		if err == user.ErrInvalidCode {
+			if err := lockout.Fail(ctx, u.db, u.cfg.Lockout, v.Start, keys...); err != nil {
+				return errors.Wrap(err, "recording failed attempt")
+			}
+		}
+		return userError(err, "confirming two factor authentication")
+	}
+
+	if err := lockout.Succeed(ctx, u.db, u.cfg.Lockout, v.Start, keys...); err != nil {
+		return errors.Wrap(err, "clearing failed attempts")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// DisableTwoFactor turns two factor authentication off for the authenticated
+// user, who must give a TOTP or recovery code. Wrong codes count as failed
+// attempts, as they do in TokenTwoFactor.
+func (u *UserHandlers) DisableTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.DisableTwoFactor")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var sf user.SecondFactor
+	if err := web.Decode(r, &sf); err != nil {
+		return err
+	}
+
+	usr, err := user.Retrieve(ctx, u.db, claims, claims.Subject)
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	// The attempt counts as failed until the code is known to be right.
+	keys := []lockout.Key{lockout.Account(usr.Email), lockout.IP(clientIP(r))}
+	if err := u.attempt(ctx, w, keys, v.Start); err != nil {
+		return err
+	}
+
+	if err := user.DisableTwoFactor(ctx, u.db, usr.ID, sf.Code, v.Start); err != nil {
+		if err == user.ErrInvalidCode {
+			if err := lockout.Fail(ctx, u.db, u.cfg.Lockout, v.Start, keys...); err != nil {
+				return errors.Wrap(err, "recording failed attempt")
+			}
+		}
+		return userError(err, "disabling two factor authentication")
+	}
+
+	if err := lockout.Succeed(ctx, u.db, u.cfg.Lockout, v.Start, keys...); err != nil {
+		return errors.Wrap(err, "clearing failed attempts")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Refresh generates a new authentication token, along with a new refresh
+// token, in exchange for a refresh token. Each refresh token can be used once.
+func (u *UserHandlers) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Refresh")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	var rf session.Refresh
+	if err := web.Decode(r, &rf); err != nil {
+		return err
+	}
+
+	rt, err := session.Use(ctx, u.db, rf.RefreshToken, v.Start)
+	if err != nil {
+		switch err {
+		case session.ErrInvalidToken:
 			return web.NewRequestError(err, http.StatusUnauthorized)
 		default:
-			return errors.Wrap(err, "authenticating")
+			return errors.Wrap(err, "using refresh token")
 		}
 	}
 
+	claims, err := user.RefreshClaims(ctx, u.db, v.Start, rt.UserID)
+	if err != nil {
+		switch err {
+		case user.ErrNotFound:
+			return web.NewRequestError(session.ErrInvalidToken, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "refreshing claims")
+		}
+	}
+
+	claims, err = u.restrictClaims(ctx, claims)
+	if err != nil {
+		return err
+	}
+
+	return u.respondTokens(ctx, w, claims, rt.FamilyID, v.Start)
+}
+
+// respondTokens responds with an authentication token for the claims and a
+// refresh token of the given family, or of a new one if empty.
+func (u *UserHandlers) respondTokens(ctx context.Context, w http.ResponseWriter, claims auth.Claims, familyID string, now time.Time) error {
+
 	var tkn struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
+
+	var err error
 	tkn.Token, err = u.authenticator.GenerateToken(claims)
 	if err != nil {
 		return errors.Wrap(err, "generating token")
 	}
 
+	tkn.RefreshToken, err = session.Issue(ctx, u.db, claims, familyID, u.cfg.RefreshTTL, now)
+	if err != nil {
+		return errors.Wrap(err, "issuing refresh token")
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// Logout revokes the authentication token of the request and the refresh
+// tokens issued along.
+func (u *UserHandlers) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Logout")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	if err := session.Logout(ctx, u.db, claims, time.Now()); err != nil {
+		return errors.Wrap(err, "logging out")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Revoke ends all the sessions of the specified user, revoking their refresh
+// tokens and the authentication tokens issued along.
+func (u *UserHandlers) Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Revoke")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	usr, err := user.Retrieve(ctx, u.db, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	if err := session.RevokeUser(ctx, u.db, usr.ID, time.Now()); err != nil {
+		return errors.Wrap(err, "revoking sessions")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// SetStatus suspends, deactivates or reactivates the specified user. Their
+// tokens stop working right away on this instance, and within the status
+// cache TTL on others.
+func (u *UserHandlers) SetStatus(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.SetStatus")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var sc user.StatusChange
+	if err := web.Decode(r, &sc); err != nil {
+		return err
+	}
+
+	usr, err := user.SetStatus(ctx, u.db, claims, chi.URLParam(r, "id"), sc, time.Now())
+	if err != nil {
+		return userError(err, "changing user status")
+	}
+	u.statuses.Forget(usr.ID)
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// Impersonate gives the authenticated admin a short lived token to act as the
+// specified user, seeing what they see without the permissions of any role.
+// The real actor is carried by the token and logged with every request.
+func (u *UserHandlers) Impersonate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Impersonate")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	imp, err := user.Impersonate(ctx, u.db, claims, chi.URLParam(r, "id"), v.Start, u.cfg.ImpersonationTTL)
+	if err != nil {
+		return userError(err, "impersonating user")
+	}
+
+	u.log.Printf("%s : impersonation : user %s acts as %s until %s", v.TraceID,
+		claims.Subject, imp.Subject, time.Unix(imp.ExpiresAt, 0).UTC().Format(time.RFC3339))
+
+	var tkn struct {
+		Token string `json:"token"`
+	}
+	tkn.Token, err = u.authenticator.GenerateToken(imp)
+	if err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+
 	return web.Respond(ctx, w, tkn, http.StatusOK)
 }
+
+// ListImpersonations returns the times the specified user was impersonated
+// and by whom.
+func (u *UserHandlers) ListImpersonations(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.ListImpersonations")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	usr, err := user.Retrieve(ctx, u.db, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	list, err := user.ListImpersonations(ctx, u.db, usr.ID)
+	if err != nil {
+		return errors.Wrap(err, "listing impersonations")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// List returns all the existing users in the system.
+func (u *UserHandlers) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	users, err := user.List(ctx, u.db, claims)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, users, http.StatusOK)
+}
+
+// Retrieve returns the specified user from the system.
+func (u *UserHandlers) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+	usr, err := user.Retrieve(ctx, u.db, claims, id)
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// Activity returns the latest login attempts of the specified user and when
+// they were last seen.
+func (u *UserHandlers) Activity(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Activity")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	// Looking for the user first keeps the ones of other tenants out of reach.
+	usr, err := user.Retrieve(ctx, u.db, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	a, err := activity.Retrieve(ctx, u.db, usr.ID)
+	if err != nil {
+		return errors.Wrap(err, "looking for activity")
+	}
+
+	return web.Respond(ctx, w, a, http.StatusOK)
+}
+
+// Create inserts a new user into the system.
+func (u *UserHandlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var nu user.NewUser
+	if err := web.Decode(r, &nu); err != nil {
+		return err
+	}
+
+	// Users join the organization of their creator, unless a user that may
+	// cross tenants says otherwise.
+	switch {
+	case nu.OrgID == "":
+		nu.OrgID = claims.OrgID
+	case nu.OrgID != claims.OrgID && claims.Tenant() != nil:
+		return web.NewRequestError(user.ErrForbidden, http.StatusForbidden)
+	}
+	if err := role.Grantable(ctx, u.db, claims, nu.Roles); err != nil {
+		return roleError(err, "checking roles")
+	}
+
+	usr, err := user.Create(ctx, u.db, u.cfg.PasswordPolicy, nu, time.Now())
+	if err != nil {
+		return userError(err, "creating user")
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusCreated)
+}
+
+// Update updates the specified user in the system. Setting a new password
+// ends all the sessions of the user and revokes their API keys.
+func (u *UserHandlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Update")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var upd user.UpdateUser
+	if err := web.Decode(r, &upd); err != nil {
+		return err
+	}
+	if err := role.Grantable(ctx, u.db, claims, upd.Roles); err != nil {
+		return roleError(err, "checking roles")
+	}
+
+	now := time.Now()
+	id := chi.URLParam(r, "id")
+	if err := user.Update(ctx, u.db, claims, u.cfg.PasswordPolicy, id, upd, now); err != nil {
+		return userError(err, "updating user")
+	}
+
+	if upd.Password != nil {
+		if err := u.revokeCredentials(ctx, id, now); err != nil {
+			return err
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes the specified user from the system.
+func (u *UserHandlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	// The tokens of the user are revoked first, as deleting the user would
+	// lose track of them.
+	usr, err := user.Retrieve(ctx, u.db, claims, id)
+	switch err {
+	case nil:
+		if err := session.RevokeUser(ctx, u.db, usr.ID, time.Now()); err != nil {
+			return errors.Wrap(err, "revoking sessions")
+		}
+	case user.ErrNotFound:
+	default:
+		return userError(err, "looking for user")
+	}
+
+	if err := user.Delete(ctx, u.db, claims, id); err != nil {
+		return userError(err, "deleting user")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Register signs up a new user with the USER role. The user has to follow the
+// verification link sent to their email before being able to get a token.
+// The response is the same whether the email is taken or not, so that it
+// cannot be used to find out which emails are in the system: the owner of a
+// taken email is told about the attempt instead.
+func (u *UserHandlers) Register(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Register")
+	defer span.End()
+
+	var nr user.NewRegistration
+	if err := web.Decode(r, &nr); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	usr, err := user.Register(ctx, u.db, u.cfg.PasswordPolicy, nr, now)
+	switch err {
+	case nil:
+
+		// The email is sent in the background, as in PasswordForgot, so that
+		// the time the response takes does not tell whether the email is
+		// taken. Without it the account could never be used, so let the user
+		// try again from scratch.
+		go func() {
+			if err := u.sendVerification(context.Background(), usr, now); err != nil {
+				u.log.Printf("sending verification to user %s: %v", usr.ID, err)
+				if err := user.Delete(context.Background(), u.db, auth.Claims{OrgID: usr.OrgID}, usr.ID); err != nil {
+					u.log.Printf("removing user %s after failing to send verification: %v", usr.ID, err)
+				}
+			}
+		}()
+
+	case user.ErrEmailTaken:
+		owner, err := user.RetrieveByEmail(ctx, u.db, nr.Email)
+		if err == user.ErrNotFound {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "looking for the owner of the email")
+		}
+
+		m := mail.Message{
+			To:      owner.Email,
+			Subject: "Someone tried to sign up with your email",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone tried to sign up with your email address, which already has an account. If it was you, log in with your password, or ask for a password reset if you forgot it. Otherwise, you can ignore this email.\n",
+				owner.Name),
+		}
+		go func() {
+			if err := u.cfg.Mailer.Send(context.Background(), m); err != nil {
+				u.log.Printf("telling user %s about a registration: %v", owner.ID, err)
+			}
+		}()
+
+	default:
+		return userError(err, "registering user")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusAccepted)
+}
+
+// Verify marks the user a verification link was sent to as verified. The
+// token of the link is expected as the `token` query parameter.
+func (u *UserHandlers) Verify(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Verify")
+	defer span.End()
+
+	if err := user.Verify(ctx, u.db, u.cfg.VerifyKey, r.URL.Query().Get("token"), time.Now()); err != nil {
+		switch err {
+		case user.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case user.ErrEmailTaken:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "verifying user")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// PasswordForgot sends a password reset token to the email of a user. The
+// response is the same whether the email is known or not, so that it cannot
+// be used to find out which emails are in the system.
+func (u *UserHandlers) PasswordForgot(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.PasswordForgot")
+	defer span.End()
+
+	var pf user.PasswordForgot
+	if err := web.Decode(r, &pf); err != nil {
+		return err
+	}
+
+	token, usr, err := user.RequestPasswordReset(ctx, u.db, pf.Email, u.cfg.ResetTTL, time.Now())
+	switch err {
+	case nil:
+		m := mail.Message{
+			To:      usr.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\nUse this token to reset your password:\n\n%s\n\nThe token expires in %v. If you did not ask for a password reset, you can ignore this email.\n",
+				usr.Name, token, u.cfg.ResetTTL),
+		}
+
+		// The email is sent in the background and a failure is only logged,
+		// so that neither the time the response takes nor its status tell
+		// whether the email is known.
+		go func() {
+			if err := u.cfg.Mailer.Send(context.Background(), m); err != nil {
+				u.log.Printf("sending password reset to user %s: %v", usr.ID, err)
+			}
+		}()
+	case user.ErrNotFound:
+	default:
+		return errors.Wrap(err, "requesting password reset")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// PasswordReset sets a new password using a password reset token. All the
+// sessions and API keys of the user are revoked, as whoever got the password
+// before may still use them.
+func (u *UserHandlers) PasswordReset(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.PasswordReset")
+	defer span.End()
+
+	var pr user.PasswordReset
+	if err := web.Decode(r, &pr); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	id, err := user.ResetPassword(ctx, u.db, u.cfg.PasswordPolicy, pr.Token, pr.Password, now)
+	if err != nil {
+		switch err {
+		case user.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return userError(err, "resetting password")
+		}
+	}
+
+	if err := u.revokeCredentials(ctx, id, now); err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ChangePassword sets a new password for the authenticated user, who must
+// provide their current password as well. All the sessions and API keys of
+// the user are revoked, the current session included.
+func (u *UserHandlers) ChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.ChangePassword")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var pc user.PasswordChange
+	if err := web.Decode(r, &pc); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := user.ChangePassword(ctx, u.db, u.cfg.PasswordPolicy, claims.Subject, pc, now); err != nil {
+		switch err {
+		case user.ErrAuthenticationFailure:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return userError(err, "changing password")
+		}
+	}
+
+	if err := u.revokeCredentials(ctx, claims.Subject, now); err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// revokeCredentials ends all the sessions of a user and revokes their API
+// keys, after their password changed.
+func (u *UserHandlers) revokeCredentials(ctx context.Context, id string, now time.Time) error {
+
+	if err := session.RevokeUser(ctx, u.db, id, now); err != nil {
+		return errors.Wrap(err, "revoking sessions")
+	}
+	if err := apikey.RevokeUser(ctx, u.db, id, now); err != nil {
+		return errors.Wrap(err, "revoking api keys")
+	}
+	return nil
+}
+
+// Me returns the authenticated user.
+func (u *UserHandlers) Me(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Me")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	usr, err := user.Retrieve(ctx, u.db, claims, claims.Subject)
+	if err != nil {
+		return userError(err, "looking for user")
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// MyActivity returns the latest login attempts of the authenticated user and
+// when they were last seen.
+func (u *UserHandlers) MyActivity(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.MyActivity")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	a, err := activity.Retrieve(ctx, u.db, claims.Subject)
+	if err != nil {
+		return errors.Wrap(err, "looking for activity")
+	}
+
+	return web.Respond(ctx, w, a, http.StatusOK)
+}
+
+// UpdateMe changes the name or the email of the authenticated user, who must
+// provide their current password to change the email. A new email is sent a
+// verification link and only replaces the current one once the link is
+// followed.
+func (u *UserHandlers) UpdateMe(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.UpdateMe")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var pc user.ProfileChange
+	if err := web.Decode(r, &pc); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	usr, err := user.ChangeProfile(ctx, u.db, claims.Subject, pc, now)
+	if err != nil {
+		switch err {
+		case user.ErrAuthenticationFailure:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return userError(err, "changing profile")
+		}
+	}
+
+	if pc.Email != nil && usr.PendingEmail != nil {
+		if err := u.sendVerification(ctx, usr, now); err != nil {
+			return errors.Wrap(err, "sending verification email")
+		}
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// sendVerification emails a verification link to the address the user has
+// yet to verify.
+func (u *UserHandlers) sendVerification(ctx context.Context, usr *user.User, now time.Time) error {
+
+	token := user.VerificationToken(u.cfg.VerifyKey, usr, now.Add(u.cfg.VerifyTTL))
+	link := u.cfg.PublicURL + "/v1/users/verify?token=" + url.QueryEscape(token)
+
+	to := usr.Email
+	if usr.PendingEmail != nil {
+		to = *usr.PendingEmail
+	}
+
+	m := mail.Message{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease verify your email address by following this link:\n\n%s\n\nThe link expires in %v.\n",
+			usr.Name, link, u.cfg.VerifyTTL),
+	}
+	return u.cfg.Mailer.Send(ctx, m)
+}
+
+// attempt reserves an authentication attempt for the keys, to be confirmed
+// with lockout.Fail once it failed or taken back with lockout.Succeed once it
+// succeeded. It refuses the request when attempts
+// for any of the keys are currently refused, telling the client when to try
+// again.
+func (u *UserHandlers) attempt(ctx context.Context, w http.ResponseWriter, keys []lockout.Key, now time.Time) error {
+
+	until, err := lockout.Attempt(ctx, u.db, u.cfg.Lockout, now, keys...)
+	if err != nil {
+		return errors.Wrap(err, "checking lockout")
+	}
+	if !until.IsZero() {
+		secs := int(math.Ceil(until.Sub(now).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		err := errors.New("too many failed attempts, try again later")
+		return web.NewRequestError(err, http.StatusTooManyRequests)
+	}
+	return nil
+}
+
+// clientIP gives the IP address the request comes from, which is the remote
+// address of the connection. Headers such as X-Forwarded-For are ignored as
+// any client can set them, so behind a reverse proxy every client has the
+// address of the proxy.
+func clientIP(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// userError maps the errors of the user package to web request errors.
+func userError(err error, msg string) error {
+
+	if pe, ok := err.(*user.PolicyError); ok {
+		fields := make([]web.FieldError, len(pe.Violations))
+		for i, v := range pe.Violations {
+			fields[i] = web.FieldError{Field: "password", Error: v}
+		}
+		return &web.RequestError{Err: err, Status: http.StatusBadRequest, Fields: fields}
+	}
+
+	switch err {
+	case user.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case user.ErrInvalidID, user.ErrUnknownOrg:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case user.ErrForbidden, user.ErrInactive:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case user.ErrEmailTaken, user.ErrTwoFactorEnabled, user.ErrTwoFactorDisabled:
+		return web.NewRequestError(err, http.StatusConflict)
+	case user.ErrInvalidCode:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -2,22 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/zipkin"
 	_ "expvar" // Register the /debug/vars handler.
 	"github.com/devisions/garagesale/cmd/sales-api/internal/handlers"
+	"github.com/devisions/garagesale/internal/lockout"
 	"github.com/devisions/garagesale/internal/platform/auth"
 	"github.com/devisions/garagesale/internal/platform/conf"
 	"github.com/devisions/garagesale/internal/platform/database"
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/devisions/garagesale/internal/platform/mail"
+	"github.com/devisions/garagesale/internal/platform/oidc"
+	"github.com/devisions/garagesale/internal/user"
 	openzipkin "github.com/openzipkin/zipkin-go"
 	zipkinHTTP "github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/pkg/errors"
@@ -39,9 +44,59 @@ func run() error {
 
 	var cfg struct {
 		Authn struct {
-			KeyID          string `conf:"default:1"`
-			PrivateKeyFile string `conf:"default:private.pem"`
-			Algorithm      string `conf:"default:RS256"`
+			KeyID                 string        `conf:"default:1"`
+			PrivateKeyFile        string        `conf:"default:private.pem"`
+			KeysDir               string        `conf:"help:directory of rotated signing keys used instead of the private key file"`
+			Algorithm             string        `conf:"default:RS256,help:RS256 ES256 ES384 or EdDSA matching the type of the keys"`
+			VerifySecret          string        `conf:"noprint"`
+			VerifyTTL             time.Duration `conf:"default:48h"`
+			ResetTTL              time.Duration `conf:"default:1h"`
+			RefreshTTL            time.Duration `conf:"default:720h"`
+			ChallengeTTL          time.Duration `conf:"default:5m,help:time to give the second factor after the password"`
+			TwoFactorIssuer       string        `conf:"default:Garage Sale"`
+			RequireAdminTwoFactor bool          `conf:"help:deny the roles granting admin permissions to users without two factor authentication"`
+			ImpersonationTTL      time.Duration `conf:"default:15m,help:lifetime of the tokens of admins acting as another user"`
+			StatusCacheTTL        time.Duration `conf:"default:30s,help:time the status of a user is cached for checking tokens"`
+		}
+		OIDC struct {
+			Issuer       string `conf:"help:issuer of the OpenID Connect provider users may log in with"`
+			ClientID     string
+			ClientSecret string `conf:"noprint"`
+			RedirectURL  string `conf:"help:defaults to /v1/users/oidc/callback at the public url"`
+			Scopes       string `conf:"default:email profile,help:space separated scopes to request besides openid"`
+		}
+		Password struct {
+			MinLength     int `conf:"default:8"`
+			RequireUpper  bool
+			RequireLower  bool
+			RequireDigit  bool
+			RequireSymbol bool
+			DenyListFile  string `conf:"help:file of passwords to refuse besides the most common ones"`
+			History       int    `conf:"default:5,help:number of previous passwords that cannot be reused"`
+			Hasher        string `conf:"default:bcrypt,help:algorithm hashing passwords: bcrypt or argon2id"`
+			BcryptCost    int    `conf:"default:10"`
+			ArgonTime     uint32 `conf:"default:3,help:argon2id passes over the memory"`
+			ArgonMemory   uint32 `conf:"default:65536,help:argon2id memory in KiB"`
+			ArgonThreads  uint8  `conf:"default:4"`
+		}
+		Lockout struct {
+			AccountThreshold int           `conf:"default:5,help:failed attempts locking an account"`
+			IPThreshold      int           `conf:"default:20,help:failed attempts locking a client IP"`
+			Delay            time.Duration `conf:"default:1s,help:delay after a first failed attempt, doubled for each further one"`
+			MaxDelay         time.Duration `conf:"default:30s"`
+			Duration         time.Duration `conf:"default:15m"`
+			Window           time.Duration `conf:"default:15m,help:time after which failed attempts are forgotten"`
+		}
+		Products struct {
+			HideInactive bool `conf:"help:hide the products of suspended or deactivated users from listings"`
+		}
+		Mail struct {
+			Sink         string `conf:"default:smtp,help:smtp or log"`
+			LogFile      string `conf:"help:file the log sink writes to instead of stdout"`
+			SMTPHost     string `conf:"default:localhost:25"`
+			SMTPUser     string
+			SMTPPassword string `conf:"noprint"`
+			From         string `conf:"default:garagesale@localhost"`
 		}
 		DB struct {
 			User       string `conf:"default:postgres"`
@@ -52,6 +107,7 @@ func run() error {
 		}
 		Web struct {
 			Address         string        `conf:"default:localhost:8000"`
+			PublicURL       string        `conf:"default:http://localhost:8000"`
 			DebugAddress    string        `conf:"default:localhost:6060"`
 			ReadTimeout     time.Duration `conf:"default:5s"`
 			WriteTimeout    time.Duration `conf:"default:5s"`
@@ -100,10 +156,35 @@ func run() error {
 	}
 	defer db.Close()
 
+	// -----------------------------------------------------------------------
+	// Password Policy
+
+	policy := user.Policy{
+		MinLength:     cfg.Password.MinLength,
+		RequireUpper:  cfg.Password.RequireUpper,
+		RequireLower:  cfg.Password.RequireLower,
+		RequireDigit:  cfg.Password.RequireDigit,
+		RequireSymbol: cfg.Password.RequireSymbol,
+		History:       cfg.Password.History,
+	}
+	if cfg.Password.DenyListFile != "" {
+		if policy.DenyList, err = user.ReadDenyList(cfg.Password.DenyListFile); err != nil {
+			return errors.Wrap(err, "setting up password policy")
+		}
+	}
+	policy.Hasher, err = user.NewPasswordHasher(cfg.Password.Hasher,
+		user.Bcrypt{Cost: cfg.Password.BcryptCost},
+		user.Argon2id{Time: cfg.Password.ArgonTime, Memory: cfg.Password.ArgonMemory, Threads: cfg.Password.ArgonThreads},
+	)
+	if err != nil {
+		return errors.Wrap(err, "setting up password policy")
+	}
+
 	// -----------------------------------------------------------------------
 	// Authentication Support
 
-	authenticator, err := createAuth(
+	authenticator, keys, err := createAuth(
+		cfg.Authn.KeysDir,
 		cfg.Authn.PrivateKeyFile,
 		cfg.Authn.KeyID,
 		cfg.Authn.Algorithm,
@@ -112,6 +193,71 @@ func run() error {
 		return errors.Wrap(err, "constructing authenticator")
 	}
 
+	// Rotated keys are picked up on SIGHUP. A directory that cannot be read
+	// leaves the keys as they were.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := keys.Reload(); err != nil {
+				log.Printf("main : Reloading signing keys : %v", err)
+				continue
+			}
+			log.Println("main : Signing keys reloaded")
+		}
+	}()
+
+	verifyKey := []byte(cfg.Authn.VerifySecret)
+	if len(verifyKey) == 0 {
+		log.Println("main : No verify secret configured, email verification links will not survive a restart")
+		verifyKey = make([]byte, 32)
+		if _, err := rand.Read(verifyKey); err != nil {
+			return errors.Wrap(err, "generating verify secret")
+		}
+	}
+
+	// -----------------------------------------------------------------------
+	// OpenID Connect Support
+
+	var provider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		redirectURL := cfg.OIDC.RedirectURL
+		if redirectURL == "" {
+			redirectURL = cfg.Web.PublicURL + "/v1/users/oidc/callback"
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err = oidc.Discover(ctx, &http.Client{Timeout: 10 * time.Second}, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       strings.Fields(cfg.OIDC.Scopes),
+		})
+		cancel()
+		if err != nil {
+			return errors.Wrap(err, "setting up openid connect")
+		}
+	}
+
+	// -----------------------------------------------------------------------
+	// Mail Support
+
+	mailer, closeMailer, err := createMailer(
+		cfg.Mail.Sink,
+		cfg.Mail.LogFile,
+		mail.SMTPConfig{
+			Host:     cfg.Mail.SMTPHost,
+			Username: cfg.Mail.SMTPUser,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		},
+	)
+	if err != nil {
+		return errors.Wrap(err, "constructing mailer")
+	}
+	defer closeMailer()
+
 	// -----------------------------------------------------------------------
 	// Start Tracing Support
 
@@ -145,8 +291,33 @@ func run() error {
 	signal.Notify(shutd, os.Interrupt, syscall.SIGTERM)
 
 	srv := http.Server{
-		Addr:         cfg.Web.Address,
-		Handler:      handlers.API(db, authenticator, log, shutd),
+		Addr: cfg.Web.Address,
+		Handler: handlers.API(db, authenticator, log, shutd, handlers.Config{
+			PublicURL:  cfg.Web.PublicURL,
+			Mailer:     mailer,
+			VerifyKey:  verifyKey,
+			VerifyTTL:  cfg.Authn.VerifyTTL,
+			ResetTTL:   cfg.Authn.ResetTTL,
+			RefreshTTL: cfg.Authn.RefreshTTL,
+
+			TwoFactorIssuer:       cfg.Authn.TwoFactorIssuer,
+			ChallengeTTL:          cfg.Authn.ChallengeTTL,
+			RequireAdminTwoFactor: cfg.Authn.RequireAdminTwoFactor,
+			ImpersonationTTL:      cfg.Authn.ImpersonationTTL,
+			StatusCacheTTL:        cfg.Authn.StatusCacheTTL,
+			HideInactiveProducts:  cfg.Products.HideInactive,
+			OIDC:                  provider,
+
+			PasswordPolicy: policy,
+			Lockout: lockout.Policy{
+				AccountThreshold: cfg.Lockout.AccountThreshold,
+				IPThreshold:      cfg.Lockout.IPThreshold,
+				Delay:            cfg.Lockout.Delay,
+				MaxDelay:         cfg.Lockout.MaxDelay,
+				Duration:         cfg.Lockout.Duration,
+				Window:           cfg.Lockout.Window,
+			},
+		}),
 		ReadTimeout:  cfg.Web.ReadTimeout,
 		WriteTimeout: cfg.Web.WriteTimeout,
 	}
@@ -189,20 +360,60 @@ func run() error {
 	return nil
 }
 
-func createAuth(privateKeyFile, keyID, algorithm string) (*auth.Authenticator, error) {
+// createAuth constructs the Authenticator with the keys of the directory, if
+// any, or else with the single private key of the file. The returned KeyStore
+// holds the keys, to be reloaded once rotated.
+func createAuth(keysDir, privateKeyFile, keyID, algorithm string) (*auth.Authenticator, *auth.KeyStore, error) {
 
-	keyContents, err := ioutil.ReadFile(privateKeyFile)
-	if err != nil {
-		return nil, errors.Wrap(err, "reading auth private key")
+	var keys *auth.KeyStore
+	if keysDir != "" {
+		var err error
+		if keys, err = auth.OpenKeyStore(keysDir, algorithm); err != nil {
+			return nil, nil, errors.Wrap(err, "reading auth keys")
+		}
+	} else {
+		keyContents, err := ioutil.ReadFile(privateKeyFile)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "reading auth private key")
+		}
+		key, err := auth.ParsePrivateKey(keyContents)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "parsing auth private key")
+		}
+		if keys, err = auth.NewKeyStore(algorithm, auth.SigningKey{ID: keyID, PrivateKey: key}); err != nil {
+			return nil, nil, err
+		}
 	}
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyContents)
+
+	a, err := auth.NewKeyStoreAuthenticator(keys)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing auth private key")
+		return nil, nil, err
 	}
 
-	pubKeyLookupFunc := auth.NewSimpleKeyLookupFunc(keyID, &key.PublicKey)
+	return a, keys, nil
+}
+
+// createMailer constructs the Mailer for the configured sink. The returned
+// function releases the resources of the Mailer.
+func createMailer(sink, logFile string, smtpCfg mail.SMTPConfig) (mail.Mailer, func() error, error) {
 
-	return auth.NewAuthenticator(key, keyID, algorithm, pubKeyLookupFunc)
+	switch sink {
+	case "smtp":
+		return mail.NewSMTP(smtpCfg), func() error { return nil }, nil
+
+	case "log":
+		if logFile == "" {
+			return mail.NewWriter(os.Stdout), func() error { return nil }, nil
+		}
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "opening mail log file")
+		}
+		return mail.NewWriter(f), f.Close, nil
+
+	default:
+		return nil, nil, errors.Errorf("unknown mail sink %q", sink)
+	}
 }
 
 func registerTracer(service, httpAddr, traceURL string, probability float64) (func() error, error) {
@@ -29,19 +29,22 @@ func TestProducts(t *testing.T) {
 
 	shutdown := make(chan os.Signal, 1)
 	tests := ProductTests{
-		app: handlers.API(test.DB, test.Authenticator, test.Log, shutdown),
+		app:        handlers.API(test.DB, test.Authenticator, test.Log, shutdown, handlers.Config{}),
+		adminToken: test.Token("admin@example.com", "gophers"),
 	}
 
 	t.Run("List", tests.List)
 	t.Run("CreateRequiresFields", tests.CreateRequiresFields)
 	t.Run("ProductCRUD", tests.ProductCRUD)
+	t.Run("TaxReport", tests.TaxReport)
 }
 
 // ProductTests holds methods for each product subtest. This type allows
 // passing dependencies for tests while still providing a convenient syntax
 // when subtests are registered.
 type ProductTests struct {
-	app http.Handler
+	app        http.Handler
+	adminToken string
 }
 
 func (p *ProductTests) List(t *testing.T) {
@@ -64,6 +67,8 @@ func (p *ProductTests) List(t *testing.T) {
 		{
 			"id":           "a2b0639f-2cc6-44b8-b97b-15d69dbb511e",
 			"name":         "Comic Books",
+			"category":     "",
+			"event_id":     nil,
 			"cost":         float64(50),
 			"quantity":     float64(42),
 			"revenue":      float64(350),
@@ -74,6 +79,8 @@ func (p *ProductTests) List(t *testing.T) {
 		{
 			"id":           "72f8b983-3eb4-48db-9ed0-e45cc6bd716b",
 			"name":         "McDonalds Toys",
+			"category":     "",
+			"event_id":     nil,
 			"cost":         float64(75),
 			"quantity":     float64(120),
 			"revenue":      float64(225),
@@ -139,6 +146,8 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 			"date_created": created["date_created"],
 			"date_updated": created["date_updated"],
 			"name":         "product0",
+			"category":     "",
+			"event_id":     nil,
 			"cost":         float64(55),
 			"quantity":     float64(6),
 			"sold":         float64(0),
@@ -173,3 +182,26 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 		}
 	}
 }
+
+// TaxReport ensures that the tax report includes the sales of the day it is
+// asked to end with.
+func (p *ProductTests) TaxReport(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/v1/reports/tax?from=2019-01-01&to=2019-01-01", nil)
+	req.Header.Set("Authorization", "Bearer "+p.adminToken)
+	resp := httptest.NewRecorder()
+
+	p.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting tax report: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	var totals []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&totals); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if len(totals) != 1 || totals[0]["sales"] != float64(3) {
+		t.Fatalf("expected the 3 sales of the day, got %v", totals)
+	}
+}
@@ -1,14 +1,28 @@
 package tests
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/devisions/garagesale/cmd/sales-api/internal/handlers"
+	"github.com/devisions/garagesale/internal/lockout"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/mail"
+	"github.com/devisions/garagesale/internal/platform/oidc"
+	"github.com/devisions/garagesale/internal/platform/oidc/oidctest"
+	"github.com/devisions/garagesale/internal/platform/totp"
 	"github.com/devisions/garagesale/internal/tests"
+	"github.com/devisions/garagesale/internal/user"
+	jwt "github.com/dgrijalva/jwt-go"
 )
 
 // TestUsers runs a series of tests to exercise User behavior.
@@ -19,19 +33,131 @@ func TestUsers(t *testing.T) {
 
 	shutdown := make(chan os.Signal, 1)
 
-	ut := UserTests{app: handlers.API(test.DB, test.Authenticator, test.Log, shutdown)}
+	stub, err := oidctest.NewProvider("garagesale", "secret")
+	if err != nil {
+		t.Fatalf("starting identity provider: %s", err)
+	}
+	defer stub.Close()
+	provider, err := oidc.Discover(context.Background(), http.DefaultClient, oidc.Config{
+		Issuer:       stub.Issuer(),
+		ClientID:     "garagesale",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8000/v1/users/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("discovering identity provider: %s", err)
+	}
+
+	var mails mailbox
+	cfg := handlers.Config{
+		PublicURL: "http://localhost:8000",
+		Mailer:    mail.NewWriter(&mails),
+		VerifyKey: []byte("test verify key"),
+		VerifyTTL: time.Hour,
+		ResetTTL:  time.Hour,
+
+		PasswordPolicy: user.Policy{History: 3},
+		Lockout:        lockout.Policy{AccountThreshold: 3, Duration: time.Hour, Window: time.Hour},
+		RefreshTTL:     time.Hour,
+
+		TwoFactorIssuer: "Garage Sale",
+		ChallengeTTL:    time.Minute,
+
+		ImpersonationTTL: 15 * time.Minute,
+		OIDC:             provider,
+	}
+
+	strict := cfg
+	strict.RequireAdminTwoFactor = true
+
+	ut := UserTests{
+		app:        handlers.API(test.DB, test.Authenticator, test.Log, shutdown, cfg),
+		strictApp:  handlers.API(test.DB, test.Authenticator, test.Log, shutdown, strict),
+		mails:      &mails,
+		userToken:  test.Token("user@example.com", "gophers"),
+		adminToken: test.Token("admin@example.com", "gophers"),
+		superToken: test.Token("superadmin@example.com", "gophers"),
+		stub:       stub,
+	}
 
 	t.Run("TokenRequireAuth", ut.TokenRequireAuth)
 	t.Run("TokenDenyUnknown", ut.TokenDenyUnknown)
 	t.Run("TokenDenyBadPassword", ut.TokenDenyBadPassword)
 	t.Run("TokenSuccess", ut.TokenSuccess)
+	t.Run("TokenLockout", ut.TokenLockout)
+	t.Run("RefreshAndLogout", ut.RefreshAndLogout)
+	t.Run("JWKS", ut.JWKS)
+	t.Run("APIKeys", ut.APIKeys)
+	t.Run("ListRequiresAdmin", ut.ListRequiresAdmin)
+	t.Run("CreateDuplicateEmail", ut.CreateDuplicateEmail)
+	t.Run("UserCRUD", ut.UserCRUD)
+	t.Run("Tenancy", ut.Tenancy)
+	t.Run("RegisterAndVerify", ut.RegisterAndVerify)
+	t.Run("Profile", ut.Profile)
+	t.Run("Activity", ut.Activity)
+	t.Run("OIDC", ut.OIDC)
+	t.Run("Impersonation", ut.Impersonation)
+	t.Run("Status", ut.Status)
+	t.Run("TwoFactor", ut.TwoFactor)
+	t.Run("AdminTwoFactorRequired", ut.AdminTwoFactorRequired)
+	t.Run("SuperAdminTwoFactorRequired", ut.SuperAdminTwoFactorRequired)
+	t.Run("PasswordReset", ut.PasswordReset)
+	t.Run("ChangePassword", ut.ChangePassword)
 }
 
 // UserTests holds methods for each user subtest. This type allows passing
 // dependencies for tests while still providing a convenient syntax when
 // subtests are registered.
 type UserTests struct {
-	app http.Handler
+	app        http.Handler
+	strictApp  http.Handler
+	mails      *mailbox
+	userToken  string
+	adminToken string
+	superToken string
+	stub       *oidctest.Provider
+}
+
+// mailbox holds the mails sent by the API, some of which are sent in the
+// background.
+type mailbox struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write adds to the mails.
+func (m *mailbox) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf.Write(p)
+}
+
+// String gives the mails sent so far.
+func (m *mailbox) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf.String()
+}
+
+// Reset forgets the mails sent so far.
+func (m *mailbox) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buf.Reset()
+}
+
+// Find gives the submatches of re in the mails, waiting a bit for a mail
+// sent in the background to match.
+func (m *mailbox) Find(t *testing.T, re *regexp.Regexp) []string {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if match := re.FindStringSubmatch(m.String()); match != nil {
+			return match
+		}
+	}
+	t.Fatalf("%s not found in mails:\n%s", re, m.String())
+	return nil
 }
 
 // TokenRequireAuth ensures that requests with no authentication are denied.
@@ -92,11 +218,1403 @@ func (ut *UserTests) TokenSuccess(t *testing.T) {
 		t.Fatalf("decoding: %s", err)
 	}
 
-	if len(got) != 1 {
+	if len(got) != 2 {
 		t.Error("unexpected values in token response")
 	}
 
 	if got["token"] == "" {
 		t.Fatal("token was not in response")
 	}
+	if got["refresh_token"] == "" {
+		t.Fatal("refresh token was not in response")
+	}
+}
+
+// RefreshAndLogout ensures that refresh tokens can be used once to get new
+// tokens, and that logging out revokes both kinds of tokens.
+func (ut *UserTests) RefreshAndLogout(t *testing.T) {
+
+	login := func() map[string]string {
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("user@example.com", "gophers")
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+
+		var got map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		return got
+	}
+
+	refresh := func(token string, want int) map[string]string {
+		body := strings.NewReader(`{"refresh_token":"` + token + `"}`)
+		req := httptest.NewRequest("POST", "/v1/users/token/refresh", body)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("refreshing: expected status code %v, got %v", want, resp.Code)
+		}
+
+		var got map[string]string
+		if want == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("decoding: %s", err)
+			}
+		}
+		return got
+	}
+
+	products := func(token string, want int) {
+		req := httptest.NewRequest("GET", "/v1/products", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("listing products: expected status code %v, got %v", want, resp.Code)
+		}
+	}
+
+	first := login()
+	second := refresh(first["refresh_token"], http.StatusOK)
+	products(second["token"], http.StatusOK)
+
+	// Using a refresh token twice revokes its whole family.
+	refresh(first["refresh_token"], http.StatusUnauthorized)
+	refresh(second["refresh_token"], http.StatusUnauthorized)
+	products(second["token"], http.StatusUnauthorized)
+
+	{ // LOGOUT
+		tkns := login()
+
+		req := httptest.NewRequest("POST", "/v1/users/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+tkns["token"])
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("logging out: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+
+		products(tkns["token"], http.StatusUnauthorized)
+		refresh(tkns["refresh_token"], http.StatusUnauthorized)
+	}
+}
+
+// JWKS ensures that the keys our tokens are verified with are published, so
+// other services verify them without our private key.
+func (ut *UserTests) JWKS(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting keys: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	if cc := resp.Header().Get("Cache-Control"); !strings.Contains(cc, "max-age=") {
+		t.Fatalf("expected the keys to be cacheable, got Cache-Control %q", cc)
+	}
+
+	var set auth.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if len(set.Keys) == 0 || set.Keys[0].Kty != "RSA" || set.Keys[0].Use != "sig" {
+		t.Fatalf("unexpected keys: %+v", set.Keys)
+	}
+
+	srv := httptest.NewServer(ut.app)
+	defer srv.Close()
+	lookup := auth.NewRemoteKeyLookupFunc(srv.Client(), srv.URL+"/.well-known/jwks.json")
+
+	keyFunc := func(tkn *jwt.Token) (interface{}, error) {
+		kid, _ := tkn.Header["kid"].(string)
+		return lookup(kid)
+	}
+	parser := jwt.Parser{ValidMethods: []string{"RS256"}}
+	if _, err := parser.Parse(ut.userToken, keyFunc); err != nil {
+		t.Fatalf("verifying token with the published keys: %s", err)
+	}
+}
+
+// TokenLockout ensures that an account gets locked after too many failed
+// attempts, until an admin clears the lockout. The account is unknown, so only
+// super admins see it.
+func (ut *UserTests) TokenLockout(t *testing.T) {
+
+	token := func(want int) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("locked@example.com", "guess")
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("getting token: expected status code %v, got %v", want, resp.Code)
+		}
+		return resp
+	}
+
+	for i := 0; i < 3; i++ {
+		token(http.StatusUnauthorized)
+	}
+	if resp := token(http.StatusTooManyRequests); resp.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+
+	{ // LIST
+		req := httptest.NewRequest("GET", "/v1/lockouts", nil)
+		req.Header.Set("Authorization", "Bearer "+ut.superToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("listing lockouts: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+
+		var list []map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if len(list) != 1 || list[0]["subject"] != "locked@example.com" {
+			t.Fatalf("expected the account to be locked, got %v", list)
+		}
+	}
+
+	{ // CLEAR
+		req := httptest.NewRequest("DELETE", "/v1/lockouts/account/locked@example.com", nil)
+		req.Header.Set("Authorization", "Bearer "+ut.superToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("clearing lockout: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+	}
+
+	token(http.StatusUnauthorized)
+}
+
+// APIKeys ensures that API keys authenticate with their roles, through either
+// header, until revoked.
+func (ut *UserTests) APIKeys(t *testing.T) {
+
+	var c struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+
+	{ // CREATE
+		body := strings.NewReader(`{"name":"POS tablet","roles":["USER"]}`)
+		req := httptest.NewRequest("POST", "/v1/keys", body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("creating key: expected status code %v, got %v", http.StatusCreated, resp.Code)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+	}
+
+	use := func(header, value, path string, want int) {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(header, value)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("%s with %s: expected status code %v, got %v", path, header, want, resp.Code)
+		}
+	}
+
+	use("X-API-Key", c.Secret, "/v1/products", http.StatusOK)
+	use("Authorization", "Bearer "+c.Secret, "/v1/products", http.StatusOK)
+
+	// The key only has the USER role.
+	use("X-API-Key", c.Secret, "/v1/users", http.StatusForbidden)
+
+	{ // Keys cannot create other keys.
+		body := strings.NewReader(`{"name":"Copy","roles":["USER"]}`)
+		req := httptest.NewRequest("POST", "/v1/keys", body)
+		req.Header.Set("X-API-Key", c.Secret)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusForbidden {
+			t.Fatalf("creating key with a key: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+		}
+	}
+
+	{ // REVOKE
+		req := httptest.NewRequest("DELETE", "/v1/keys/"+c.ID, nil)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("revoking key: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+	}
+
+	use("X-API-Key", c.Secret, "/v1/products", http.StatusUnauthorized)
+}
+
+// ListRequiresAdmin ensures that regular users cannot list the users.
+func (ut *UserTests) ListRequiresAdmin(t *testing.T) {
+	req := httptest.NewRequest("GET", "/v1/users", nil)
+	req.Header.Set("Authorization", "Bearer "+ut.userToken)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("getting: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+}
+
+// CreateDuplicateEmail ensures that a user cannot be created with the email of
+// an existing user.
+func (ut *UserTests) CreateDuplicateEmail(t *testing.T) {
+	body := strings.NewReader(`{"name":"Twin","email":"user@example.com","roles":["USER"],"password":"gophers","password_confirm":"gophers"}`)
+	req := httptest.NewRequest("POST", "/v1/users", body)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("posting: expected status code %v, got %v", http.StatusConflict, resp.Code)
+	}
+}
+
+// UserCRUD performs a complete test of CRUD against the api.
+func (ut *UserTests) UserCRUD(t *testing.T) {
+
+	var created map[string]interface{}
+
+	{ // CREATE with an invalid email
+		body := strings.NewReader(`{"name":"Bill","email":"bill","roles":["USER"],"password":"gophers","password_confirm":"gophers"}`)
+		req := httptest.NewRequest("POST", "/v1/users", body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("posting: expected status code %v, got %v", http.StatusBadRequest, resp.Code)
+		}
+	}
+
+	{ // CREATE
+		body := strings.NewReader(`{"name":"Bill","email":"bill@example.com","roles":["USER"],"password":"gophers","password_confirm":"gophers"}`)
+		req := httptest.NewRequest("POST", "/v1/users", body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("posting: expected status code %v, got %v", http.StatusCreated, resp.Code)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if _, ok := created["password_hash"]; ok {
+			t.Fatal("password hash must not be exposed")
+		}
+	}
+
+	url := "/v1/users/" + created["id"].(string)
+
+	{ // UPDATE without confirming the password
+		body := strings.NewReader(`{"password":"new gophers"}`)
+		req := httptest.NewRequest("PUT", url, body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("updating: expected status code %v, got %v", http.StatusBadRequest, resp.Code)
+		}
+	}
+
+	{ // UPDATE with an invalid email
+		body := strings.NewReader(`{"email":"bill"}`)
+		req := httptest.NewRequest("PUT", url, body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("updating: expected status code %v, got %v", http.StatusBadRequest, resp.Code)
+		}
+	}
+
+	{ // UPDATE the password, ending the sessions of the user
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("bill@example.com", "gophers")
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+		var tkns map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&tkns); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+
+		body := strings.NewReader(`{"password":"new gophers","password_confirm":"new gophers"}`)
+		req = httptest.NewRequest("PUT", url, body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("updating: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+
+		body = strings.NewReader(`{"refresh_token":"` + tkns["refresh_token"] + `"}`)
+		req = httptest.NewRequest("POST", "/v1/users/token/refresh", body)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusUnauthorized {
+			t.Fatalf("refreshing: expected status code %v, got %v", http.StatusUnauthorized, resp.Code)
+		}
+	}
+
+	{ // UPDATE
+		body := strings.NewReader(`{"name":"William"}`)
+		req := httptest.NewRequest("PUT", url, body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("updating: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+	}
+
+	{ // READ
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("retrieving: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+
+		var fetched map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if exp, got := "William", fetched["name"]; exp != got {
+			t.Fatalf("expected name %v, got %v", exp, got)
+		}
+		if exp, got := "bill@example.com", fetched["email"]; exp != got {
+			t.Fatalf("expected email %v, got %v", exp, got)
+		}
+	}
+
+	{ // DELETE
+		req := httptest.NewRequest("DELETE", url, nil)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("deleting: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+
+		req = httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNotFound {
+			t.Fatalf("retrieving: expected status code %v, got %v", http.StatusNotFound, resp.Code)
+		}
+	}
+}
+
+// Tenancy ensures that the users of an organization cannot reach the users and
+// products of another, and that only super admins manage organizations.
+func (ut *UserTests) Tenancy(t *testing.T) {
+
+	var scouts map[string]interface{}
+
+	{ // CREATE ORGANIZATION
+		req := httptest.NewRequest("POST", "/v1/orgs", strings.NewReader(`{"name":"Scouts"}`))
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusForbidden {
+			t.Fatalf("posting as admin: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+		}
+
+		req = httptest.NewRequest("POST", "/v1/orgs", strings.NewReader(`{"name":"Scouts"}`))
+		req.Header.Set("Authorization", "Bearer "+ut.superToken)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("posting: expected status code %v, got %v", http.StatusCreated, resp.Code)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&scouts); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+	}
+
+	body := `{"name":"Scout","email":"scout@example.com","roles":["ADMIN"],"org_id":"` + scouts["id"].(string) + `","password":"gophers","password_confirm":"gophers"}`
+
+	{ // CREATE USER
+		req := httptest.NewRequest("POST", "/v1/users", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusForbidden {
+			t.Fatalf("posting as admin: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+		}
+
+		req = httptest.NewRequest("POST", "/v1/users", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+ut.superToken)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("posting: expected status code %v, got %v", http.StatusCreated, resp.Code)
+		}
+	}
+
+	{ // GRANT SUPER ADMIN
+		body := strings.NewReader(`{"name":"Boss","email":"boss@example.com","roles":["SUPERADMIN"],"password":"gophers","password_confirm":"gophers"}`)
+		req := httptest.NewRequest("POST", "/v1/users", body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusForbidden {
+			t.Fatalf("posting: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("scout@example.com", "gophers")
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var tokens map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	// The seeded user and product belong to the default organization.
+	for _, url := range []string{"/v1/users/45b5fbd3-755f-4379-8f07-a58d4a30fa2f", "/v1/products/a2b0639f-2cc6-44b8-b97b-15d69dbb511e"} {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+tokens["token"])
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNotFound {
+			t.Fatalf("retrieving %s: expected status code %v, got %v", url, http.StatusNotFound, resp.Code)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/v1/products", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["token"])
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("listing products: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var products []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&products); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if len(products) != 0 {
+		t.Fatalf("expected no products of another organization, got %d", len(products))
+	}
+}
+
+// RegisterAndVerify ensures that a self registered user can only get a token
+// after following the link sent to their email, and that registering with a
+// taken email only tells its owner.
+func (ut *UserTests) RegisterAndVerify(t *testing.T) {
+
+	{ // REGISTER
+		body := strings.NewReader(`{"name":"Seller","email":"seller@example.com","password":"gophers","password_confirm":"gophers"}`)
+		req := httptest.NewRequest("POST", "/v1/users/register", body)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusAccepted {
+			t.Fatalf("registering: expected status code %v, got %v", http.StatusAccepted, resp.Code)
+		}
+	}
+
+	{ // REGISTER WITH A TAKEN EMAIL
+		body := strings.NewReader(`{"name":"Impostor","email":"user@example.com","password":"gophers","password_confirm":"gophers"}`)
+		req := httptest.NewRequest("POST", "/v1/users/register", body)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusAccepted {
+			t.Fatalf("registering: expected status code %v, got %v", http.StatusAccepted, resp.Code)
+		}
+		ut.mails.Find(t, regexp.MustCompile(`(?s)To: user@example.com.*Someone tried to sign up with your email`))
+	}
+
+	{ // TOKEN BEFORE VERIFICATION
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("seller@example.com", "gophers")
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusForbidden {
+			t.Fatalf("getting token: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+		}
+	}
+
+	{ // VERIFY
+		link := ut.mails.Find(t, regexp.MustCompile(`http://localhost:8000(/v1/users/verify\?token=\S+)`))
+
+		req := httptest.NewRequest("GET", link[1], nil)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("verifying: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+	}
+
+	{ // TOKEN AFTER VERIFICATION
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("seller@example.com", "gophers")
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+	}
+}
+
+// Profile ensures that users can see and change who they are, with a new
+// email only used once verified, and see what they sell.
+func (ut *UserTests) Profile(t *testing.T) {
+
+	ut.mails.Reset()
+
+	token := func(email string) string {
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth(email, "gophers")
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+		var tokens map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		return tokens["token"]
+	}
+	seller := token("seller@example.com")
+
+	{ // ME
+		req := httptest.NewRequest("GET", "/v1/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+seller)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("retrieving: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+		var me map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if exp, got := "seller@example.com", me["email"]; exp != got {
+			t.Fatalf("expected email %v, got %v", exp, got)
+		}
+		if roles, ok := me["roles"].([]interface{}); !ok || len(roles) != 1 || roles[0] != "USER" {
+			t.Fatalf("expected roles [USER], got %v", me["roles"])
+		}
+	}
+
+	{ // SELL
+		body := strings.NewReader(`{"name":"Lamp","cost":20,"quantity":2}`)
+		req := httptest.NewRequest("POST", "/v1/products", body)
+		req.Header.Set("Authorization", "Bearer "+seller)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("posting product: expected status code %v, got %v", http.StatusCreated, resp.Code)
+		}
+		var lamp map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&lamp); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+
+		body = strings.NewReader(`{"quantity":1,"paid":20}`)
+		req = httptest.NewRequest("POST", "/v1/products/"+lamp["id"].(string)+"/sales", body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("posting sale: expected status code %v, got %v", http.StatusCreated, resp.Code)
+		}
+	}
+
+	{ // MY PRODUCTS AND SALES
+		req := httptest.NewRequest("GET", "/v1/users/me/products", nil)
+		req.Header.Set("Authorization", "Bearer "+seller)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("listing products: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+		var products []map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&products); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if len(products) != 1 || products[0]["name"] != "Lamp" || products[0]["sold"] != float64(1) {
+			t.Fatalf("expected the sold lamp, got %v", products)
+		}
+
+		req = httptest.NewRequest("GET", "/v1/users/me/sales", nil)
+		req.Header.Set("Authorization", "Bearer "+seller)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("listing sales: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+		var sales []map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&sales); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if len(sales) != 1 || sales[0]["paid"] != float64(20) {
+			t.Fatalf("expected the lamp sale, got %v", sales)
+		}
+	}
+
+	{ // CHANGE EMAIL
+		tests := []struct {
+			name string
+			body string
+			want int
+		}{
+			{"NoPassword", `{"email":"top@example.com"}`, http.StatusBadRequest},
+			{"WrongPassword", `{"email":"top@example.com","current_password":"bad"}`, http.StatusForbidden},
+			{"Taken", `{"email":"user@example.com","current_password":"gophers"}`, http.StatusOK},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest("PUT", "/v1/users/me", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+seller)
+			resp := httptest.NewRecorder()
+
+			ut.app.ServeHTTP(resp, req)
+
+			if resp.Code != tt.want {
+				t.Fatalf("%s: expected status code %v, got %v", tt.name, tt.want, resp.Code)
+			}
+		}
+
+		// A taken email is only refused when verifying it.
+		link := ut.mails.Find(t, regexp.MustCompile(`http://localhost:8000(/v1/users/verify\?token=\S+)`))
+		req := httptest.NewRequest("GET", link[1], nil)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusConflict {
+			t.Fatalf("verifying a taken email: expected status code %v, got %v", http.StatusConflict, resp.Code)
+		}
+		ut.mails.Reset()
+
+		req = httptest.NewRequest("PUT", "/v1/users/me", strings.NewReader(`{"name":"Top Seller","email":"top@example.com","current_password":"gophers"}`))
+		req.Header.Set("Authorization", "Bearer "+seller)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("updating: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+		var me map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if me["name"] != "Top Seller" || me["email"] != "seller@example.com" || me["pending_email"] != "top@example.com" {
+			t.Fatalf("expected the new email to be pending, got %v", me)
+		}
+	}
+
+	{ // VERIFY NEW EMAIL
+		if !strings.Contains(ut.mails.String(), "To: top@example.com") {
+			t.Fatalf("verification not sent to the new email:\n%s", ut.mails.String())
+		}
+		link := ut.mails.Find(t, regexp.MustCompile(`http://localhost:8000(/v1/users/verify\?token=\S+)`))
+
+		req := httptest.NewRequest("GET", link[1], nil)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("verifying: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+
+		token("top@example.com")
+	}
+}
+
+// PasswordReset ensures that a password can be reset with the token sent by
+// email, only once, that it revokes the API keys of the user and that unknown
+// emails are not revealed.
+func (ut *UserTests) PasswordReset(t *testing.T) {
+
+	var key struct {
+		Secret string `json:"secret"`
+	}
+	{ // CREATE KEY
+		body := strings.NewReader(`{"name":"POS tablet","roles":["USER"]}`)
+		req := httptest.NewRequest("POST", "/v1/keys", body)
+		req.Header.Set("Authorization", "Bearer "+ut.userToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("creating key: expected status code %v, got %v", http.StatusCreated, resp.Code)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&key); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+	}
+
+	{ // FORGOT UNKNOWN EMAIL
+		body := strings.NewReader(`{"email":"nobody@example.com"}`)
+		req := httptest.NewRequest("POST", "/v1/users/password/forgot", body)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("forgot: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+	}
+
+	ut.mails.Reset()
+
+	{ // FORGOT
+		body := strings.NewReader(`{"email":"user@example.com"}`)
+		req := httptest.NewRequest("POST", "/v1/users/password/forgot", body)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("forgot: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+	}
+
+	m := ut.mails.Find(t, regexp.MustCompile(`reset your password:\s+(\S+)`))
+	reset := `{"token":"` + m[1] + `","password":"new gophers","password_confirm":"new gophers"}`
+
+	{ // RESET
+		req := httptest.NewRequest("POST", "/v1/users/password/reset", strings.NewReader(reset))
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("resetting: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+	}
+
+	{ // RESET AGAIN
+		req := httptest.NewRequest("POST", "/v1/users/password/reset", strings.NewReader(reset))
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("resetting again: expected status code %v, got %v", http.StatusBadRequest, resp.Code)
+		}
+	}
+
+	{ // KEY REVOKED
+		req := httptest.NewRequest("GET", "/v1/products", nil)
+		req.Header.Set("X-API-Key", key.Secret)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusUnauthorized {
+			t.Fatalf("using key: expected status code %v, got %v", http.StatusUnauthorized, resp.Code)
+		}
+	}
+
+	{ // TOKEN WITH NEW PASSWORD
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("user@example.com", "new gophers")
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+	}
+}
+
+// ChangePassword ensures that users can change their password only by giving
+// their current one and a new one that follows the policy.
+func (ut *UserTests) ChangePassword(t *testing.T) {
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"WrongCurrent", `{"current_password":"bad","password":"brand new gophers","password_confirm":"brand new gophers"}`, http.StatusForbidden},
+		{"Common", `{"current_password":"new gophers","password":"password","password_confirm":"password"}`, http.StatusBadRequest},
+		{"Reused", `{"current_password":"new gophers","password":"gophers","password_confirm":"gophers"}`, http.StatusBadRequest},
+		{"Success", `{"current_password":"new gophers","password":"brand new gophers","password_confirm":"brand new gophers"}`, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("PUT", "/v1/users/me/password", strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+ut.userToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != tt.want {
+			t.Fatalf("%s: expected status code %v, got %v: %s", tt.name, tt.want, resp.Code, resp.Body)
+		}
+	}
+}
+
+// Activity ensures that login attempts and the last time a user was seen are
+// recorded, and that only admins see the activity of others.
+func (ut *UserTests) Activity(t *testing.T) {
+
+	{ // MY ACTIVITY
+		req := httptest.NewRequest("GET", "/v1/users/me/activity", nil)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("retrieving: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+		var got struct {
+			LastSeen *time.Time `json:"last_seen"`
+			Logins   []struct {
+				Success bool   `json:"success"`
+				Reason  string `json:"reason"`
+			} `json:"logins"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if got.LastSeen == nil {
+			t.Fatal("expected to be seen")
+		}
+		// Earlier tests logged in with a bad password, then a good one.
+		var failed, succeeded bool
+		for _, l := range got.Logins {
+			failed = failed || (!l.Success && l.Reason == "invalid_credentials")
+			succeeded = succeeded || l.Success
+		}
+		if !failed || !succeeded {
+			t.Fatalf("expected failed and successful logins, got %+v", got.Logins)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"admin", ut.adminToken, http.StatusOK},
+		{"user", ut.userToken, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/v1/users/45b5fbd3-755f-4379-8f07-a58d4a30fa2f/activity", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != tt.status {
+			t.Fatalf("retrieving as %s: expected status code %v, got %v", tt.name, tt.status, resp.Code)
+		}
+	}
+}
+
+// OIDC ensures that users logging in through the identity provider get a
+// token, as a new user the first time, provided the provider verified their
+// email.
+func (ut *UserTests) OIDC(t *testing.T) {
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// login goes through the provider and gives the response of the callback
+	// it sends the user agent back to.
+	login := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/users/oidc/login", nil)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusFound {
+			t.Fatalf("starting login: expected status code %v, got %v", http.StatusFound, resp.Code)
+		}
+		cookies := resp.Result().Cookies()
+
+		at, err := client.Get(resp.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("authorizing: %s", err)
+		}
+		at.Body.Close()
+		if at.StatusCode != http.StatusFound {
+			t.Fatalf("authorizing: expected status code %v, got %v", http.StatusFound, at.StatusCode)
+		}
+
+		req = httptest.NewRequest("GET", at.Header.Get("Location"), nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	ut.stub.SetIdentity(oidc.Identity{Subject: "unverified", Email: "unverified@example.com"})
+	if resp := login(); resp.Code != http.StatusForbidden {
+		t.Fatalf("logging in unverified: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+
+	ut.stub.SetIdentity(oidc.Identity{Subject: "volunteer", Email: "volunteer@example.com", EmailVerified: true, Name: "Volunteer"})
+	resp := login()
+	if resp.Code != http.StatusOK {
+		t.Fatalf("logging in: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var tokens map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["token"])
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("retrieving: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var me map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if me["email"] != "volunteer@example.com" || me["name"] != "Volunteer" {
+		t.Fatalf("expected the provisioned volunteer, got %v", me)
+	}
+
+	// Coming back without the cookie of the login is refused.
+	req = httptest.NewRequest("GET", "/v1/users/oidc/callback?code=x&state=y", nil)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("calling back: expected status code %v, got %v", http.StatusUnauthorized, resp.Code)
+	}
+}
+
+// Impersonation ensures that admins can act as a user, seeing what they see
+// but without being able to do what admins do, and that it is recorded.
+func (ut *UserTests) Impersonation(t *testing.T) {
+
+	const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+
+	// do makes a request with the token and checks its status code.
+	do := func(method, target, token string, body string, status int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != status {
+			t.Fatalf("%s %s: expected status code %v, got %v", method, target, status, resp.Code)
+		}
+		return resp
+	}
+
+	do("POST", "/v1/users/"+userID+"/impersonate", ut.userToken, "", http.StatusForbidden)
+
+	resp := do("POST", "/v1/users/"+userID+"/impersonate", ut.adminToken, "", http.StatusOK)
+	var tkn map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&tkn); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	token := tkn["token"]
+
+	resp = do("GET", "/v1/users/me", token, "", http.StatusOK)
+	var me map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if me["email"] != "user@example.com" {
+		t.Fatalf("expected to act as user@example.com, got %v", me["email"])
+	}
+
+	// The user may manage their keys and password, but not the admin acting as
+	// them, who cannot act as someone else either.
+	do("POST", "/v1/keys", token, `{"name":"POS","roles":["USER"]}`, http.StatusForbidden)
+	do("PUT", "/v1/users/me/password", token, `{"current_password":"gophers","password":"gophers2","password_confirm":"gophers2"}`, http.StatusForbidden)
+	do("POST", "/v1/users/5cf37266-3473-4006-984f-9325122678b7/impersonate", token, "", http.StatusForbidden)
+
+	// Acting as an admin does not give the permissions of admins.
+	resp = do("POST", "/v1/users/5cf37266-3473-4006-984f-9325122678b7/impersonate", ut.superToken, "", http.StatusOK)
+	if err := json.NewDecoder(resp.Body).Decode(&tkn); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	do("GET", "/v1/users", tkn["token"], "", http.StatusForbidden)
+
+	resp = do("GET", "/v1/users/"+userID+"/impersonations", ut.adminToken, "", http.StatusOK)
+	var list []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if len(list) != 1 || list[0]["actor_id"] != "5cf37266-3473-4006-984f-9325122678b7" {
+		t.Fatalf("expected one impersonation by the admin, got %v", list)
+	}
+}
+
+// Status ensures that suspended users cannot get tokens and that the ones
+// they have stop working, until they are active again.
+func (ut *UserTests) Status(t *testing.T) {
+
+	// do makes a request and checks its status code.
+	do := func(req *http.Request, status int) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != status {
+			t.Fatalf("%s %s: expected status code %v, got %v", req.Method, req.URL.Path, status, resp.Code)
+		}
+		return resp
+	}
+	token := func(status int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("suspended@example.com", "gophers")
+		return do(req, status)
+	}
+	me := func(token string, status int) {
+		req := httptest.NewRequest("GET", "/v1/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		do(req, status)
+	}
+	var url string
+	setStatus := func(body string) {
+		req := httptest.NewRequest("PUT", url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		do(req, http.StatusOK)
+	}
+
+	body := strings.NewReader(`{"name":"Mallory","email":"suspended@example.com","roles":["USER"],"password":"gophers","password_confirm":"gophers"}`)
+	req := httptest.NewRequest("POST", "/v1/users", body)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	var created map[string]interface{}
+	if err := json.NewDecoder(do(req, http.StatusCreated).Body).Decode(&created); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	url = "/v1/users/" + created["id"].(string) + "/status"
+
+	var tkn map[string]string
+	if err := json.NewDecoder(token(http.StatusOK).Body).Decode(&tkn); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	me(tkn["token"], http.StatusOK)
+
+	setStatus(`{"status":"suspended","reason":"spam"}`)
+	me(tkn["token"], http.StatusUnauthorized)
+	token(http.StatusForbidden)
+
+	// Users cannot change their own status.
+	req = httptest.NewRequest("PUT", "/v1/users/5cf37266-3473-4006-984f-9325122678b7/status", strings.NewReader(`{"status":"active"}`))
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	do(req, http.StatusForbidden)
+
+	setStatus(`{"status":"active"}`)
+	me(tkn["token"], http.StatusOK)
+	token(http.StatusOK)
+}
+
+// TwoFactor ensures that a user with two factor authentication enabled only
+// gets a token after giving a TOTP or recovery code, each usable once, and
+// that wrong codes lock the account out. It relies on the user registered by
+// RegisterAndVerify.
+func (ut *UserTests) TwoFactor(t *testing.T) {
+
+	do := func(method, path, token, body string, want int, v interface{}) {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("%s %s: expected status code %v, got %v: %s", method, path, want, resp.Code, resp.Body)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("decoding: %s", err)
+			}
+		}
+	}
+
+	login := func(want int, v interface{}) {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("seller@example.com", "gophers")
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("getting token: expected status code %v, got %v", want, resp.Code)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+	}
+
+	var tkns map[string]string
+	login(http.StatusOK, &tkns)
+
+	var e struct {
+		Secret        string   `json:"secret"`
+		URI           string   `json:"uri"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	do("POST", "/v1/users/me/2fa", tkns["token"], "", http.StatusCreated, &e)
+	if len(e.RecoveryCodes) != 10 || !strings.HasPrefix(e.URI, "otpauth://totp/") {
+		t.Fatalf("unexpected enrollment: %+v", e)
+	}
+
+	code, err := totp.Code(e.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("computing code: %s", err)
+	}
+	do("POST", "/v1/users/me/2fa/confirm", tkns["token"], `{"code":"000000x"}`, http.StatusBadRequest, nil)
+	do("POST", "/v1/users/me/2fa/confirm", tkns["token"], `{"code":"`+code+`"}`, http.StatusNoContent, nil)
+
+	var ch map[string]string
+	login(http.StatusAccepted, &ch)
+	if ch["challenge"] == "" {
+		t.Fatal("challenge was not in response")
+	}
+
+	// The code used to confirm cannot be used again.
+	do("POST", "/v1/users/token/2fa", "", `{"token":"`+ch["challenge"]+`","code":"`+code+`"}`, http.StatusUnauthorized, nil)
+
+	recovery := `{"token":"` + ch["challenge"] + `","code":"` + e.RecoveryCodes[0] + `"}`
+	var got map[string]string
+	do("POST", "/v1/users/token/2fa", "", recovery, http.StatusOK, &got)
+	if got["token"] == "" {
+		t.Fatal("token was not in response")
+	}
+	do("POST", "/v1/users/token/2fa", "", recovery, http.StatusUnauthorized, nil)
+
+	do("DELETE", "/v1/users/me/2fa", got["token"], `{"code":"`+e.RecoveryCodes[1]+`"}`, http.StatusNoContent, nil)
+	login(http.StatusOK, &tkns)
+
+	// Wrong codes count as failed attempts, so they cannot be guessed with a
+	// token either.
+	do("POST", "/v1/users/me/2fa", tkns["token"], "", http.StatusCreated, &e)
+	for i := 0; i < 3; i++ {
+		do("POST", "/v1/users/me/2fa/confirm", tkns["token"], `{"code":"000000x"}`, http.StatusBadRequest, nil)
+	}
+	do("POST", "/v1/users/me/2fa/confirm", tkns["token"], `{"code":"000000x"}`, http.StatusTooManyRequests, nil)
+	do("DELETE", "/v1/lockouts/account/seller@example.com", ut.superToken, "", http.StatusNoContent, nil)
+	login(http.StatusOK, &tkns)
+}
+
+// AdminTwoFactorRequired ensures that admins without two factor
+// authentication do not get the admin role when it is required, whether they
+// use a token or an API key.
+func (ut *UserTests) AdminTwoFactorRequired(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("admin@example.com", "gophers")
+	resp := httptest.NewRecorder()
+
+	ut.strictApp.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	var tkns map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&tkns); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/users", nil)
+	req.Header.Set("Authorization", "Bearer "+tkns["token"])
+	resp = httptest.NewRecorder()
+
+	ut.strictApp.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("listing users: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+
+	var key struct {
+		Secret string `json:"secret"`
+	}
+	{
+		body := strings.NewReader(`{"name":"Admin script","roles":["ADMIN"]}`)
+		req := httptest.NewRequest("POST", "/v1/keys", body)
+		req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("creating key: expected status code %v, got %v", http.StatusCreated, resp.Code)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&key); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/v1/users", nil)
+	req.Header.Set("X-API-Key", key.Secret)
+	resp = httptest.NewRecorder()
+
+	ut.strictApp.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("listing users with a key: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+}
+
+// SuperAdminTwoFactorRequired ensures that the roles granting admin
+// permissions other than the admin one are also denied to users without two
+// factor authentication when it is required.
+func (ut *UserTests) SuperAdminTwoFactorRequired(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("superadmin@example.com", "gophers")
+	resp := httptest.NewRecorder()
+
+	ut.strictApp.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	var tkns map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&tkns); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	for _, url := range []string{"/v1/users", "/v1/orgs"} {
+		req = httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+tkns["token"])
+		resp = httptest.NewRecorder()
+
+		ut.strictApp.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusForbidden {
+			t.Fatalf("getting %s: expected status code %v, got %v", url, http.StatusForbidden, resp.Code)
+		}
+	}
 }
@@ -0,0 +1,76 @@
+package activity
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// lastSeenPrecision is how often the last time a user was seen is recorded
+// at most.
+const lastSeenPrecision = time.Minute
+
+// loginLimit is how many of the latest login attempts Retrieve gives.
+const loginLimit = 50
+
+// RecordLogin stores a login attempt, a successful one when nl has no Reason.
+// The attempt is linked to the user having the email, if any.
+func RecordLogin(ctx context.Context, db *sqlx.DB, nl NewLogin, now time.Time) error {
+
+	const q = `INSERT INTO login_events
+		(event_id, user_id, email, success, reason, ip, user_agent, date_created)
+		VALUES ($1, (SELECT user_id FROM users WHERE email = $2), $2, $3, $4, $5, $6, $7)`
+
+	_, err := db.ExecContext(ctx, q,
+		uuid.New().String(), nl.Email, nl.Reason == "", nl.Reason,
+		nl.IP, nl.UserAgent, now.UTC(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "inserting login event")
+	}
+
+	return nil
+}
+
+// Seen records that the user made a request from the given IP. To spare the
+// database a write per request, it is only recorded once per minute.
+func Seen(ctx context.Context, db *sqlx.DB, userID, ip string, now time.Time) error {
+
+	// Tokens may outlive their user, who is then not tracked anymore.
+	const q = `INSERT INTO last_seen AS s (user_id, ip, date_seen)
+		SELECT user_id, $2::text, $3::timestamp FROM users WHERE user_id = $1
+		ON CONFLICT (user_id) DO UPDATE SET ip = $2, date_seen = $3
+		WHERE s.date_seen < $4`
+
+	if _, err := db.ExecContext(ctx, q, userID, ip, now.UTC(), now.Add(-lastSeenPrecision).UTC()); err != nil {
+		return errors.Wrap(err, "recording last seen")
+	}
+
+	return nil
+}
+
+// Retrieve gives the Activity of a user. It is up to the caller to make sure
+// the user may be looked at.
+func Retrieve(ctx context.Context, db *sqlx.DB, userID string) (*Activity, error) {
+
+	var a Activity
+
+	const qs = `SELECT date_seen, ip FROM last_seen WHERE user_id = $1`
+	err := db.QueryRowContext(ctx, qs, userID).Scan(&a.LastSeen, &a.LastSeenIP)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "selecting last seen")
+	}
+
+	a.Logins = []Login{}
+	const ql = `SELECT * FROM login_events WHERE user_id = $1
+		ORDER BY date_created DESC LIMIT $2`
+	if err := db.SelectContext(ctx, &a.Logins, ql, userID, loginLimit); err != nil {
+		return nil, errors.Wrap(err, "selecting login events")
+	}
+
+	return &a, nil
+}
@@ -0,0 +1,87 @@
+package activity_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/activity"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/tests"
+	"github.com/devisions/garagesale/internal/user"
+)
+
+func TestActivity(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	nu := user.NewUser{
+		Name:            "Gopher",
+		Email:           "gopher@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	u, err := user.Create(ctx, db, user.Policy{}, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	a, err := activity.Retrieve(ctx, db, u.ID)
+	if err != nil {
+		t.Fatalf("retrieving activity: %s", err)
+	}
+	if a.LastSeen != nil || len(a.Logins) != 0 {
+		t.Fatalf("expected no activity, got %+v", a)
+	}
+
+	failed := activity.NewLogin{Email: nu.Email, Reason: activity.ReasonInvalidCredentials, IP: "192.0.2.1", UserAgent: "curl"}
+	if err := activity.RecordLogin(ctx, db, failed, now); err != nil {
+		t.Fatalf("recording login: %s", err)
+	}
+	succeeded := activity.NewLogin{Email: nu.Email, IP: "192.0.2.1", UserAgent: "curl"}
+	if err := activity.RecordLogin(ctx, db, succeeded, now.Add(time.Second)); err != nil {
+		t.Fatalf("recording login: %s", err)
+	}
+	unknown := activity.NewLogin{Email: "nobody@example.com", Reason: activity.ReasonInvalidCredentials, IP: "192.0.2.1"}
+	if err := activity.RecordLogin(ctx, db, unknown, now); err != nil {
+		t.Fatalf("recording login: %s", err)
+	}
+
+	if err := activity.Seen(ctx, db, u.ID, "192.0.2.1", now); err != nil {
+		t.Fatalf("recording last seen: %s", err)
+	}
+	// Being seen again within a minute is not recorded.
+	if err := activity.Seen(ctx, db, u.ID, "192.0.2.2", now.Add(30*time.Second)); err != nil {
+		t.Fatalf("recording last seen: %s", err)
+	}
+
+	a, err = activity.Retrieve(ctx, db, u.ID)
+	if err != nil {
+		t.Fatalf("retrieving activity: %s", err)
+	}
+	if a.LastSeen == nil || !a.LastSeen.Equal(now) || *a.LastSeenIP != "192.0.2.1" {
+		t.Fatalf("expected to be last seen at %v from 192.0.2.1, got %+v", now, a)
+	}
+	if len(a.Logins) != 2 {
+		t.Fatalf("expected 2 logins, got %d", len(a.Logins))
+	}
+	if !a.Logins[0].Success || a.Logins[1].Success || a.Logins[1].Reason != activity.ReasonInvalidCredentials {
+		t.Fatalf("expected a success after a failure, got %+v", a.Logins)
+	}
+
+	if err := activity.Seen(ctx, db, u.ID, "192.0.2.2", now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("recording last seen: %s", err)
+	}
+	a, err = activity.Retrieve(ctx, db, u.ID)
+	if err != nil {
+		t.Fatalf("retrieving activity: %s", err)
+	}
+	if !a.LastSeen.Equal(now.Add(2*time.Minute)) || *a.LastSeenIP != "192.0.2.2" {
+		t.Fatalf("expected to be last seen again, got %+v", a)
+	}
+}
@@ -0,0 +1,4 @@
+// Package activity implements all business logic regarding what users do with
+// their accounts: the attempts to log in, successful or not, and when they
+// were last seen using the API.
+package activity
@@ -0,0 +1,41 @@
+package activity
+
+import "time"
+
+// Reasons a login attempt failed for.
+const (
+	ReasonInvalidCredentials = "invalid_credentials"
+	ReasonNotVerified        = "not_verified"
+	ReasonLockedOut          = "locked_out"
+	ReasonInvalidCode        = "invalid_code"
+	ReasonInactive           = "inactive"
+)
+
+// Login is an attempt to get a token. UserID is only known when the email
+// belonged to a user at the time, and Reason is only set for failures.
+type Login struct {
+	ID          string    `db:"event_id"      json:"id"`
+	UserID      *string   `db:"user_id"       json:"user_id"`
+	Email       string    `db:"email"         json:"email"`
+	Success     bool      `db:"success"       json:"success"`
+	Reason      string    `db:"reason"        json:"reason,omitempty"`
+	IP          string    `db:"ip"            json:"ip"`
+	UserAgent   string    `db:"user_agent"    json:"user_agent"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+}
+
+// NewLogin is what we record about a login attempt.
+type NewLogin struct {
+	Email     string
+	Reason    string
+	IP        string
+	UserAgent string
+}
+
+// Activity is what a user did lately: when and from where they were last seen
+// and their latest login attempts, the most recent first.
+type Activity struct {
+	LastSeen   *time.Time `json:"last_seen"`
+	LastSeenIP *string    `json:"last_seen_ip"`
+	Logins     []Login    `json:"logins"`
+}
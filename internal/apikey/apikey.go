@@ -0,0 +1,197 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/role"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrNotFound  = errors.New("api key not found")
+	ErrInvalidID = errors.New("provided id is not a valid UUID")
+	ErrForbidden = errors.New("attempted action is not allowed")
+
+	// ErrInvalidRoles occurs when a Key would get a role its user does not
+	// have.
+	ErrInvalidRoles = errors.New("api keys can only get roles of their user")
+
+	// ErrInvalidKey occurs when authenticating with an unknown or revoked
+	// key.
+	ErrInvalidKey = errors.New("invalid api key")
+)
+
+// prefix starts every key, for people and secret scanners to recognize them.
+const prefix = "gsk_"
+
+// lastUsedPrecision is how often the last use of a key is recorded at most.
+const lastUsedPrecision = time.Minute
+
+// Create generates a new Key for the user of the claims, with some of their
+// roles.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, nk NewKey, now time.Time) (*Created, error) {
+
+	for _, role := range nk.Roles {
+		if !user.HasRole(role) {
+			return nil, ErrInvalidRoles
+		}
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, errors.Wrap(err, "generating api key")
+	}
+	secret := prefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	c := Created{
+		Key: Key{
+			ID:          uuid.New().String(),
+			UserID:      user.Subject,
+			Name:        nk.Name,
+			Prefix:      secret[:len(prefix)+6],
+			KeyHash:     hashKey(secret),
+			Roles:       nk.Roles,
+			DateCreated: now.UTC(),
+		},
+		Secret: secret,
+	}
+
+	const q = `INSERT INTO api_keys
+		(key_id, user_id, name, prefix, key_hash, roles, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.ExecContext(ctx, q,
+		c.ID, c.UserID, c.Name, c.Prefix,
+		c.KeyHash, c.Roles, c.DateCreated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting api key")
+	}
+
+	return &c, nil
+}
+
+// List gives the keys of a user, revoked ones included.
+func List(ctx context.Context, db *sqlx.DB, userID string) ([]Key, error) {
+
+	keys := []Key{}
+
+	const q = `SELECT * FROM api_keys WHERE user_id = $1 ORDER BY date_created DESC`
+	if err := db.SelectContext(ctx, &keys, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting api keys")
+	}
+	return keys, nil
+}
+
+// Revoke makes a Key unusable. Only its user or someone allowed to manage
+// users can do it. Keys of users of other organizations than the one of the
+// user are not found.
+func Revoke(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, now time.Time) error {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	var k Key
+	const q = `SELECT k.* FROM api_keys AS k
+		JOIN users AS u ON u.user_id = k.user_id
+		WHERE k.key_id = $1 AND ($2::uuid IS NULL OR u.org_id = $2)`
+	if err := db.GetContext(ctx, &k, q, id, user.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "selecting api key %q", id)
+	}
+
+	if k.UserID != user.Subject && !user.HasPermission(auth.PermUserWrite) {
+		return ErrForbidden
+	}
+
+	const qu = `UPDATE api_keys SET revoked_at = $2 WHERE key_id = $1 AND revoked_at IS NULL`
+	if _, err := db.ExecContext(ctx, qu, id, now.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking api key %q", id)
+	}
+	return nil
+}
+
+// RevokeUser makes all the keys of a user unusable, as when their password
+// changed.
+func RevokeUser(ctx context.Context, db *sqlx.DB, userID string, now time.Time) error {
+
+	const q = `UPDATE api_keys SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := db.ExecContext(ctx, q, userID, now.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking api keys of user %q", userID)
+	}
+	return nil
+}
+
+// Authenticate finds a Key by its secret and gives the claims it acts with:
+// the roles of the key that its user still has, within the organization of
+// the user. Keys of users that are not active are refused. The last use of
+// the key is recorded.
+func Authenticate(ctx context.Context, db *sqlx.DB, secret string, now time.Time) (auth.Claims, error) {
+
+	var k struct {
+		Key
+		UserRoles pq.StringArray `db:"user_roles"`
+		OrgID     string         `db:"org_id"`
+	}
+	const q = `SELECT k.*, u.roles AS user_roles, u.org_id FROM api_keys AS k
+		JOIN users AS u ON u.user_id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL
+			AND (u.status = 'active' OR u.status_until <= $2)`
+	if err := db.QueryRowxContext(ctx, q, hashKey(secret), now.UTC()).StructScan(&k); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrInvalidKey
+		}
+		return auth.Claims{}, errors.Wrap(err, "selecting api key")
+	}
+
+	roles := []string{}
+	for _, role := range k.Roles {
+		for _, has := range k.UserRoles {
+			if role == has {
+				roles = append(roles, role)
+				break
+			}
+		}
+	}
+
+	const qu = `UPDATE api_keys SET last_used = $2
+		WHERE key_id = $1 AND (last_used IS NULL OR last_used < $3)`
+	if _, err := db.ExecContext(ctx, qu, k.ID, now.UTC(), now.Add(-lastUsedPrecision).UTC()); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "recording api key use")
+	}
+
+	// The claims only live for the request, so they get no id to revoke
+	// them by. Revoking the key is the way to go.
+	claims := auth.NewClaims(k.UserID, roles, now, time.Minute)
+	claims.Id = ""
+	claims.OrgID = k.OrgID
+	claims.KeyID = k.ID
+
+	var err error
+	claims.Permissions, err = role.Resolve(ctx, db, roles)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	return claims, nil
+}
+
+// hashKey gives the hex encoded SHA-256 of a key.
+func hashKey(secret string) string {
+
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
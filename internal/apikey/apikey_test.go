@@ -0,0 +1,98 @@
+package apikey_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/apikey"
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/tests"
+	"github.com/devisions/garagesale/internal/user"
+)
+
+func TestAPIKey(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	nu := user.NewUser{
+		Name:            "Gopher",
+		Email:           "gopher@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	u, err := user.Create(ctx, db, user.Policy{}, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+	claims.OrgID = u.OrgID
+
+	if _, err := apikey.Create(ctx, db, claims, apikey.NewKey{Name: "POS", Roles: []string{auth.RoleAdmin}}, now); err != apikey.ErrInvalidRoles {
+		t.Fatalf("expected ErrInvalidRoles, got %v", err)
+	}
+
+	c, err := apikey.Create(ctx, db, claims, apikey.NewKey{Name: "POS", Roles: []string{auth.RoleUser}}, now)
+	if err != nil {
+		t.Fatalf("creating api key: %s", err)
+	}
+
+	got, err := apikey.Authenticate(ctx, db, c.Secret, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("authenticating: %s", err)
+	}
+	if got.Subject != u.ID || !got.HasRole(auth.RoleUser) || got.KeyID != c.ID {
+		t.Fatalf("unexpected claims: %+v", got)
+	}
+
+	keys, err := apikey.List(ctx, db, u.ID)
+	if err != nil {
+		t.Fatalf("listing api keys: %s", err)
+	}
+	if len(keys) != 1 || keys[0].LastUsed == nil || !keys[0].LastUsed.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected the key to be used, got %+v", keys)
+	}
+
+	other := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, now, time.Hour)
+	other.OrgID = org.Default
+	if err := apikey.Revoke(ctx, db, other, c.ID, now); err != apikey.ErrForbidden {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	// Managing users does not reach the keys of other organizations.
+	scouts, err := org.Create(ctx, db, org.NewOrg{Name: "Scouts"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+	stranger := auth.NewClaims("2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37", []string{auth.RoleAdmin}, now, time.Hour)
+	stranger.OrgID = scouts.ID
+	stranger.Permissions = []string{auth.PermUserWrite}
+	if err := apikey.Revoke(ctx, db, stranger, c.ID, now); err != apikey.ErrNotFound {
+		t.Fatalf("expected ErrNotFound revoking another organization's key, got %v", err)
+	}
+
+	if err := apikey.Revoke(ctx, db, claims, c.ID, now); err != nil {
+		t.Fatalf("revoking api key: %s", err)
+	}
+	if _, err := apikey.Authenticate(ctx, db, c.Secret, now.Add(time.Hour)); err != apikey.ErrInvalidKey {
+		t.Fatalf("expected ErrInvalidKey, got %v", err)
+	}
+
+	// All the keys of a user are revoked at once.
+	c, err = apikey.Create(ctx, db, claims, apikey.NewKey{Name: "Sync", Roles: []string{auth.RoleUser}}, now)
+	if err != nil {
+		t.Fatalf("creating api key: %s", err)
+	}
+	if err := apikey.RevokeUser(ctx, db, u.ID, now); err != nil {
+		t.Fatalf("revoking api keys: %s", err)
+	}
+	if _, err := apikey.Authenticate(ctx, db, c.Secret, now.Add(time.Hour)); err != apikey.ErrInvalidKey {
+		t.Fatalf("expected ErrInvalidKey, got %v", err)
+	}
+}
@@ -0,0 +1,4 @@
+// Package apikey implements all business logic regarding API keys, used by
+// machine clients like POS tablets and sync scripts to authenticate without
+// holding the password of a user.
+package apikey
@@ -0,0 +1,35 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Key is an API key of a user. Only a hash of the secret is stored, while
+// its Prefix tells the keys apart. A key authenticates as its user, with the
+// Roles it was given among theirs.
+type Key struct {
+	ID          string         `db:"key_id"        json:"id"`
+	UserID      string         `db:"user_id"       json:"user_id"`
+	Name        string         `db:"name"          json:"name"`
+	Prefix      string         `db:"prefix"        json:"prefix"`
+	KeyHash     string         `db:"key_hash"      json:"-"`
+	Roles       pq.StringArray `db:"roles"         json:"roles"`
+	DateCreated time.Time      `db:"date_created"  json:"date_created"`
+	LastUsed    *time.Time     `db:"last_used"     json:"last_used"`
+	RevokedAt   *time.Time     `db:"revoked_at"    json:"revoked_at"`
+}
+
+// NewKey is what we require from clients when creating a Key.
+type NewKey struct {
+	Name  string   `json:"name"   validate:"required"`
+	Roles []string `json:"roles"  validate:"required,min=1"`
+}
+
+// Created is a newly created Key along with its secret, which is never shown
+// again.
+type Created struct {
+	Key
+	Secret string `json:"secret"`
+}
@@ -0,0 +1,143 @@
+package customer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrNotFound  = errors.New("customer not found")
+	ErrInvalidID = errors.New("provided id is not a valid UUID")
+)
+
+// List returns all Customers of the organization of the user.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]Customer, error) {
+
+	list := []Customer{}
+
+	const q = `SELECT * FROM customers WHERE ($1::uuid IS NULL OR org_id = $1) ORDER BY name`
+	if err := db.SelectContext(ctx, &list, q, claims.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting all customers")
+	}
+	return list, nil
+}
+
+// Retrieve returns a single Customer. Customers of other organizations than
+// the one of the user are not found.
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) (*Customer, error) {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var c Customer
+	const q = `SELECT * FROM customers WHERE customer_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if err := db.GetContext(ctx, &c, q, id, claims.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting single customer")
+	}
+	return &c, nil
+}
+
+// Create makes a new Customer in the organization of the user.
+func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, nc NewCustomer, now time.Time) (*Customer, error) {
+
+	c := Customer{
+		ID:          uuid.New().String(),
+		OrgID:       claims.OrgID,
+		Name:        nc.Name,
+		Contact:     nc.Contact,
+		Notes:       nc.Notes,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	const q = `INSERT INTO customers
+		(customer_id, org_id, name, contact, notes, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := db.ExecContext(ctx, q, c.ID, c.OrgID, c.Name, c.Contact, c.Notes, c.DateCreated, c.DateUpdated); err != nil {
+		return nil, errors.Wrapf(err, "inserting customer: %v", nc)
+	}
+
+	return &c, nil
+}
+
+// Update modifies data about a Customer. It will error if the specified ID is
+// invalid or does not reference an existing Customer.
+func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, update UpdateCustomer, now time.Time) error {
+
+	c, err := Retrieve(ctx, db, claims, id)
+	if err != nil {
+		return err
+	}
+
+	if update.Name != nil {
+		c.Name = *update.Name
+	}
+	if update.Contact != nil {
+		c.Contact = *update.Contact
+	}
+	if update.Notes != nil {
+		c.Notes = *update.Notes
+	}
+	c.DateUpdated = now.UTC()
+
+	const q = `UPDATE customers SET
+		"name" = $2,
+		"contact" = $3,
+		"notes" = $4,
+		"date_updated" = $5
+		WHERE customer_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, c.Name, c.Contact, c.Notes, c.DateUpdated); err != nil {
+		return errors.Wrap(err, "updating customer")
+	}
+
+	return nil
+}
+
+// Delete removes the customer identified by a given ID. The sales made to
+// this customer are kept, but they are no longer linked to anyone. Customers
+// of other organizations than the one of the user are left alone.
+func Delete(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) error {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM customers WHERE customer_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if _, err := db.ExecContext(ctx, q, id, claims.Tenant()); err != nil {
+		return errors.Wrapf(err, "deleting customer %s", id)
+	}
+
+	return nil
+}
+
+// ListPurchases gives all the Sales made to a Customer, most recent first.
+func ListPurchases(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) ([]Purchase, error) {
+
+	if _, err := Retrieve(ctx, db, claims, id); err != nil {
+		return nil, err
+	}
+
+	purchases := []Purchase{}
+
+	const q = `SELECT s.sale_id, s.product_id, p.name AS product_name,
+			   s.quantity, s.paid, s.date_created
+			   FROM sales AS s
+			   JOIN products AS p ON p.product_id = s.product_id
+			   WHERE s.customer_id = $1
+			   ORDER BY s.date_created DESC`
+	if err := db.SelectContext(ctx, &purchases, q, id); err != nil {
+		return nil, errors.Wrap(err, "selecting customer purchases")
+	}
+
+	return purchases, nil
+}
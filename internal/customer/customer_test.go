@@ -0,0 +1,185 @@
+package customer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/customer"
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/product"
+	"github.com/devisions/garagesale/internal/tests"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCustomers(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+	claims.OrgID = org.Default
+
+	nc := customer.NewCustomer{Name: "Jane Doe", Contact: "jane@example.com"}
+
+	saved, err := customer.Create(ctx, db, claims, nc, now)
+	if err != nil {
+		t.Fatalf("could not create customer: %v", err)
+	}
+
+	fetched, err := customer.Retrieve(ctx, db, claims, saved.ID)
+	if err != nil {
+		t.Fatalf("could not retrieve customer: %v", err)
+	}
+	if diff := cmp.Diff(saved, fetched); diff != "" {
+		t.Fatalf("fetched customer did not match saved. diff: %v", diff)
+	}
+
+	update := customer.UpdateCustomer{Notes: tests.StringPointer("Picks up on Sundays")}
+	if err := customer.Update(ctx, db, claims, saved.ID, update, now.Add(time.Hour)); err != nil {
+		t.Fatalf("could not update customer: %v", err)
+	}
+
+	fetched, err = customer.Retrieve(ctx, db, claims, saved.ID)
+	if err != nil {
+		t.Fatalf("could not retrieve customer: %v", err)
+	}
+	if exp, got := *update.Notes, fetched.Notes; exp != got {
+		t.Fatalf("expected notes %q, got %q", exp, got)
+	}
+	if exp, got := nc.Name, fetched.Name; exp != got {
+		t.Fatalf("expected name %q, got %q", exp, got)
+	}
+
+	if err := customer.Delete(ctx, db, claims, saved.ID); err != nil {
+		t.Fatalf("could not delete customer: %v", err)
+	}
+	if _, err := customer.Retrieve(ctx, db, claims, saved.ID); err != customer.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestPurchases(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	claims := auth.NewClaims(
+		"718ffbea-f4a1-4667-8ae3-b349da52675e", // A random UUID.
+		[]string{auth.RoleAdmin, auth.RoleUser},
+		now, time.Hour,
+	)
+	claims.OrgID = org.Default
+
+	comics, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Comic Books", Cost: 10, Quantity: 20}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+
+	jane, err := customer.Create(ctx, db, claims, customer.NewCustomer{Name: "Jane Doe"}, now)
+	if err != nil {
+		t.Fatalf("could not create customer: %v", err)
+	}
+
+	// One sale to Jane and one anonymous sale.
+	ns := product.NewSale{Quantity: 2, Paid: 15, CustomerID: &jane.ID}
+	if _, err := product.AddSale(ctx, db, claims, ns, comics.ID, now); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if _, err := product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1, Paid: 10}, comics.ID, now); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	purchases, err := customer.ListPurchases(ctx, db, claims, jane.ID)
+	if err != nil {
+		t.Fatalf("listing purchases: %s", err)
+	}
+	if exp, got := 1, len(purchases); exp != got {
+		t.Fatalf("expected purchase list size %v, got %v", exp, got)
+	}
+	if exp, got := "Comic Books", purchases[0].ProductName; exp != got {
+		t.Fatalf("expected product name %q, got %q", exp, got)
+	}
+
+	// A sale cannot reference a customer that does not exist.
+	unknown := "0b0cc2ca-79b9-4d1c-bc83-5f9bc0a26e0b"
+	ns = product.NewSale{Quantity: 1, Paid: 10, CustomerID: &unknown}
+	if _, err := product.AddSale(ctx, db, claims, ns, comics.ID, now); err != product.ErrUnknownCustomer {
+		t.Fatalf("expected ErrUnknownCustomer, got %v", err)
+	}
+}
+
+func TestCustomerTenancy(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	other, err := org.Create(ctx, db, org.NewOrg{Name: "Other Gophers"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	owner := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+	owner.OrgID = org.Default
+	stranger := auth.NewClaims("2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37", []string{auth.RoleAdmin}, now, time.Hour)
+	stranger.OrgID = other.ID
+	stranger.Permissions = []string{auth.PermCustomerDelete}
+	super := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleSuperAdmin}, now, time.Hour)
+	super.OrgID = other.ID
+	super.Permissions = []string{auth.PermOrgManage}
+
+	jane, err := customer.Create(ctx, db, owner, customer.NewCustomer{Name: "Jane Doe"}, now)
+	if err != nil {
+		t.Fatalf("creating customer: %s", err)
+	}
+
+	if _, err := customer.Retrieve(ctx, db, stranger, jane.ID); err != customer.ErrNotFound {
+		t.Fatalf("expected ErrNotFound retrieving another organization's customer, got %v", err)
+	}
+	list, err := customer.List(ctx, db, stranger)
+	if err != nil {
+		t.Fatalf("listing customers: %s", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no customers of another organization, got %d", len(list))
+	}
+	if _, err := customer.ListPurchases(ctx, db, stranger, jane.ID); err != customer.ErrNotFound {
+		t.Fatalf("expected ErrNotFound listing another organization's purchases, got %v", err)
+	}
+	name := "Stolen"
+	if err := customer.Update(ctx, db, stranger, jane.ID, customer.UpdateCustomer{Name: &name}, now); err != customer.ErrNotFound {
+		t.Fatalf("expected ErrNotFound updating another organization's customer, got %v", err)
+	}
+	if err := customer.Delete(ctx, db, stranger, jane.ID); err != nil {
+		t.Fatalf("deleting customer: %s", err)
+	}
+
+	// Sales of another organization cannot be made to the customer.
+	comics, err := product.Create(ctx, db, stranger, product.NewProduct{Name: "Comic Books", Cost: 10, Quantity: 20}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	ns := product.NewSale{Quantity: 1, Paid: 10, CustomerID: &jane.ID}
+	if _, err := product.AddSale(ctx, db, stranger, ns, comics.ID, now); err != product.ErrUnknownCustomer {
+		t.Fatalf("expected ErrUnknownCustomer selling to another organization's customer, got %v", err)
+	}
+
+	// A super admin reaches every organization.
+	fetched, err := customer.Retrieve(ctx, db, super, jane.ID)
+	if err != nil {
+		t.Fatalf("super admin retrieving customer: %s", err)
+	}
+	if fetched.Name != jane.Name {
+		t.Fatalf("unexpected customer %+v", fetched)
+	}
+}
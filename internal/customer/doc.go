@@ -0,0 +1,3 @@
+// Package customer implements all business logic regarding customers, the
+// people who buy things at our sales.
+package customer
@@ -0,0 +1,41 @@
+package customer
+
+import "time"
+
+// Customer is someone who bought, or may buy, something from us.
+type Customer struct {
+	ID          string    `db:"customer_id"   json:"id"`
+	OrgID       string    `db:"org_id"        json:"org_id"`
+	Name        string    `db:"name"          json:"name"`
+	Contact     string    `db:"contact"       json:"contact"`
+	Notes       string    `db:"notes"         json:"notes"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+	DateUpdated time.Time `db:"date_updated"  json:"date_updated"`
+}
+
+// NewCustomer is the input request for creating a new Customer.
+type NewCustomer struct {
+	Name    string `json:"name"     validate:"required"`
+	Contact string `json:"contact"`
+	Notes   string `json:"notes"`
+}
+
+// UpdateCustomer defines what information may be provided to modify an
+// existing Customer. All fields are optional so clients can send just the
+// fields they want changed.
+type UpdateCustomer struct {
+	Name    *string `json:"name"     validate:"omitempty,min=1"`
+	Contact *string `json:"contact"`
+	Notes   *string `json:"notes"`
+}
+
+// Purchase is a Sale seen from the Customer's side, including the name of the
+// Product that was bought.
+type Purchase struct {
+	SaleID      string    `db:"sale_id"       json:"sale_id"`
+	ProductID   string    `db:"product_id"    json:"product_id"`
+	ProductName string    `db:"product_name"  json:"product_name"`
+	Quantity    int       `db:"quantity"      json:"quantity"`
+	Paid        int       `db:"paid"          json:"paid"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+}
@@ -0,0 +1,4 @@
+// Package drawer implements all business logic regarding cash drawer
+// sessions, used to reconcile the cash counted at the end of a sale day with
+// the cash payments recorded during it.
+package drawer
@@ -0,0 +1,200 @@
+package drawer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devisions/garagesale/internal/payment"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrNotFound      = errors.New("drawer session not found")
+	ErrInvalidID     = errors.New("provided id is not a valid UUID")
+	ErrAlreadyOpen   = errors.New("the cash drawer is already open")
+	ErrNotOpen       = errors.New("the cash drawer is not open")
+	ErrAlreadyClosed = errors.New("drawer session is already closed")
+)
+
+// Open starts a new Session of the cash drawer of the organization of the
+// user with the given float. Only one Session per organization can be open at
+// a time.
+func Open(ctx context.Context, db *sqlx.DB, claims auth.Claims, ns NewSession, now time.Time) (*Session, error) {
+
+	s := Session{
+		ID:       uuid.New().String(),
+		OrgID:    claims.OrgID,
+		Float:    ns.Float,
+		OpenedBy: subject(claims),
+		OpenedAt: now.UTC(),
+	}
+
+	const q = `INSERT INTO drawer_sessions
+		(session_id, org_id, float, opened_by, opened_at, notes)
+		VALUES ($1, $2, $3, $4, $5, '')`
+	if _, err := db.ExecContext(ctx, q, s.ID, s.OrgID, s.Float, s.OpenedBy, s.OpenedAt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "drawer_sessions_single_open" {
+			return nil, ErrAlreadyOpen
+		}
+		return nil, errors.Wrap(err, "inserting drawer session")
+	}
+
+	return &s, nil
+}
+
+// List returns all Sessions of the organization of the user, the most recent
+// first.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]Session, error) {
+
+	list := []Session{}
+
+	const q = `SELECT * FROM drawer_sessions WHERE ($1::uuid IS NULL OR org_id = $1) ORDER BY opened_at DESC`
+	if err := db.SelectContext(ctx, &list, q, claims.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting drawer sessions")
+	}
+	return list, nil
+}
+
+// Retrieve returns a single Session. For a Session that is still open, the
+// Expected cash is computed as of now. Sessions of other organizations than
+// the one of the user are not found.
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, now time.Time) (*Session, error) {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var s Session
+	const q = `SELECT * FROM drawer_sessions WHERE session_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if err := db.GetContext(ctx, &s, q, id, claims.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting single drawer session")
+	}
+
+	if s.ClosedAt == nil {
+		expected, err := expectedCash(ctx, db, &s, now)
+		if err != nil {
+			return nil, err
+		}
+		s.Expected = &expected
+	}
+
+	return &s, nil
+}
+
+// Current returns the open Session of the organization of the user, with the
+// Expected cash as of now.
+func Current(ctx context.Context, db *sqlx.DB, claims auth.Claims, now time.Time) (*Session, error) {
+
+	var id string
+	const q = `SELECT session_id FROM drawer_sessions WHERE closed_at IS NULL AND org_id = $1`
+	if err := db.GetContext(ctx, &id, q, claims.OrgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotOpen
+		}
+		return nil, errors.Wrap(err, "selecting open drawer session")
+	}
+
+	return Retrieve(ctx, db, claims, id, now)
+}
+
+// Close ends a Session with the cash counted in the drawer and stores the
+// discrepancy from the expected cash. The Session is locked before computing
+// the expected cash, so cash payments recorded meanwhile are waited for.
+func Close(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, cs CloseSession, now time.Time) (*Session, error) {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	var s Session
+	const ql = `SELECT * FROM drawer_sessions WHERE session_id = $1 AND ($2::uuid IS NULL OR org_id = $2)
+		FOR UPDATE`
+	if err := tx.GetContext(ctx, &s, ql, id, claims.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "locking drawer session")
+	}
+	if s.ClosedAt != nil {
+		return nil, ErrAlreadyClosed
+	}
+
+	expected, err := expectedCash(ctx, tx, &s, now)
+	if err != nil {
+		return nil, err
+	}
+
+	closedAt := now.UTC()
+	discrepancy := cs.Counted - expected
+
+	s.ClosedBy = subject(claims)
+	s.ClosedAt = &closedAt
+	s.Expected = &expected
+	s.Counted = &cs.Counted
+	s.Discrepancy = &discrepancy
+	s.Notes = cs.Notes
+
+	const q = `UPDATE drawer_sessions SET
+		"closed_by" = $2,
+		"closed_at" = $3,
+		"expected" = $4,
+		"counted" = $5,
+		"discrepancy" = $6,
+		"notes" = $7
+		WHERE session_id = $1`
+	_, err = tx.ExecContext(ctx, q, s.ID,
+		s.ClosedBy, s.ClosedAt,
+		s.Expected, s.Counted,
+		s.Discrepancy, s.Notes,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "closing drawer session")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing drawer session")
+	}
+
+	return &s, nil
+}
+
+// expectedCash gives the float of a Session plus the cash payments for the
+// sales of its organization recorded from its opening up to now.
+func expectedCash(ctx context.Context, db sqlx.QueryerContext, s *Session, now time.Time) (int, error) {
+
+	var cash int
+	const q = `SELECT COALESCE(SUM(p.amount), 0) FROM payments AS p
+			   JOIN sales AS s ON s.sale_id = p.sale_id
+			   WHERE p.method = $1 AND p.date_created >= $2 AND p.date_created <= $3
+			   AND s.org_id = $4`
+	if err := sqlx.GetContext(ctx, db, &cash, q, payment.MethodCash, s.OpenedAt, now.UTC(), s.OrgID); err != nil {
+		return 0, errors.Wrap(err, "summing cash payments")
+	}
+
+	return s.Float + cash, nil
+}
+
+// subject gives the id of the user of the claims, or nil for the claims the
+// sales-admin tool acts with.
+func subject(claims auth.Claims) *string {
+
+	if claims.Subject == "" {
+		return nil
+	}
+	return &claims.Subject
+}
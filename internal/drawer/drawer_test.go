@@ -0,0 +1,141 @@
+package drawer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/drawer"
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/payment"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/product"
+	"github.com/devisions/garagesale/internal/tests"
+)
+
+func TestDrawer(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	claims := auth.NewClaims(
+		"718ffbea-f4a1-4667-8ae3-b349da52675e", // A random UUID.
+		[]string{auth.RoleAdmin, auth.RoleUser},
+		now, time.Hour,
+	)
+	claims.OrgID = org.Default
+
+	s, err := drawer.Open(ctx, db, claims, drawer.NewSession{Float: 100}, now)
+	if err != nil {
+		t.Fatalf("opening drawer: %s", err)
+	}
+	if _, err := drawer.Open(ctx, db, claims, drawer.NewSession{Float: 100}, now); err != drawer.ErrAlreadyOpen {
+		t.Fatalf("expected ErrAlreadyOpen, got %v", err)
+	}
+
+	lamp, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Lamp", Cost: 20, Quantity: 3}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+
+	// One sale paid in cash, one paid by card, that must not count.
+	if _, err := product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1, Paid: 20}, lamp.ID, now.Add(time.Hour)); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	ns := product.NewSale{
+		Quantity: 1,
+		Paid:     20,
+		Payments: []payment.NewPayment{{Method: payment.MethodCard, Amount: 20}},
+	}
+	if _, err := product.AddSale(ctx, db, claims, ns, lamp.ID, now.Add(time.Hour)); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	s, err = drawer.Close(ctx, db, claims, s.ID, drawer.CloseSession{Counted: 115}, now.Add(8*time.Hour))
+	if err != nil {
+		t.Fatalf("closing drawer: %s", err)
+	}
+	if exp, got := 120, *s.Expected; exp != got {
+		t.Fatalf("expected cash %v, got %v", exp, got)
+	}
+	if exp, got := -5, *s.Discrepancy; exp != got {
+		t.Fatalf("expected discrepancy %v, got %v", exp, got)
+	}
+
+	if _, err := drawer.Current(ctx, db, claims, now); err != drawer.ErrNotOpen {
+		t.Fatalf("expected ErrNotOpen, got %v", err)
+	}
+	if _, err := drawer.Close(ctx, db, claims, s.ID, drawer.CloseSession{Counted: 115}, now); err != drawer.ErrAlreadyClosed {
+		t.Fatalf("expected ErrAlreadyClosed, got %v", err)
+	}
+}
+
+func TestDrawerTenancy(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	other, err := org.Create(ctx, db, org.NewOrg{Name: "Other Gophers"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	owner := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+	owner.OrgID = org.Default
+	stranger := auth.NewClaims("2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37", []string{auth.RoleAdmin}, now, time.Hour)
+	stranger.OrgID = other.ID
+	stranger.Permissions = []string{auth.PermDrawerManage}
+
+	mine, err := drawer.Open(ctx, db, owner, drawer.NewSession{Float: 100}, now)
+	if err != nil {
+		t.Fatalf("opening drawer: %s", err)
+	}
+
+	// Every organization has a drawer of its own.
+	theirs, err := drawer.Open(ctx, db, stranger, drawer.NewSession{Float: 50}, now)
+	if err != nil {
+		t.Fatalf("opening drawer of another organization: %s", err)
+	}
+	if _, err := drawer.Retrieve(ctx, db, stranger, mine.ID, now); err != drawer.ErrNotFound {
+		t.Fatalf("expected ErrNotFound retrieving another organization's session, got %v", err)
+	}
+	list, err := drawer.List(ctx, db, stranger)
+	if err != nil {
+		t.Fatalf("listing drawer sessions: %s", err)
+	}
+	if len(list) != 1 || list[0].ID != theirs.ID {
+		t.Fatalf("expected only the session of the organization, got %+v", list)
+	}
+	if _, err := drawer.Close(ctx, db, stranger, mine.ID, drawer.CloseSession{Counted: 0}, now); err != drawer.ErrNotFound {
+		t.Fatalf("expected ErrNotFound closing another organization's session, got %v", err)
+	}
+
+	// Cash taken by one organization is not expected in the drawer of another.
+	lamp, err := product.Create(ctx, db, owner, product.NewProduct{Name: "Lamp", Cost: 20, Quantity: 3}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+	if _, err := product.AddSale(ctx, db, owner, product.NewSale{Quantity: 1, Paid: 20}, lamp.ID, now.Add(time.Hour)); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	current, err := drawer.Current(ctx, db, stranger, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("getting open session: %s", err)
+	}
+	if exp, got := 50, *current.Expected; exp != got {
+		t.Fatalf("expected cash %v, got %v", exp, got)
+	}
+	current, err = drawer.Current(ctx, db, owner, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("getting open session: %s", err)
+	}
+	if exp, got := 120, *current.Expected; exp != got {
+		t.Fatalf("expected cash %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,36 @@
+package drawer
+
+import "time"
+
+// Session is the time between opening the cash drawer with a Float and
+// closing it. On close the cash in the drawer is Counted and compared to the
+// Expected cash, being the Float plus the cash payments recorded during the
+// Session. Discrepancy is Counted minus Expected, so a negative value means
+// cash is missing.
+//
+// OpenedBy and ClosedBy hold the ids of the users who did so. They are empty
+// when that was done from the sales-admin tool.
+type Session struct {
+	ID          string     `db:"session_id"    json:"id"`
+	OrgID       string     `db:"org_id"        json:"org_id"`
+	Float       int        `db:"float"         json:"float"`
+	OpenedBy    *string    `db:"opened_by"     json:"opened_by"`
+	OpenedAt    time.Time  `db:"opened_at"     json:"opened_at"`
+	ClosedBy    *string    `db:"closed_by"     json:"closed_by"`
+	ClosedAt    *time.Time `db:"closed_at"     json:"closed_at"`
+	Expected    *int       `db:"expected"      json:"expected"`
+	Counted     *int       `db:"counted"       json:"counted"`
+	Discrepancy *int       `db:"discrepancy"   json:"discrepancy"`
+	Notes       string     `db:"notes"         json:"notes"`
+}
+
+// NewSession is what we require for opening the cash drawer.
+type NewSession struct {
+	Float int `json:"float"  validate:"gte=0"`
+}
+
+// CloseSession is what we require for closing the cash drawer.
+type CloseSession struct {
+	Counted int    `json:"counted"  validate:"gte=0"`
+	Notes   string `json:"notes"`
+}
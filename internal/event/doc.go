@@ -0,0 +1,3 @@
+// Package event implements all business logic regarding garage sale events,
+// the distinct sale days that products are sold at.
+package event
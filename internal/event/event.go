@@ -0,0 +1,194 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrNotFound     = errors.New("event not found")
+	ErrInvalidID    = errors.New("provided id is not a valid UUID")
+	ErrInvalidDates = errors.New("event must end after it starts")
+)
+
+// List returns all Events of the organization of the user, the most recent
+// first.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]Event, error) {
+
+	list := []Event{}
+
+	const q = `SELECT * FROM events WHERE ($1::uuid IS NULL OR org_id = $1) ORDER BY starts_at DESC`
+	if err := db.SelectContext(ctx, &list, q, claims.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting all events")
+	}
+	return list, nil
+}
+
+// Retrieve returns a single Event. Events of other organizations than the
+// one of the user are not found.
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) (*Event, error) {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var e Event
+	const q = `SELECT * FROM events WHERE event_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if err := db.GetContext(ctx, &e, q, id, claims.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting single event")
+	}
+	return &e, nil
+}
+
+// Active gives the Event with the id, of an organization, if sales made at
+// the time should be attributed to it: it is active and takes place then. It
+// returns nil otherwise, or if no id is given. It can be used within a
+// transaction.
+func Active(ctx context.Context, db sqlx.QueryerContext, orgID string, id *string, now time.Time) (*Event, error) {
+
+	if id == nil {
+		return nil, nil
+	}
+
+	var e Event
+	const q = `SELECT * FROM events
+			   WHERE event_id = $1 AND org_id = $2 AND status = $3
+			   AND starts_at <= $4 AND ends_at > $4`
+	if err := sqlx.GetContext(ctx, db, &e, q, *id, orgID, StatusActive, now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "selecting active event")
+	}
+	return &e, nil
+}
+
+// Create makes a new Event in the organization of the user.
+func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, ne NewEvent, now time.Time) (*Event, error) {
+
+	e := Event{
+		ID:          uuid.New().String(),
+		OrgID:       claims.OrgID,
+		Name:        ne.Name,
+		Location:    ne.Location,
+		StartsAt:    ne.StartsAt.UTC(),
+		EndsAt:      ne.EndsAt.UTC(),
+		Status:      StatusPlanned,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	const q = `INSERT INTO events
+		(event_id, org_id, name, location, starts_at, ends_at, status, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := db.ExecContext(ctx, q,
+		e.ID, e.OrgID, e.Name, e.Location,
+		e.StartsAt, e.EndsAt, e.Status,
+		e.DateCreated, e.DateUpdated,
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "inserting event: %v", ne)
+	}
+
+	return &e, nil
+}
+
+// Update modifies data about an Event. Setting its status to closed is how
+// the books of an Event are closed.
+func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, update UpdateEvent, now time.Time) error {
+
+	e, err := Retrieve(ctx, db, claims, id)
+	if err != nil {
+		return err
+	}
+
+	if update.Name != nil {
+		e.Name = *update.Name
+	}
+	if update.Location != nil {
+		e.Location = *update.Location
+	}
+	if update.StartsAt != nil {
+		e.StartsAt = update.StartsAt.UTC()
+	}
+	if update.EndsAt != nil {
+		e.EndsAt = update.EndsAt.UTC()
+	}
+	if update.Status != nil {
+		e.Status = *update.Status
+	}
+	if !e.EndsAt.After(e.StartsAt) {
+		return ErrInvalidDates
+	}
+	e.DateUpdated = now.UTC()
+
+	const q = `UPDATE events SET
+		"name" = $2,
+		"location" = $3,
+		"starts_at" = $4,
+		"ends_at" = $5,
+		"status" = $6,
+		"date_updated" = $7
+		WHERE event_id = $1`
+	_, err = db.ExecContext(ctx, q, id,
+		e.Name, e.Location,
+		e.StartsAt, e.EndsAt,
+		e.Status, e.DateUpdated,
+	)
+	if err != nil {
+		return errors.Wrap(err, "updating event")
+	}
+
+	return nil
+}
+
+// Delete removes the Event identified by a given ID. Its products and sales
+// are kept, but they are no longer attributed to any Event. Events of other
+// organizations than the one of the user are left alone.
+func Delete(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) error {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM events WHERE event_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if _, err := db.ExecContext(ctx, q, id, claims.Tenant()); err != nil {
+		return errors.Wrapf(err, "deleting event %s", id)
+	}
+
+	return nil
+}
+
+// Summarize gives the totals of the Event identified by a given ID. Only the
+// products and sales of the organization of the Event are counted.
+func Summarize(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) (*Summary, error) {
+
+	e, err := Retrieve(ctx, db, claims, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Summary
+	const q = `SELECT $1::UUID AS event_id,
+			   (SELECT COUNT(*) FROM products WHERE event_id = $1 AND org_id = $2) AS products,
+			   COUNT(*) AS sales,
+			   COALESCE(SUM(quantity), 0) AS sold,
+			   COALESCE(SUM(paid), 0) AS revenue,
+			   COALESCE(SUM(tax), 0) AS tax
+			   FROM sales
+			   WHERE event_id = $1 AND org_id = $2`
+	if err := db.GetContext(ctx, &s, q, id, e.OrgID); err != nil {
+		return nil, errors.Wrap(err, "selecting event summary")
+	}
+
+	return &s, nil
+}
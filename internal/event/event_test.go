@@ -0,0 +1,199 @@
+package event_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/event"
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/product"
+	"github.com/devisions/garagesale/internal/tests"
+)
+
+func TestEvents(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	claims := auth.NewClaims(
+		"718ffbea-f4a1-4667-8ae3-b349da52675e", // A random UUID.
+		[]string{auth.RoleAdmin, auth.RoleUser},
+		now, time.Hour,
+	)
+	claims.OrgID = org.Default
+
+	spring, err := event.Create(ctx, db, claims, event.NewEvent{Name: "Spring", StartsAt: now, EndsAt: now.Add(8 * time.Hour)}, now)
+	if err != nil {
+		t.Fatalf("creating event: %s", err)
+	}
+	autumn, err := event.Create(ctx, db, claims, event.NewEvent{Name: "Autumn", StartsAt: now, EndsAt: now.Add(8 * time.Hour)}, now)
+	if err != nil {
+		t.Fatalf("creating event: %s", err)
+	}
+
+	active := event.StatusActive
+	for _, id := range []string{spring.ID, autumn.ID} {
+		if err := event.Update(ctx, db, claims, id, event.UpdateEvent{Status: &active}, now); err != nil {
+			t.Fatalf("activating event: %s", err)
+		}
+	}
+
+	comics, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Comic Books", Cost: 10, Quantity: 20, EventID: &autumn.ID}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+
+	// Both events are active, the sale goes to the one the product is
+	// assigned to.
+	s, err := product.AddSale(ctx, db, claims, product.NewSale{Quantity: 2, Paid: 20}, comics.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.EventID == nil || *s.EventID != autumn.ID {
+		t.Fatalf("expected sale to be attributed to %q, got %v", autumn.ID, s.EventID)
+	}
+
+	summary, err := event.Summarize(ctx, db, claims, autumn.ID)
+	if err != nil {
+		t.Fatalf("summarizing event: %s", err)
+	}
+	want := event.Summary{EventID: autumn.ID, Products: 1, Sales: 1, Sold: 2, Revenue: 20}
+	if *summary != want {
+		t.Fatalf("expected summary %+v, got %+v", want, *summary)
+	}
+
+	summary, err = event.Summarize(ctx, db, claims, spring.ID)
+	if err != nil {
+		t.Fatalf("summarizing event: %s", err)
+	}
+	if exp, got := 0, summary.Sales; exp != got {
+		t.Fatalf("expected %v sales at the other event, got %v", exp, got)
+	}
+
+	// Sales of products assigned to no event, or made while their event does
+	// not take place, are attributed to none.
+	games, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Games", Cost: 40, Quantity: 30}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+	s, err = product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1, Paid: 40}, games.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.EventID != nil {
+		t.Fatalf("expected sale of a product without event not to be attributed, got %v", *s.EventID)
+	}
+	s, err = product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1, Paid: 10}, comics.ID, now.Add(9*time.Hour))
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.EventID != nil {
+		t.Fatalf("expected sale after the event ended not to be attributed, got %v", *s.EventID)
+	}
+
+	// Once closed, the event no longer takes sales.
+	closed := event.StatusClosed
+	for _, id := range []string{spring.ID, autumn.ID} {
+		if err := event.Update(ctx, db, claims, id, event.UpdateEvent{Status: &closed}, now); err != nil {
+			t.Fatalf("closing event: %s", err)
+		}
+	}
+	s, err = product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1, Paid: 10}, comics.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.EventID != nil {
+		t.Fatalf("expected sale not to be attributed to any event, got %v", *s.EventID)
+	}
+
+	before := now.Add(-time.Hour)
+	if err := event.Update(ctx, db, claims, spring.ID, event.UpdateEvent{EndsAt: &before}, now); err != event.ErrInvalidDates {
+		t.Fatalf("expected ErrInvalidDates, got %v", err)
+	}
+}
+
+func TestEventTenancy(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	other, err := org.Create(ctx, db, org.NewOrg{Name: "Other Gophers"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	owner := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+	owner.OrgID = org.Default
+	stranger := auth.NewClaims("2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37", []string{auth.RoleAdmin}, now, time.Hour)
+	stranger.OrgID = other.ID
+	stranger.Permissions = []string{auth.PermEventWrite, auth.PermReportRead}
+	super := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleSuperAdmin}, now, time.Hour)
+	super.OrgID = other.ID
+	super.Permissions = []string{auth.PermOrgManage}
+
+	fair, err := event.Create(ctx, db, owner, event.NewEvent{Name: "Fair", StartsAt: now, EndsAt: now.Add(8 * time.Hour)}, now)
+	if err != nil {
+		t.Fatalf("creating event: %s", err)
+	}
+	active := event.StatusActive
+	if err := event.Update(ctx, db, owner, fair.ID, event.UpdateEvent{Status: &active}, now); err != nil {
+		t.Fatalf("activating event: %s", err)
+	}
+
+	if _, err := event.Retrieve(ctx, db, stranger, fair.ID); err != event.ErrNotFound {
+		t.Fatalf("expected ErrNotFound retrieving another organization's event, got %v", err)
+	}
+	list, err := event.List(ctx, db, stranger)
+	if err != nil {
+		t.Fatalf("listing events: %s", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no events of another organization, got %d", len(list))
+	}
+	if _, err := event.Summarize(ctx, db, stranger, fair.ID); err != event.ErrNotFound {
+		t.Fatalf("expected ErrNotFound summarizing another organization's event, got %v", err)
+	}
+	if err := event.Update(ctx, db, stranger, fair.ID, event.UpdateEvent{Status: &active}, now); err != event.ErrNotFound {
+		t.Fatalf("expected ErrNotFound updating another organization's event, got %v", err)
+	}
+	if err := event.Delete(ctx, db, stranger, fair.ID); err != nil {
+		t.Fatalf("deleting event: %s", err)
+	}
+
+	// Products of another organization can neither be assigned to the event
+	// nor have their sales attributed to it.
+	np := product.NewProduct{Name: "Games", Cost: 40, Quantity: 30, EventID: &fair.ID}
+	if _, err := product.Create(ctx, db, stranger, np, now); err != product.ErrUnknownEvent {
+		t.Fatalf("expected ErrUnknownEvent assigning a product to another organization's event, got %v", err)
+	}
+	np.EventID = nil
+	games, err := product.Create(ctx, db, stranger, np, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	s, err := product.AddSale(ctx, db, stranger, product.NewSale{Quantity: 1, Paid: 40}, games.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.EventID != nil {
+		t.Fatalf("expected the sale not to be attributed to another organization's event, got %v", *s.EventID)
+	}
+
+	// A super admin reaches every organization, but the summary only counts
+	// the sales of the organization of the event.
+	summary, err := event.Summarize(ctx, db, super, fair.ID)
+	if err != nil {
+		t.Fatalf("super admin summarizing event: %s", err)
+	}
+	if summary.Sales != 0 {
+		t.Fatalf("expected no sales at the event, got %+v", summary)
+	}
+}
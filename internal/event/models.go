@@ -0,0 +1,55 @@
+package event
+
+import "time"
+
+// These are the possible values of Event.Status. Sales are only attributed
+// to active events, and closed events keep their books as they are.
+const (
+	StatusPlanned = "planned"
+	StatusActive  = "active"
+	StatusClosed  = "closed"
+)
+
+// Event is a distinct sale day (or days) that products are sold at.
+type Event struct {
+	ID          string    `db:"event_id"      json:"id"`
+	OrgID       string    `db:"org_id"        json:"org_id"`
+	Name        string    `db:"name"          json:"name"`
+	Location    string    `db:"location"      json:"location"`
+	StartsAt    time.Time `db:"starts_at"     json:"starts_at"`
+	EndsAt      time.Time `db:"ends_at"       json:"ends_at"`
+	Status      string    `db:"status"        json:"status"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+	DateUpdated time.Time `db:"date_updated"  json:"date_updated"`
+}
+
+// NewEvent is the input request for creating a new Event. New events are
+// always planned.
+type NewEvent struct {
+	Name     string    `json:"name"       validate:"required"`
+	Location string    `json:"location"`
+	StartsAt time.Time `json:"starts_at"  validate:"required"`
+	EndsAt   time.Time `json:"ends_at"    validate:"required,gtfield=StartsAt"`
+}
+
+// UpdateEvent defines what information may be provided to modify an existing
+// Event. All fields are optional so clients can send just the fields they
+// want changed.
+type UpdateEvent struct {
+	Name     *string    `json:"name"       validate:"omitempty,min=1"`
+	Location *string    `json:"location"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+	Status   *string    `json:"status"     validate:"omitempty,oneof=planned active closed"`
+}
+
+// Summary totals what happened at an Event. Sold is the number of units sold
+// and Revenue is the sum of what was paid for them.
+type Summary struct {
+	EventID  string `db:"event_id"  json:"event_id"`
+	Products int    `db:"products"  json:"products"`
+	Sales    int    `db:"sales"     json:"sales"`
+	Sold     int    `db:"sold"      json:"sold"`
+	Revenue  int    `db:"revenue"   json:"revenue"`
+	Tax      int    `db:"tax"       json:"tax"`
+}
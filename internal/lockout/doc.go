@@ -0,0 +1,5 @@
+// Package lockout implements all business logic regarding the protection of
+// authentication against brute force: failed attempts are tracked per account
+// and per client IP, each one delaying the next attempt a bit more, until a
+// temporary lockout.
+package lockout
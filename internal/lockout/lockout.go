@@ -0,0 +1,259 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrNotFound = errors.New("lockout not found")
+)
+
+// Penalty gives how long attempts are refused after the given number of
+// consecutive failures for a Key of the given kind.
+func (p Policy) Penalty(kind string, failures int) time.Duration {
+
+	if p.locks(kind, failures) {
+		return p.Duration
+	}
+
+	if p.Delay <= 0 || failures <= 0 {
+		return 0
+	}
+	d := p.Delay
+	for i := 1; i < failures && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// locks tells whether the given number of consecutive failures reaches the
+// threshold of the kind of Key.
+func (p Policy) locks(kind string, failures int) bool {
+
+	threshold := p.AccountThreshold
+	if kind == KindIP {
+		threshold = p.IPThreshold
+	}
+	return threshold > 0 && failures >= threshold
+}
+
+// Attempt reserves an attempt for each of the keys, counting it as failed
+// until told otherwise by Fail or Succeed. It gives until when attempts are
+// refused if any of the keys is blocked, in which case nothing is counted, and
+// the zero time if the attempt may go on. Delays only start once a failure is
+// confirmed, so attempts made at the same time do not hold each other up, but
+// an attempt reaching the threshold locks right away: as it is counted before
+// it is checked, parallel attempts cannot get past the threshold.
+func Attempt(ctx context.Context, db *sqlx.DB, p Policy, now time.Time, keys ...Key) (time.Time, error) {
+
+	now = now.UTC()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	// The rows of the keys are locked, so attempts for the same keys wait for
+	// this one to be counted.
+	var until time.Time
+	list := make([]Lockout, len(keys))
+	for i, k := range keys {
+		const qi = `INSERT INTO login_failures
+			(kind, subject, failures, last_failure, locked_until)
+			VALUES ($1, $2, 0, $3, $3)
+			ON CONFLICT (kind, subject) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, qi, k.Kind, k.Subject, now); err != nil {
+			return time.Time{}, errors.Wrap(err, "inserting login failures")
+		}
+
+		const qs = `SELECT * FROM login_failures WHERE kind = $1 AND subject = $2 FOR UPDATE`
+		if err := tx.GetContext(ctx, &list[i], qs, k.Kind, k.Subject); err != nil {
+			return time.Time{}, errors.Wrap(err, "selecting login failures")
+		}
+		if list[i].LockedUntil.After(now) && list[i].LockedUntil.After(until) {
+			until = list[i].LockedUntil
+		}
+	}
+	if !until.IsZero() {
+		return until, nil
+	}
+
+	for _, l := range list {
+
+		// Failures that happened before the window are not counted anymore.
+		failures := l.Failures + 1
+		if l.LastFailure.Before(now.Add(-p.Window)) {
+			failures = 1
+		}
+
+		lockedUntil := l.LockedUntil
+		if p.locks(l.Kind, failures) {
+			lockedUntil = now.Add(p.Duration)
+		}
+
+		const q = `UPDATE login_failures SET
+			failures = $3, last_failure = $4, locked_until = $5
+			WHERE kind = $1 AND subject = $2`
+		if _, err := tx.ExecContext(ctx, q, l.Kind, l.Subject, failures, now, lockedUntil); err != nil {
+			return time.Time{}, errors.Wrap(err, "recording attempt")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, errors.Wrap(err, "committing attempt")
+	}
+
+	return time.Time{}, nil
+}
+
+// Fail confirms the attempt reserved by Attempt for each of the keys failed,
+// refusing further attempts for as long as the policy tells.
+func Fail(ctx context.Context, db *sqlx.DB, p Policy, now time.Time, keys ...Key) error {
+
+	now = now.UTC()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	for _, k := range keys {
+
+		// The failures were cleared meanwhile if the row is gone.
+		var l Lockout
+		const qs = `SELECT * FROM login_failures WHERE kind = $1 AND subject = $2 FOR UPDATE`
+		if err := tx.GetContext(ctx, &l, qs, k.Kind, k.Subject); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return errors.Wrap(err, "selecting login failures")
+		}
+
+		if until := now.Add(p.Penalty(l.Kind, l.Failures)); until.After(l.LockedUntil) {
+			l.LockedUntil = until
+		}
+
+		const q = `UPDATE login_failures SET last_failure = $3, locked_until = $4
+			WHERE kind = $1 AND subject = $2`
+		if _, err := tx.ExecContext(ctx, q, l.Kind, l.Subject, now, l.LockedUntil); err != nil {
+			return errors.Wrap(err, "recording login failure")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing failure")
+	}
+
+	return nil
+}
+
+// Succeed takes back the attempt reserved by Attempt for each of the keys
+// after it succeeded. The failed attempts of accounts are forgotten. Client
+// IPs only get the attempt back: forgetting their failures would let anyone
+// owning one account guess the passwords of others from that IP.
+func Succeed(ctx context.Context, db *sqlx.DB, p Policy, now time.Time, keys ...Key) error {
+
+	now = now.UTC()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	for _, k := range keys {
+
+		if k.Kind == KindAccount {
+			const q = `DELETE FROM login_failures WHERE kind = $1 AND subject = $2`
+			if _, err := tx.ExecContext(ctx, q, k.Kind, k.Subject); err != nil {
+				return errors.Wrap(err, "deleting login failures")
+			}
+			continue
+		}
+
+		var l Lockout
+		const qs = `SELECT * FROM login_failures WHERE kind = $1 AND subject = $2 FOR UPDATE`
+		if err := tx.GetContext(ctx, &l, qs, k.Kind, k.Subject); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return errors.Wrap(err, "selecting login failures")
+		}
+
+		// The lock the attempt set is lifted, but not the ones other failures
+		// set.
+		if l.Failures > 0 {
+			l.Failures--
+		}
+		if until := now.Add(p.Penalty(l.Kind, l.Failures)); until.Before(l.LockedUntil) {
+			l.LockedUntil = until
+		}
+
+		const q = `UPDATE login_failures SET failures = $3, locked_until = $4
+			WHERE kind = $1 AND subject = $2`
+		if _, err := tx.ExecContext(ctx, q, l.Kind, l.Subject, l.Failures, l.LockedUntil); err != nil {
+			return errors.Wrap(err, "taking back attempt")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing success")
+	}
+
+	return nil
+}
+
+// tenantQuery restricts login failures to the accounts of the users of an
+// organization, unless none is given. Client IPs and unknown accounts belong
+// to no organization.
+const tenantQuery = `($1::uuid IS NULL OR (kind = 'account' AND EXISTS (
+	SELECT 1 FROM users AS u WHERE lower(u.email) = subject AND u.org_id = $1)))`
+
+// List gives the keys for which attempts are currently refused, the ones
+// locked for the longest first. Only the accounts of the organization of the
+// user are listed, unless they may cross tenants.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims, now time.Time) ([]Lockout, error) {
+
+	list := []Lockout{}
+
+	const q = `SELECT * FROM login_failures WHERE ` + tenantQuery + `
+		AND locked_until > $2 ORDER BY locked_until DESC`
+	if err := db.SelectContext(ctx, &list, q, claims.Tenant(), now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "selecting lockouts")
+	}
+	return list, nil
+}
+
+// Clear forgets the failed attempts of a Key, allowing attempts right away.
+// Only the accounts of the organization of the user are found, unless they
+// may cross tenants.
+func Clear(ctx context.Context, db *sqlx.DB, claims auth.Claims, k Key) error {
+
+	const q = `DELETE FROM login_failures WHERE ` + tenantQuery + `
+		AND kind = $2 AND subject = $3`
+	res, err := db.ExecContext(ctx, q, claims.Tenant(), k.Kind, k.Subject)
+	if err != nil {
+		return errors.Wrap(err, "deleting login failures")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "deleting login failures")
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
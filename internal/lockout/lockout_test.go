@@ -0,0 +1,261 @@
+package lockout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/lockout"
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/tests"
+	"github.com/devisions/garagesale/internal/user"
+)
+
+func TestPenalty(t *testing.T) {
+
+	p := lockout.Policy{
+		AccountThreshold: 5,
+		IPThreshold:      10,
+		Delay:            time.Second,
+		MaxDelay:         10 * time.Second,
+		Duration:         time.Hour,
+	}
+
+	tests := []struct {
+		kind     string
+		failures int
+		want     time.Duration
+	}{
+		{lockout.KindAccount, 0, 0},
+		{lockout.KindAccount, 1, time.Second},
+		{lockout.KindAccount, 2, 2 * time.Second},
+		{lockout.KindAccount, 4, 8 * time.Second},
+		{lockout.KindAccount, 5, time.Hour},
+		{lockout.KindIP, 5, 10 * time.Second},
+		{lockout.KindIP, 10, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := p.Penalty(tt.kind, tt.failures); got != tt.want {
+			t.Errorf("%s after %d failures: expected %v, got %v", tt.kind, tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	p := lockout.Policy{AccountThreshold: 3, Delay: time.Second, Duration: time.Hour, Window: time.Hour}
+	super := auth.Claims{Permissions: []string{auth.PermOrgManage}}
+	account := lockout.Account("Someone@Example.com")
+	ip := lockout.IP("192.0.2.1")
+
+	fail := func(at time.Time, keys ...lockout.Key) {
+		t.Helper()
+
+		until, err := lockout.Attempt(ctx, db, p, at, keys...)
+		if err != nil {
+			t.Fatalf("attempting: %s", err)
+		}
+		if !until.IsZero() {
+			t.Fatalf("expected the attempt at %v to be allowed, got blocked until %v", at, until)
+		}
+		if err := lockout.Fail(ctx, db, p, at, keys...); err != nil {
+			t.Fatalf("recording failure: %s", err)
+		}
+	}
+
+	fail(now, account, ip)
+	until, err := lockout.Attempt(ctx, db, p, now, lockout.Account("someone@example.com"))
+	if err != nil {
+		t.Fatalf("attempting: %s", err)
+	}
+	if want := now.Add(time.Second); !until.Equal(want) {
+		t.Fatalf("expected to be blocked until %v, got %v", want, until)
+	}
+
+	// Two more failures reach the threshold.
+	for i := 1; i <= 2; i++ {
+		fail(now.Add(time.Duration(i)*time.Minute), account, ip)
+	}
+	later := now.Add(10 * time.Minute)
+	until, err = lockout.Attempt(ctx, db, p, later, account, ip)
+	if err != nil {
+		t.Fatalf("attempting: %s", err)
+	}
+	if want := now.Add(2*time.Minute + time.Hour); !until.Equal(want) {
+		t.Fatalf("expected to be locked until %v, got %v", want, until)
+	}
+
+	list, err := lockout.List(ctx, db, super, later)
+	if err != nil {
+		t.Fatalf("listing lockouts: %s", err)
+	}
+	if len(list) != 1 || list[0].Kind != lockout.KindAccount || list[0].Failures != 3 {
+		t.Fatalf("expected the account to be the only lockout, got %+v", list)
+	}
+
+	if err := lockout.Clear(ctx, db, super, account); err != nil {
+		t.Fatalf("clearing lockout: %s", err)
+	}
+	if err := lockout.Clear(ctx, db, super, account); err != lockout.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	list, err = lockout.List(ctx, db, super, later)
+	if err != nil {
+		t.Fatalf("listing lockouts: %s", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no lockout, got %+v", list)
+	}
+
+	// Failures older than the window are forgotten.
+	fail(now.Add(3*time.Hour), ip)
+	list, err = lockout.List(ctx, db, super, now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("listing lockouts: %s", err)
+	}
+	if len(list) != 1 || list[0].Failures != 1 {
+		t.Fatalf("expected the ip to have a single failure, got %+v", list)
+	}
+}
+
+func TestAttempt(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	p := lockout.Policy{AccountThreshold: 3, IPThreshold: 10, Delay: time.Second, Duration: time.Hour, Window: time.Hour}
+	account := lockout.Account("someone@example.com")
+	ip := lockout.IP("192.0.2.1")
+
+	// Attempts made at the same time are not delayed by each other, but count
+	// as failed before they are checked, so the threshold holds for them.
+	for i := 0; i < 3; i++ {
+		until, err := lockout.Attempt(ctx, db, p, now, account, ip)
+		if err != nil {
+			t.Fatalf("attempting: %s", err)
+		}
+		if !until.IsZero() {
+			t.Fatalf("expected attempt %d to be allowed, got blocked until %v", i+1, until)
+		}
+	}
+	until, err := lockout.Attempt(ctx, db, p, now, account, ip)
+	if err != nil {
+		t.Fatalf("attempting: %s", err)
+	}
+	if want := now.Add(time.Hour); !until.Equal(want) {
+		t.Fatalf("expected to be locked until %v, got %v", want, until)
+	}
+
+	// A successful attempt forgets the failures of the account, but only
+	// takes its own back from the client IP.
+	other := lockout.Account("other@example.com")
+	if _, err := lockout.Attempt(ctx, db, p, now, other, ip); err != nil {
+		t.Fatalf("attempting: %s", err)
+	}
+	if err := lockout.Succeed(ctx, db, p, now, other, ip); err != nil {
+		t.Fatalf("succeeding: %s", err)
+	}
+	list, err := lockout.List(ctx, db, auth.Claims{Permissions: []string{auth.PermOrgManage}}, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("listing lockouts: %s", err)
+	}
+	failures := map[string]int{}
+	for _, l := range list {
+		failures[l.Subject] = l.Failures
+	}
+	if n, ok := failures[other.Subject]; ok {
+		t.Fatalf("expected the failures of the account to be forgotten, got %d", n)
+	}
+	if n := failures[ip.Subject]; n != 3 {
+		t.Fatalf("expected the ip to keep 3 failures, got %d", n)
+	}
+
+	// Once confirmed, a failure delays the next attempt.
+	late := lockout.Account("late@example.com")
+	if _, err := lockout.Attempt(ctx, db, p, now, late); err != nil {
+		t.Fatalf("attempting: %s", err)
+	}
+	if err := lockout.Fail(ctx, db, p, now, late); err != nil {
+		t.Fatalf("recording failure: %s", err)
+	}
+	until, err = lockout.Attempt(ctx, db, p, now, late)
+	if err != nil {
+		t.Fatalf("attempting: %s", err)
+	}
+	if want := now.Add(time.Second); !until.Equal(want) {
+		t.Fatalf("expected to be delayed until %v, got %v", want, until)
+	}
+}
+
+func TestLockoutTenancy(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	other, err := org.Create(ctx, db, org.NewOrg{Name: "Other Gophers"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+	nu := user.NewUser{
+		Name:            "Gopher",
+		Email:           "Gopher@Example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	if _, err := user.Create(ctx, db, user.Policy{}, nu, now); err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	admin := auth.Claims{OrgID: org.Default, Permissions: []string{auth.PermLockoutManage}}
+	stranger := auth.Claims{OrgID: other.ID, Permissions: []string{auth.PermLockoutManage}}
+
+	p := lockout.Policy{AccountThreshold: 1, Duration: time.Hour, Window: time.Hour}
+	account := lockout.Account(nu.Email)
+	keys := []lockout.Key{account, lockout.IP("192.0.2.1")}
+	if _, err := lockout.Attempt(ctx, db, p, now, keys...); err != nil {
+		t.Fatalf("attempting: %s", err)
+	}
+	if err := lockout.Fail(ctx, db, p, now, keys...); err != nil {
+		t.Fatalf("recording failure: %s", err)
+	}
+
+	// Admins of an organization only see the accounts of its users.
+	list, err := lockout.List(ctx, db, admin, now)
+	if err != nil {
+		t.Fatalf("listing lockouts: %s", err)
+	}
+	if len(list) != 1 || list[0].Kind != lockout.KindAccount {
+		t.Fatalf("expected the account to be the only lockout, got %+v", list)
+	}
+	list, err = lockout.List(ctx, db, stranger, now)
+	if err != nil {
+		t.Fatalf("listing lockouts: %s", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no lockouts of another organization, got %+v", list)
+	}
+	if err := lockout.Clear(ctx, db, stranger, account); err != lockout.ErrNotFound {
+		t.Fatalf("expected ErrNotFound clearing another organization's lockout, got %v", err)
+	}
+	if err := lockout.Clear(ctx, db, admin, lockout.IP("192.0.2.1")); err != lockout.ErrNotFound {
+		t.Fatalf("expected ErrNotFound clearing a client IP lockout, got %v", err)
+	}
+	if err := lockout.Clear(ctx, db, admin, account); err != nil {
+		t.Fatalf("clearing lockout: %s", err)
+	}
+}
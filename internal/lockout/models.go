@@ -0,0 +1,53 @@
+package lockout
+
+import (
+	"strings"
+	"time"
+)
+
+// Kinds of subjects failed attempts are tracked for.
+const (
+	KindAccount = "account"
+	KindIP      = "ip"
+)
+
+// Key identifies what failed attempts are tracked for: an account, by its
+// email, or a client IP.
+type Key struct {
+	Kind    string
+	Subject string
+}
+
+// Account gives the Key of the account with the given email.
+func Account(email string) Key {
+	return Key{Kind: KindAccount, Subject: strings.ToLower(email)}
+}
+
+// IP gives the Key of a client IP.
+func IP(addr string) Key {
+	return Key{Kind: KindIP, Subject: addr}
+}
+
+// Lockout holds the failed attempts tracked for a Key. No attempt is allowed
+// before LockedUntil.
+type Lockout struct {
+	Kind        string    `db:"kind"          json:"kind"`
+	Subject     string    `db:"subject"       json:"subject"`
+	Failures    int       `db:"failures"      json:"failures"`
+	LastFailure time.Time `db:"last_failure"  json:"last_failure"`
+	LockedUntil time.Time `db:"locked_until"  json:"locked_until"`
+}
+
+// Policy tells how failed attempts are penalized. After each failure the next
+// attempt is delayed by Delay, doubled for every further failure up to
+// MaxDelay. Reaching the threshold of the Key kind locks it for Duration.
+// Failures older than Window are forgotten. A zero threshold or Delay turns
+// the respective penalty off.
+type Policy struct {
+	AccountThreshold int
+	IPThreshold      int
+	Delay            time.Duration
+	MaxDelay         time.Duration
+	Duration         time.Duration
+	Window           time.Duration
+}
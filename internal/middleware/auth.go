@@ -19,8 +19,22 @@ var ErrForbidden = web.NewRequestError(
 	http.StatusForbidden,
 )
 
-// Authenticate validates a JWT from the `Authorization` header.
-func Authenticate(authenticator *auth.Authenticator) web.Middleware {
+// RevokedFunc tells whether the token with the given id (jti) was revoked.
+type RevokedFunc func(ctx context.Context, jti string) (bool, error)
+
+// APIKeyFunc gives the claims an API key acts with. Its errors are returned
+// as is, so it has to make request errors out of invalid keys.
+type APIKeyFunc func(ctx context.Context, key string) (auth.Claims, error)
+
+// ActiveFunc tells whether the user with the given id may still use the
+// system.
+type ActiveFunc func(ctx context.Context, userID string) (bool, error)
+
+// Authenticate validates a JWT from the `Authorization` header. Tokens that
+// were revoked before they expire are refused, as are those of users who are
+// no longer active. API keys are accepted as well, either from the
+// `X-API-Key` header or as bearer tokens.
+func Authenticate(authenticator *auth.Authenticator, revoked RevokedFunc, active ActiveFunc, apiKey APIKeyFunc) web.Middleware {
 
 	// This is the actual middleware function to be executed.
 	f := func(next web.AppHandler) web.AppHandler {
@@ -31,6 +45,10 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 			ctx, span := trace.StartSpan(ctx, "internal.middleware.Authenticate")
 			defer span.End()
 
+			if key := r.Header.Get("X-API-Key"); key != "" {
+				return withAPIKey(ctx, w, r, apiKey, key, next)
+			}
+
 			// Parse the authorization header. Expected header is of
 			// the format `Bearer <token>`.
 			parts := strings.Split(r.Header.Get("Authorization"), " ")
@@ -39,11 +57,44 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 				return web.NewRequestError(err, http.StatusUnauthorized)
 			}
 
+			// A JWT has three dot separated parts, which API keys do not.
+			if strings.Count(parts[1], ".") != 2 {
+				return withAPIKey(ctx, w, r, apiKey, parts[1], next)
+			}
+
 			claims, err := authenticator.ParseClaims(parts[1])
 			if err != nil {
 				return web.NewRequestError(err, http.StatusUnauthorized)
 			}
 
+			if claims.Id != "" {
+				isRevoked, err := revoked(ctx, claims.Id)
+				if err != nil {
+					return errors.Wrap(err, "checking token revocation")
+				}
+				if isRevoked {
+					err := errors.New("token was revoked")
+					return web.NewRequestError(err, http.StatusUnauthorized)
+				}
+			}
+
+			isActive, err := active(ctx, claims.Subject)
+			if err != nil {
+				return errors.Wrap(err, "checking user status")
+			}
+			if !isActive {
+				err := errors.New("user account is not active")
+				return web.NewRequestError(err, http.StatusUnauthorized)
+			}
+
+			// The real actor of an impersonated request has to show in the
+			// logs.
+			if claims.Impersonated() {
+				if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+					v.Actor = claims.Act.Subject
+				}
+			}
+
 			// Add claims to the context so they can be retrieved later.
 			ctx = context.WithValue(ctx, auth.Key, claims)
 
@@ -56,6 +107,106 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 	return f
 }
 
+// withAPIKey calls the next handler with the claims of an API key.
+func withAPIKey(ctx context.Context, w http.ResponseWriter, r *http.Request, apiKey APIKeyFunc, key string, next web.AppHandler) error {
+
+	claims, err := apiKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	ctx = context.WithValue(ctx, auth.Key, claims)
+	return next(ctx, w, r)
+}
+
+// RequirePermission validates that the claims of an authenticated user grant
+// the specified permission. This method constructs the actual function that is
+// used.
+func RequirePermission(permission string) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.AppHandler) web.AppHandler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.RequirePermission")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: RequirePermission called without/before Authenticate")
+			}
+			if !claims.HasPermission(permission) {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// NotImpersonated refuses impersonated requests, for actions that only the
+// user themselves may take, like managing their credentials.
+func NotImpersonated() web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.AppHandler) web.AppHandler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.NotImpersonated")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: NotImpersonated called without/before Authenticate")
+			}
+			if claims.Impersonated() {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// NotAPIKey refuses requests authenticated with an API key, for actions that
+// a leaked key must not allow, like creating other keys.
+func NotAPIKey() web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.AppHandler) web.AppHandler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.NotAPIKey")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: NotAPIKey called without/before Authenticate")
+			}
+			if claims.FromAPIKey() {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
 // HasRole validates that an authenticated user has at least one role from a
 // specified list. This method constructs the actual function that is used.
 func HasRole(roles ...string) web.Middleware {
@@ -84,3 +235,35 @@ func HasRole(roles ...string) web.Middleware {
 
 	return f
 }
+
+// SeenFunc records that the user of the claims made a request.
+type SeenFunc func(ctx context.Context, claims auth.Claims, r *http.Request) error
+
+// TrackSeen calls seen for every authenticated request. It has to come after
+// Authenticate.
+func TrackSeen(seen SeenFunc) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.AppHandler) web.AppHandler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.TrackSeen")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: TrackSeen called without/before Authenticate")
+			}
+			if err := seen(ctx, claims, r); err != nil {
+				return errors.Wrap(err, "tracking user")
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
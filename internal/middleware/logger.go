@@ -11,7 +11,8 @@ import (
 )
 
 // RequestLogger writes some information about the request to the logs in the
-// format: TraceID : (200) GET /foo -> IP ADDR (latency)
+// format: TraceID : (200) GET /foo -> IP ADDR (latency), followed by the real
+// actor of impersonated requests.
 func RequestLogger(log *log.Logger) web.Middleware {
 
 	// This is the actual middleware function to be executed.
@@ -30,11 +31,20 @@ func RequestLogger(log *log.Logger) web.Middleware {
 			// Run the handler chain and catch any propagated error.
 			err := before(ctx, w, r)
 
-			log.Printf("%s | %d | %s %s -> %s (%s)",
-				v.TraceID, v.StatusCode,
-				r.Method, r.URL.Path,
-				r.RemoteAddr, time.Since(v.Start),
-			)
+			if v.Actor == "" {
+				log.Printf("%s | %d | %s %s -> %s (%s)",
+					v.TraceID, v.StatusCode,
+					r.Method, r.URL.Path,
+					r.RemoteAddr, time.Since(v.Start),
+				)
+			} else {
+				log.Printf("%s | %d | %s %s -> %s (%s) | actor %s",
+					v.TraceID, v.StatusCode,
+					r.Method, r.URL.Path,
+					r.RemoteAddr, time.Since(v.Start),
+					v.Actor,
+				)
+			}
 
 			// Return the (possible) error to be handled further up the chain.
 			return err
@@ -0,0 +1,4 @@
+// Package org implements all business logic regarding organizations, the
+// tenants sharing a deployment. Each organization only sees its own users,
+// products and sales.
+package org
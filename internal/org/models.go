@@ -0,0 +1,16 @@
+package org
+
+import "time"
+
+// Org is an organization using the garage sale.
+type Org struct {
+	ID          string    `db:"org_id"        json:"id"`
+	Name        string    `db:"name"          json:"name"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+	DateUpdated time.Time `db:"date_updated"  json:"date_updated"`
+}
+
+// NewOrg is what we require from clients when adding an Org.
+type NewOrg struct {
+	Name string `json:"name"  validate:"required"`
+}
@@ -0,0 +1,75 @@
+package org
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Default is the organization existing data and self registered users belong
+// to.
+const Default = "8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01"
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrNotFound  = errors.New("organization not found")
+	ErrInvalidID = errors.New("provided id is not a valid UUID")
+	ErrExists    = errors.New("organization already exists")
+)
+
+// List gives all Orgs.
+func List(ctx context.Context, db *sqlx.DB) ([]Org, error) {
+
+	orgs := []Org{}
+
+	const q = `SELECT * FROM organizations ORDER BY name`
+	if err := db.SelectContext(ctx, &orgs, q); err != nil {
+		return nil, errors.Wrap(err, "selecting organizations")
+	}
+	return orgs, nil
+}
+
+// Retrieve gives a single Org.
+func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Org, error) {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var o Org
+	const q = `SELECT * FROM organizations WHERE org_id = $1`
+	if err := db.GetContext(ctx, &o, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting organization %q", id)
+	}
+	return &o, nil
+}
+
+// Create adds an Org.
+func Create(ctx context.Context, db *sqlx.DB, no NewOrg, now time.Time) (*Org, error) {
+
+	o := Org{
+		ID:          uuid.New().String(),
+		Name:        no.Name,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO organizations (org_id, name, date_created, date_updated)
+		VALUES ($1, $2, $3, $4)`
+	if _, err := db.ExecContext(ctx, q, o.ID, o.Name, o.DateCreated, o.DateUpdated); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "organizations_name_key" {
+			return nil, ErrExists
+		}
+		return nil, errors.Wrap(err, "inserting organization")
+	}
+
+	return &o, nil
+}
@@ -0,0 +1,47 @@
+package org_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/tests"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOrg(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	saved, err := org.Create(ctx, db, org.NewOrg{Name: "Scouts"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+	if _, err := org.Create(ctx, db, org.NewOrg{Name: "Scouts"}, now); err != org.ErrExists {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+
+	fetched, err := org.Retrieve(ctx, db, saved.ID)
+	if err != nil {
+		t.Fatalf("retrieving organization: %s", err)
+	}
+	if diff := cmp.Diff(saved, fetched); diff != "" {
+		t.Fatalf("fetched organization did not match saved:\n%s", diff)
+	}
+	if _, err := org.Retrieve(ctx, db, "not-a-uuid"); err != org.ErrInvalidID {
+		t.Fatalf("expected ErrInvalidID, got %v", err)
+	}
+
+	orgs, err := org.List(ctx, db)
+	if err != nil {
+		t.Fatalf("listing organizations: %s", err)
+	}
+	if exp, got := 2, len(orgs); exp != got {
+		t.Fatalf("expected %d organizations including the default one, got %d", exp, got)
+	}
+}
@@ -0,0 +1,3 @@
+// Package payment implements all business logic regarding the payments made
+// for sales, and the balances that remain outstanding.
+package payment
@@ -0,0 +1,42 @@
+package payment
+
+import "time"
+
+// These are the expected values for Payment.Method.
+const (
+	MethodCash     = "cash"
+	MethodCard     = "card"
+	MethodTransfer = "transfer"
+	MethodOther    = "other"
+)
+
+// Payment is an amount received for a Sale with some method. Reference is
+// free text, like the receipt number of a card terminal.
+type Payment struct {
+	ID          string    `db:"payment_id"    json:"id"`
+	SaleID      string    `db:"sale_id"       json:"sale_id"`
+	Method      string    `db:"method"        json:"method"`
+	Amount      int       `db:"amount"        json:"amount"`
+	Reference   string    `db:"reference"     json:"reference"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+}
+
+// NewPayment is what we require from clients for recording a Payment.
+type NewPayment struct {
+	Method    string `json:"method"     validate:"required,oneof=cash card transfer other"`
+	Amount    int    `json:"amount"     validate:"gte=1"`
+	Reference string `json:"reference"`
+}
+
+// Balance tells how much of a Sale is paid. Due is what the buyer owes in
+// total, Received the sum of the Payments made so far, and Outstanding what
+// remains to be paid.
+type Balance struct {
+	SaleID      string    `db:"sale_id"       json:"sale_id"`
+	ProductID   string    `db:"product_id"    json:"product_id"`
+	CustomerID  *string   `db:"customer_id"   json:"customer_id"`
+	Due         int       `db:"due"           json:"due"`
+	Received    int       `db:"received"      json:"received"`
+	Outstanding int       `db:"outstanding"   json:"outstanding"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+}
@@ -0,0 +1,185 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrSaleNotFound  = errors.New("sale not found")
+	ErrInvalidID     = errors.New("provided id is not a valid UUID")
+	ErrInvalidAmount = errors.New("payment amount must be positive")
+	ErrOverpayment   = errors.New("payment exceeds the outstanding balance")
+)
+
+// balanceQuery selects the Balance of sales. What a buyer owes is the price
+// paid for the sale, plus the tax when it is charged on top of that price.
+const balanceQuery = `SELECT s.sale_id, s.product_id, s.customer_id, s.date_created,
+	s.paid + CASE WHEN s.tax_inclusive THEN 0 ELSE s.tax END AS due,
+	COALESCE(SUM(p.amount), 0) AS received,
+	s.paid + CASE WHEN s.tax_inclusive THEN 0 ELSE s.tax END - COALESCE(SUM(p.amount), 0) AS outstanding
+	FROM sales AS s
+	LEFT JOIN payments AS p ON p.sale_id = s.sale_id`
+
+// Add records a Payment for a Sale of the organization of the user. It fails
+// if the Sale would be paid more than what is due.
+func Add(ctx context.Context, db *sqlx.DB, claims auth.Claims, saleID string, np NewPayment, now time.Time) (*Payment, error) {
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	p, err := AddTx(ctx, tx, claims, saleID, np, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing payment")
+	}
+
+	return p, nil
+}
+
+// AddTx is Add within a transaction, for payments made along with other
+// changes. The Sale stays locked until the transaction ends, so payments made
+// for it at the same time cannot pay more than what is due together. A cash
+// payment locks the open cash drawer as well, which cannot close without it.
+func AddTx(ctx context.Context, tx *sqlx.Tx, claims auth.Claims, saleID string, np NewPayment, now time.Time) (*Payment, error) {
+
+	if np.Amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	const ql = `SELECT org_id FROM sales
+		WHERE sale_id = $1 AND ($2::uuid IS NULL OR org_id = $2)
+		FOR UPDATE`
+	var orgID string
+	if err := tx.GetContext(ctx, &orgID, ql, saleID, claims.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSaleNotFound
+		}
+		return nil, errors.Wrap(err, "locking sale")
+	}
+
+	// Cash goes into the open drawer, which cannot close until the payment is
+	// recorded and so counts it as expected.
+	if np.Method == MethodCash {
+		const qd = `SELECT session_id FROM drawer_sessions
+			WHERE org_id = $1 AND closed_at IS NULL
+			FOR SHARE`
+		if _, err := tx.ExecContext(ctx, qd, orgID); err != nil {
+			return nil, errors.Wrap(err, "locking drawer session")
+		}
+	}
+
+	b, err := retrieveBalance(ctx, tx, claims, saleID)
+	if err != nil {
+		return nil, err
+	}
+	if np.Amount > b.Outstanding {
+		return nil, ErrOverpayment
+	}
+
+	p := Payment{
+		ID:          uuid.New().String(),
+		SaleID:      b.SaleID,
+		Method:      np.Method,
+		Amount:      np.Amount,
+		Reference:   np.Reference,
+		DateCreated: now.UTC(),
+	}
+
+	const q = `INSERT INTO payments
+		(payment_id, sale_id, method, amount, reference, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, q,
+		p.ID, p.SaleID, p.Method,
+		p.Amount, p.Reference, p.DateCreated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting payment")
+	}
+
+	return &p, nil
+}
+
+// List gives all Payments made for a Sale of the organization of the user, in
+// the order they were made.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims, saleID string) ([]Payment, error) {
+
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	payments := []Payment{}
+
+	const q = `SELECT p.* FROM payments AS p
+		JOIN sales AS s ON s.sale_id = p.sale_id
+		WHERE p.sale_id = $1 AND ($2::uuid IS NULL OR s.org_id = $2)
+		ORDER BY p.date_created`
+	if err := db.SelectContext(ctx, &payments, q, saleID, claims.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting payments")
+	}
+
+	return payments, nil
+}
+
+// RetrieveBalance tells how much of a Sale is paid. Sales of other
+// organizations than the one of the user are not found.
+func RetrieveBalance(ctx context.Context, db *sqlx.DB, claims auth.Claims, saleID string) (*Balance, error) {
+
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	return retrieveBalance(ctx, db, claims, saleID)
+}
+
+// retrieveBalance tells how much of a Sale is paid, within a transaction or
+// not.
+func retrieveBalance(ctx context.Context, q sqlx.QueryerContext, claims auth.Claims, saleID string) (*Balance, error) {
+
+	var b Balance
+	const qb = balanceQuery + `
+		WHERE s.sale_id = $1 AND ($2::uuid IS NULL OR s.org_id = $2)
+		GROUP BY s.sale_id`
+	if err := sqlx.GetContext(ctx, q, &b, qb, saleID, claims.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSaleNotFound
+		}
+		return nil, errors.Wrap(err, "selecting sale balance")
+	}
+
+	return &b, nil
+}
+
+// Unpaid gives the Balances of all Sales of the organization of the user that
+// are not fully paid yet, the oldest first.
+func Unpaid(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]Balance, error) {
+
+	balances := []Balance{}
+
+	const q = balanceQuery + `
+		WHERE ($1::uuid IS NULL OR s.org_id = $1)
+		GROUP BY s.sale_id
+		HAVING s.paid + CASE WHEN s.tax_inclusive THEN 0 ELSE s.tax END - COALESCE(SUM(p.amount), 0) > 0
+		ORDER BY s.date_created`
+	if err := db.SelectContext(ctx, &balances, q, claims.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting unpaid balances")
+	}
+
+	return balances, nil
+}
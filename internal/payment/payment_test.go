@@ -0,0 +1,159 @@
+package payment_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/payment"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/product"
+	"github.com/devisions/garagesale/internal/tests"
+)
+
+func TestPayments(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	claims := auth.NewClaims(
+		"718ffbea-f4a1-4667-8ae3-b349da52675e", // A random UUID.
+		[]string{auth.RoleAdmin, auth.RoleUser},
+		now, time.Hour,
+	)
+	claims.OrgID = org.Default
+
+	sofa, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Sofa", Cost: 100, Quantity: 1}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+
+	// A sale recorded without payments is fully paid in cash.
+	if _, err := product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1, Paid: 10}, sofa.ID, now); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	// A deposit now, the rest on pick-up.
+	ns := product.NewSale{
+		Quantity: 1,
+		Paid:     90,
+		Payments: []payment.NewPayment{{Method: payment.MethodCash, Amount: 30}},
+	}
+	s, err := product.AddSale(ctx, db, claims, ns, sofa.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	b, err := payment.RetrieveBalance(ctx, db, claims, s.ID)
+	if err != nil {
+		t.Fatalf("retrieving balance: %s", err)
+	}
+	if exp, got := 60, b.Outstanding; exp != got {
+		t.Fatalf("expected outstanding balance %v, got %v", exp, got)
+	}
+
+	unpaid, err := payment.Unpaid(ctx, db, claims)
+	if err != nil {
+		t.Fatalf("listing unpaid balances: %s", err)
+	}
+	if exp, got := 1, len(unpaid); exp != got {
+		t.Fatalf("expected %v unpaid sales, got %v", exp, got)
+	}
+	if exp, got := s.ID, unpaid[0].SaleID; exp != got {
+		t.Fatalf("expected unpaid sale %v, got %v", exp, got)
+	}
+
+	if _, err := payment.Add(ctx, db, claims, s.ID, payment.NewPayment{Method: payment.MethodCard, Amount: 61}, now); err != payment.ErrOverpayment {
+		t.Fatalf("expected ErrOverpayment, got %v", err)
+	}
+	if _, err := payment.Add(ctx, db, claims, s.ID, payment.NewPayment{Method: payment.MethodCard, Amount: 60, Reference: "TX-1"}, now); err != nil {
+		t.Fatalf("adding payment: %s", err)
+	}
+
+	payments, err := payment.List(ctx, db, claims, s.ID)
+	if err != nil {
+		t.Fatalf("listing payments: %s", err)
+	}
+	if exp, got := 2, len(payments); exp != got {
+		t.Fatalf("expected %v payments, got %v", exp, got)
+	}
+
+	unpaid, err = payment.Unpaid(ctx, db, claims)
+	if err != nil {
+		t.Fatalf("listing unpaid balances: %s", err)
+	}
+	if exp, got := 0, len(unpaid); exp != got {
+		t.Fatalf("expected %v unpaid sales, got %v", exp, got)
+	}
+}
+
+func TestPaymentTenancy(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	other, err := org.Create(ctx, db, org.NewOrg{Name: "Other Gophers"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	owner := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+	owner.OrgID = org.Default
+	stranger := auth.NewClaims("2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37", []string{auth.RoleAdmin}, now, time.Hour)
+	stranger.OrgID = other.ID
+	stranger.Permissions = []string{auth.PermPaymentCreate, auth.PermReportRead}
+	super := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleSuperAdmin}, now, time.Hour)
+	super.OrgID = other.ID
+	super.Permissions = []string{auth.PermOrgManage}
+
+	sofa, err := product.Create(ctx, db, owner, product.NewProduct{Name: "Sofa", Cost: 100, Quantity: 1}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+	ns := product.NewSale{
+		Quantity: 1,
+		Paid:     90,
+		Payments: []payment.NewPayment{{Method: payment.MethodCash, Amount: 30}},
+	}
+	s, err := product.AddSale(ctx, db, owner, ns, sofa.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	if _, err := payment.RetrieveBalance(ctx, db, stranger, s.ID); err != payment.ErrSaleNotFound {
+		t.Fatalf("expected ErrSaleNotFound for the balance of another organization's sale, got %v", err)
+	}
+	payments, err := payment.List(ctx, db, stranger, s.ID)
+	if err != nil {
+		t.Fatalf("listing payments: %s", err)
+	}
+	if len(payments) != 0 {
+		t.Fatalf("expected no payments of another organization, got %d", len(payments))
+	}
+	unpaid, err := payment.Unpaid(ctx, db, stranger)
+	if err != nil {
+		t.Fatalf("listing unpaid balances: %s", err)
+	}
+	if len(unpaid) != 0 {
+		t.Fatalf("expected no unpaid sales of another organization, got %d", len(unpaid))
+	}
+	if _, err := payment.Add(ctx, db, stranger, s.ID, payment.NewPayment{Method: payment.MethodCard, Amount: 10}, now); err != payment.ErrSaleNotFound {
+		t.Fatalf("expected ErrSaleNotFound paying another organization's sale, got %v", err)
+	}
+
+	// A super admin reaches every organization.
+	b, err := payment.RetrieveBalance(ctx, db, super, s.ID)
+	if err != nil {
+		t.Fatalf("super admin retrieving balance: %s", err)
+	}
+	if exp, got := 60, b.Outstanding; exp != got {
+		t.Fatalf("expected outstanding balance %v, got %v", exp, got)
+	}
+}
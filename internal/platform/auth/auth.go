@@ -1,8 +1,9 @@
 package auth
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"fmt"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
@@ -17,14 +18,14 @@ import (
 //
 // * Key-id-to-public-key resolution is usually accomplished via a public JWKS
 // endpoint. See https://auth0.com/docs/jwks for more details.
-type KeyLookupFunc func(kid string) (*rsa.PublicKey, error)
+type KeyLookupFunc func(kid string) (crypto.PublicKey, error)
 
 // NewSimpleKeyLookupFunc is a simple implementation of KeyFunc that only ever
 // supports one key. This is easy for development but in production should be
 // replaced with a caching layer that calls a JWKS endpoint.
-func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLookupFunc {
+func NewSimpleKeyLookupFunc(activeKID string, publicKey crypto.PublicKey) KeyLookupFunc {
 
-	f := func(kid string) (*rsa.PublicKey, error) {
+	f := func(kid string) (crypto.PublicKey, error) {
 		if activeKID != kid {
 			return nil, fmt.Errorf("unrecognized key id %q", kid)
 		}
@@ -37,8 +38,7 @@ func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLooku
 // Authenticator is used to authenticate clients. It can generate a token for a
 // set of user claims and recreate the claims by parsing the token.
 type Authenticator struct {
-	privateKey       *rsa.PrivateKey
-	activeKID        string
+	keys             *KeyStore
 	algorithm        string
 	pubKeyLookupFunc KeyLookupFunc
 	parser           *jwt.Parser
@@ -49,7 +49,8 @@ type Authenticator struct {
 // - The public key func is nil.
 // - The key ID is blank.
 // - The specified algorithm is unsupported.
-func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
+// - The private key cannot sign with the algorithm.
+func NewAuthenticator(privateKey crypto.Signer, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
 
 	if privateKey == nil {
 		return nil, errors.New("private key cannot be nil")
@@ -63,6 +64,9 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 	if publicKeyLookupFunc == nil {
 		return nil, errors.New("public key function cannot be nil")
 	}
+	if err := checkAlgorithm(privateKey.Public(), algorithm); err != nil {
+		return nil, err
+	}
 
 	// Create the token parser to use. The algorithm used to sign the JWT must be
 	// validated to avoid a critical vulnerability:
@@ -71,9 +75,13 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 		ValidMethods: []string{algorithm},
 	}
 
+	keys, err := NewKeyStore(algorithm, SigningKey{ID: activeKID, PrivateKey: privateKey})
+	if err != nil {
+		return nil, err
+	}
+
 	a := Authenticator{
-		privateKey:       privateKey,
-		activeKID:        activeKID,
+		keys:             keys,
 		algorithm:        algorithm,
 		pubKeyLookupFunc: publicKeyLookupFunc,
 		parser:           &parser,
@@ -82,15 +90,39 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 	return &a, nil
 }
 
+// NewKeyStoreAuthenticator creates an *Authenticator signing tokens with the
+// current key of the KeyStore and verifying them with any of its published
+// keys, using the algorithm of the KeyStore.
+func NewKeyStoreAuthenticator(keys *KeyStore) (*Authenticator, error) {
+
+	if keys == nil {
+		return nil, errors.New("key store cannot be nil")
+	}
+
+	a := Authenticator{
+		keys:             keys,
+		algorithm:        keys.algorithm,
+		pubKeyLookupFunc: keys.LookupFunc(),
+		parser:           &jwt.Parser{ValidMethods: []string{keys.algorithm}},
+	}
+
+	return &a, nil
+}
+
 // GenerateToken generates a signed JWT token string representing the user Claims.
 func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 
+	key, err := a.keys.Current(time.Now())
+	if err != nil {
+		return "", err
+	}
+
 	method := jwt.GetSigningMethod(a.algorithm)
 
 	tkn := jwt.NewWithClaims(method, claims)
-	tkn.Header["kid"] = a.activeKID
+	tkn.Header["kid"] = key.ID
 
-	str, err := tkn.SignedString(a.privateKey)
+	str, err := tkn.SignedString(key.PrivateKey)
 	if err != nil {
 		return "", errors.Wrap(err, "signing token")
 	}
@@ -98,6 +130,23 @@ func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 	return str, nil
 }
 
+// JWKS gives the keys tokens of the Authenticator are verified with, for
+// other services to verify them without sharing our private key. Keys about
+// to sign are published ahead.
+func (a *Authenticator) JWKS() (JWKS, error) {
+
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range a.keys.Published(time.Now()) {
+		jwk, err := NewJWK(k.ID, a.algorithm, k.PrivateKey.Public())
+		if err != nil {
+			return JWKS{}, errors.Wrapf(err, "key %q", k.ID)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
 // ParseClaims recreates the Claims that were used to generate a token. It
 // verifies that the token was signed using our key.
 func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
@@ -0,0 +1,57 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+)
+
+func TestAuthenticator(t *testing.T) {
+
+	tests := []struct {
+		keyType   string
+		algorithm string
+		valid     bool
+	}{
+		{auth.KeyTypeRSA, "RS256", true},
+		{auth.KeyTypeP256, "ES256", true},
+		{auth.KeyTypeP384, "ES384", true},
+		{auth.KeyTypeEd25519, "EdDSA", true},
+		{auth.KeyTypeRSA, "ES256", false},
+		{auth.KeyTypeP256, "ES384", false},
+		{auth.KeyTypeP384, "EdDSA", false},
+		{auth.KeyTypeEd25519, "RS256", false},
+		{auth.KeyTypeRSA, "HS256", false},
+	}
+	for _, tt := range tests {
+		key, err := auth.GenerateKey(tt.keyType)
+		if err != nil {
+			t.Fatalf("%s: generating key: %s", tt.keyType, err)
+		}
+
+		a, err := auth.NewAuthenticator(key, "1", tt.algorithm, auth.NewSimpleKeyLookupFunc("1", key.Public()))
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("%s with %s: expected the key type and algorithm to be refused together", tt.keyType, tt.algorithm)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s with %s: creating authenticator: %s", tt.keyType, tt.algorithm, err)
+		}
+
+		claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, time.Now(), time.Hour)
+		tkn, err := a.GenerateToken(claims)
+		if err != nil {
+			t.Fatalf("%s with %s: generating token: %s", tt.keyType, tt.algorithm, err)
+		}
+		got, err := a.ParseClaims(tkn)
+		if err != nil {
+			t.Fatalf("%s with %s: parsing token: %s", tt.keyType, tt.algorithm, err)
+		}
+		if got.Subject != claims.Subject {
+			t.Fatalf("%s with %s: expected subject %q, got %q", tt.keyType, tt.algorithm, claims.Subject, got.Subject)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// minRefresh is how long a remote key set is trusted to be complete after
+// fetching it. Tokens with an unknown key id do not trigger fetching it again
+// before, so they cannot be used to flood the publisher.
+const minRefresh = 10 * time.Second
+
+// These bound how long the keys of a remote key set are used before fetching
+// them again, as told by the max age of the response, which defaults to
+// defaultMaxAge.
+const (
+	minMaxAge     = time.Second
+	defaultMaxAge = 5 * time.Minute
+	maxMaxAge     = 24 * time.Hour
+)
+
+// JWK is a JSON Web Key (RFC 7517). RSA, ECDSA (EC) and Ed25519 (OKP, RFC
+// 8037) public keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, the document publishing the keys tokens are
+// verified with.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK gives the JWK of a public key used to verify the signatures of the
+// algorithm.
+func NewJWK(kid, algorithm string, key crypto.PublicKey) (JWK, error) {
+
+	k := JWK{Kid: kid, Use: "sig", Alg: algorithm}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		k.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		k.Kty = "EC"
+		k.Crv = key.Curve.Params().Name
+		k.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		k.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+
+	case ed25519.PublicKey:
+		k.Kty = "OKP"
+		k.Crv = "Ed25519"
+		k.X = base64.RawURLEncoding.EncodeToString(key)
+
+	default:
+		return JWK{}, errors.Errorf("unsupported public key %T", key)
+	}
+
+	return k, nil
+}
+
+// curves are the elliptic curves of EC keys, by name.
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// PublicKey gives the public key of the JWK.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding exponent")
+		}
+		exp := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid key")
+		}
+
+		key := rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exp.Int64()),
+		}
+		return &key, nil
+
+	case "EC":
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, errors.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding x")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding y")
+		}
+
+		key := ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid key")
+		}
+		return &key, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding x")
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, errors.Errorf("unsupported key type %q", k.Kty)
+}
+
+// KeySet holds the keys published as a JWKS at some URL, fetched again when
+// a token refers to one it does not know, as publishers rotate them, or once
+// they are older than the max age the publisher gave.
+type KeySet struct {
+	client *http.Client
+	uri    string
+
+	// fetching is held while fetching the keys, so lookups needing them wait
+	// for a single fetch while others go on with the keys already known.
+	fetching sync.Mutex
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	expires   time.Time
+	attempted time.Time
+}
+
+// NewKeySet creates a KeySet for the keys published at uri. They are fetched
+// when first needed.
+func NewKeySet(client *http.Client, uri string) *KeySet {
+	return &KeySet{client: client, uri: uri}
+}
+
+// Lookup gives the key with the kid, fetching the keys again if it is
+// unknown or they expired.
+func (ks *KeySet) Lookup(ctx context.Context, kid string) (crypto.PublicKey, error) {
+
+	if key, ok := ks.cached(kid, time.Now()); ok {
+		return key, nil
+	}
+
+	ks.fetching.Lock()
+	defer ks.fetching.Unlock()
+
+	// The keys may have been fetched while waiting.
+	now := time.Now()
+	if key, ok := ks.cached(kid, now); ok {
+		return key, nil
+	}
+	if !ks.mayFetch(now) {
+		return nil, fmt.Errorf("unrecognized key id %q", kid)
+	}
+
+	set, maxAge, err := ks.fetch(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching key set")
+	}
+
+	// Keys of other types or uses are skipped rather than refused, as a
+	// publisher may add ones we do not need.
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	ks.mu.Lock()
+	ks.keys, ks.expires = keys, now.Add(maxAge)
+	ks.mu.Unlock()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized key id %q", kid)
+	}
+	return key, nil
+}
+
+// cached gives the key with the kid if it is known and did not expire at the
+// time.
+func (ks *KeySet) cached(kid string, now time.Time) (crypto.PublicKey, bool) {
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[kid]
+	if !ok || !now.Before(ks.expires) {
+		return nil, false
+	}
+	return key, true
+}
+
+// mayFetch tells whether the keys may be fetched at the time, recording the
+// attempt if so. Keys are fetched again right away once expired, and else not
+// more often than every minRefresh, failed attempts included.
+func (ks *KeySet) mayFetch(now time.Time) bool {
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	expired := !now.Before(ks.expires) && ks.attempted.Before(ks.expires)
+	if !expired && now.Sub(ks.attempted) < minRefresh {
+		return false
+	}
+	ks.attempted = now
+	return true
+}
+
+// fetch gets the JWKS, along with how long it may be used as told by the
+// Cache-Control header of the response.
+func (ks *KeySet) fetch(ctx context.Context) (JWKS, time.Duration, error) {
+
+	var set JWKS
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.uri, nil)
+	if err != nil {
+		return set, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return set, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return set, 0, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, ks.uri)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return set, 0, err
+	}
+
+	return set, maxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+// maxAge gives how long a response may be used according to the max-age
+// directive of its Cache-Control header, within minMaxAge and maxMaxAge.
+func maxAge(cacheControl string) time.Duration {
+
+	age := defaultMaxAge
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(directive, "max-age="), `"`))
+		if err != nil || secs < 0 {
+			continue
+		}
+		age = time.Duration(secs) * time.Second
+		break
+	}
+
+	switch {
+	case age < minMaxAge:
+		return minMaxAge
+	case age > maxMaxAge:
+		return maxMaxAge
+	}
+	return age
+}
+
+// NewRemoteKeyLookupFunc is an implementation of KeyLookupFunc for services
+// verifying the tokens of another one, which publishes its keys as a JWKS at
+// uri. The keys are cached and fetched again when a token refers to an
+// unknown one or once they are older than the max age of the response.
+// Lookups are not bound to a request, so the client should have a timeout.
+func NewRemoteKeyLookupFunc(client *http.Client, uri string) KeyLookupFunc {
+
+	ks := NewKeySet(client, uri)
+
+	f := func(kid string) (crypto.PublicKey, error) {
+		return ks.Lookup(context.Background(), kid)
+	}
+
+	return f
+}
@@ -0,0 +1,153 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+)
+
+func TestRemoteKeyLookup(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		fetches int
+		kid     = "1"
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		jwk, err := auth.NewJWK(kid, "RS256", &key.PublicKey)
+		if err != nil {
+			t.Errorf("creating jwk: %s", err)
+		}
+		json.NewEncoder(w).Encode(auth.JWKS{Keys: []auth.JWK{jwk}})
+	}))
+	defer srv.Close()
+
+	lookup := auth.NewRemoteKeyLookupFunc(srv.Client(), srv.URL)
+
+	for i := 0; i < 2; i++ {
+		got, err := lookup("1")
+		if err != nil {
+			t.Fatalf("looking up key: %s", err)
+		}
+		if !key.PublicKey.Equal(got) {
+			t.Fatal("expected the published key")
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("expected the keys to be fetched once, got %d", fetches)
+	}
+
+	// Unknown key ids fetch the keys again, but not right after fetching them.
+	mu.Lock()
+	kid = "2"
+	mu.Unlock()
+	if _, err := lookup("2"); err == nil {
+		t.Fatal("expected an unknown key id right after fetching to be refused")
+	}
+	if fetches != 1 {
+		t.Fatalf("expected the keys not to be fetched again yet, got %d fetches", fetches)
+	}
+}
+
+func TestRemoteKeyExpiry(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		jwk, err := auth.NewJWK("1", "RS256", &key.PublicKey)
+		if err != nil {
+			t.Errorf("creating jwk: %s", err)
+		}
+		w.Header().Set("Cache-Control", "public, max-age=1")
+		json.NewEncoder(w).Encode(auth.JWKS{Keys: []auth.JWK{jwk}})
+	}))
+	defer srv.Close()
+
+	lookup := auth.NewRemoteKeyLookupFunc(srv.Client(), srv.URL)
+
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches
+	}
+
+	// Keys are used for as long as the publisher tells, then fetched again.
+	for i := 0; i < 2; i++ {
+		if _, err := lookup("1"); err != nil {
+			t.Fatalf("looking up key: %s", err)
+		}
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("expected the keys to be fetched once, got %d", n)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := lookup("1"); err != nil {
+		t.Fatalf("looking up key: %s", err)
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("expected the expired keys to be fetched again, got %d fetches", n)
+	}
+}
+
+func TestJWK(t *testing.T) {
+
+	tests := []struct {
+		keyType   string
+		algorithm string
+	}{
+		{auth.KeyTypeRSA, "RS256"},
+		{auth.KeyTypeP256, "ES256"},
+		{auth.KeyTypeP384, "ES384"},
+		{auth.KeyTypeEd25519, "EdDSA"},
+	}
+	for _, tt := range tests {
+		key, err := auth.GenerateKey(tt.keyType)
+		if err != nil {
+			t.Fatalf("%s: generating key: %s", tt.keyType, err)
+		}
+
+		k, err := auth.NewJWK("1", tt.algorithm, key.Public())
+		if err != nil {
+			t.Fatalf("%s: creating jwk: %s", tt.keyType, err)
+		}
+		got, err := k.PublicKey()
+		if err != nil {
+			t.Fatalf("%s: parsing jwk: %s", tt.keyType, err)
+		}
+		if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(got) {
+			t.Fatalf("%s: expected the key to survive a round trip", tt.keyType)
+		}
+
+		k.X, k.E = "", ""
+		if _, err := k.PublicKey(); err == nil {
+			t.Fatalf("%s: expected an incomplete key to be refused", tt.keyType)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// These are the types of keys tokens can be signed with.
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeP256    = "p256"
+	KeyTypeP384    = "p384"
+	KeyTypeEd25519 = "ed25519"
+)
+
+// GenerateKey generates a private key of the type: 2048 bits for RSA, which
+// signs RS256 tokens, the P-256 or P-384 curve for ES256 or ES384 ones, and
+// Ed25519 for EdDSA ones, which are the smallest.
+func GenerateKey(keyType string) (crypto.Signer, error) {
+
+	switch keyType {
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	return nil, errors.Errorf("unknown key type %q", keyType)
+}
+
+// ParsePrivateKey parses a PEM encoded private key: PKCS #1 for RSA, SEC 1
+// for ECDSA or PKCS #8 for any type.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	return parseBlock(block)
+}
+
+// parseBlock parses the private key of a PEM block.
+func parseBlock(block *pem.Block) (crypto.Signer, error) {
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing private key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key %T", key)
+	}
+
+	return signer, nil
+}
+
+// EncodePrivateKey encodes a private key as PEM, in PKCS #1 for RSA keys as
+// they always were, and PKCS #8 otherwise.
+func EncodePrivateKey(key crypto.Signer) ([]byte, error) {
+
+	block, err := pemBlock(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// pemBlock gives the PEM block of a private key.
+func pemBlock(key crypto.Signer) (*pem.Block, error) {
+
+	if key, ok := key.(*rsa.PrivateKey); ok {
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding private key")
+	}
+
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
+
+// checkAlgorithm makes sure tokens of the algorithm can be verified with the
+// public key, so a key of one type never verifies tokens meant for another.
+func checkAlgorithm(key crypto.PublicKey, algorithm string) error {
+
+	var ok bool
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		_, ok = key.(*rsa.PublicKey)
+	case algorithm == "ES256":
+		ok = hasCurve(key, elliptic.P256())
+	case algorithm == "ES384":
+		ok = hasCurve(key, elliptic.P384())
+	case algorithm == "ES512":
+		ok = hasCurve(key, elliptic.P521())
+	case algorithm == SigningMethodEdDSA.Alg():
+		_, ok = key.(ed25519.PublicKey)
+	default:
+		return errors.Errorf("unsupported algorithm %v", algorithm)
+	}
+
+	if !ok {
+		return errors.Errorf("%T cannot be used with algorithm %v", key, algorithm)
+	}
+	return nil
+}
+
+// hasCurve tells whether the key is an ECDSA key on the curve.
+func hasCurve(key crypto.PublicKey, curve elliptic.Curve) bool {
+	k, ok := key.(*ecdsa.PublicKey)
+	return ok && k.Curve == curve
+}
@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"crypto"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// These are the PEM headers holding the period a key is used in.
+const (
+	headerNotBefore   = "Not-Before"
+	headerRetireAfter = "Retire-After"
+)
+
+// SigningKey is a private key tokens are signed with, identified by its kid.
+// It signs from NotBefore, but is published before so tokens it signs are
+// known when they show up, and verifies tokens until RetireAfter. Zero times
+// leave the period open.
+type SigningKey struct {
+	ID          string
+	PrivateKey  crypto.Signer
+	NotBefore   time.Time
+	RetireAfter time.Time
+}
+
+// signs tells whether the key may sign tokens at the time.
+func (k SigningKey) signs(now time.Time) bool {
+	return !now.Before(k.NotBefore) && k.verifies(now)
+}
+
+// verifies tells whether tokens signed with the key are still valid at the
+// time.
+func (k SigningKey) verifies(now time.Time) bool {
+	return k.RetireAfter.IsZero() || now.Before(k.RetireAfter)
+}
+
+// KeyStore holds the keys of an Authenticator, which signs with the newest
+// of them that is active and verifies with any that is not retired. Keys can
+// be rotated without logging everyone out: the new key is published ahead of
+// signing with it and the old one keeps verifying the tokens it signed until
+// they expire. All its keys have to fit the algorithm tokens are signed with.
+type KeyStore struct {
+	dir       string
+	algorithm string
+
+	mu   sync.RWMutex
+	keys []SigningKey
+}
+
+// NewKeyStore creates a KeyStore holding the specified keys, signing tokens
+// with the algorithm. It will error if the algorithm is unsupported or if a
+// key cannot be used with it.
+func NewKeyStore(algorithm string, keys ...SigningKey) (*KeyStore, error) {
+
+	if jwt.GetSigningMethod(algorithm) == nil {
+		return nil, errors.Errorf("unknown algorithm %v", algorithm)
+	}
+
+	keys = append([]SigningKey(nil), keys...)
+	if err := checkKeys(keys, algorithm, time.Now()); err != nil {
+		return nil, err
+	}
+	sortKeys(keys)
+
+	return &KeyStore{algorithm: algorithm, keys: keys}, nil
+}
+
+// OpenKeyStore creates a KeyStore holding the keys of a directory, as
+// written by WriteKey, signing tokens with the algorithm.
+func OpenKeyStore(dir, algorithm string) (*KeyStore, error) {
+
+	if jwt.GetSigningMethod(algorithm) == nil {
+		return nil, errors.Errorf("unknown algorithm %v", algorithm)
+	}
+
+	ks := KeyStore{dir: dir, algorithm: algorithm}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &ks, nil
+}
+
+// Reload reads the keys of the directory of the KeyStore again, to pick up
+// rotated keys. The keys are kept as they were if the directory cannot be
+// read, has a key not fitting the algorithm or has no key to sign with.
+func (ks *KeyStore) Reload() error {
+
+	if ks.dir == "" {
+		return nil
+	}
+
+	keys, err := ReadKeys(ks.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := checkKeys(keys, ks.algorithm, now); err != nil {
+		return err
+	}
+
+	active := false
+	for _, k := range keys {
+		active = active || k.signs(now)
+	}
+	if !active {
+		return errors.Errorf("no active key in %s", ks.dir)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keys = keys
+	return nil
+}
+
+// Current gives the key to sign tokens with at the time: the active one that
+// became active last.
+func (ks *KeyStore) Current(now time.Time) (SigningKey, error) {
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if ks.keys[i].signs(now) {
+			return ks.keys[i], nil
+		}
+	}
+
+	return SigningKey{}, errors.New("no active signing key")
+}
+
+// Published gives the keys tokens are verified with at the time, including
+// the ones that do not sign yet.
+func (ks *KeyStore) Published(now time.Time) []SigningKey {
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var keys []SigningKey
+	for _, k := range ks.keys {
+		if k.verifies(now) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// LookupFunc gives a KeyLookupFunc finding the published keys of the
+// KeyStore.
+func (ks *KeyStore) LookupFunc() KeyLookupFunc {
+
+	f := func(kid string) (crypto.PublicKey, error) {
+		for _, k := range ks.Published(time.Now()) {
+			if k.ID == kid {
+				return k.PrivateKey.Public(), nil
+			}
+		}
+		return nil, fmt.Errorf("unrecognized key id %q", kid)
+	}
+
+	return f
+}
+
+// ReadKeys reads the keys of a directory. Every key is a PEM file named after
+// its kid, with the period it is used in as headers.
+func ReadKeys(dir string) ([]SigningKey, error) {
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing keys")
+	}
+
+	var keys []SigningKey
+	for _, file := range files {
+		k, err := readKey(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading key %s", file)
+		}
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+
+	return keys, nil
+}
+
+// readKey reads the key of a file.
+func readKey(file string) (SigningKey, error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM data")
+	}
+
+	k := SigningKey{ID: strings.TrimSuffix(filepath.Base(file), ".pem")}
+
+	if k.PrivateKey, err = parseBlock(block); err != nil {
+		return SigningKey{}, err
+	}
+
+	if v, ok := block.Headers[headerNotBefore]; ok {
+		if k.NotBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return SigningKey{}, errors.Wrap(err, "parsing not before")
+		}
+	}
+	if v, ok := block.Headers[headerRetireAfter]; ok {
+		if k.RetireAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return SigningKey{}, errors.Wrap(err, "parsing retire after")
+		}
+	}
+
+	return k, nil
+}
+
+// WriteKey writes a key to a directory, replacing the one with the same kid
+// if any. The file is replaced at once so a KeyStore reloading at the same
+// time does not read half of it.
+func WriteKey(dir string, k SigningKey) error {
+
+	if k.ID == "" || strings.ContainsAny(k.ID, `/\`) || strings.HasPrefix(k.ID, ".") {
+		return errors.Errorf("invalid key id %q", k.ID)
+	}
+
+	block, err := pemBlock(k.PrivateKey)
+	if err != nil {
+		return err
+	}
+	block.Headers = map[string]string{}
+	if !k.NotBefore.IsZero() {
+		block.Headers[headerNotBefore] = k.NotBefore.UTC().Format(time.RFC3339)
+	}
+	if !k.RetireAfter.IsZero() {
+		block.Headers[headerRetireAfter] = k.RetireAfter.UTC().Format(time.RFC3339)
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".key-")
+	if err != nil {
+		return errors.Wrap(err, "creating key file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := pem.Encode(tmp, block); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "encoding key")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "closing key file")
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, k.ID+".pem")); err != nil {
+		return errors.Wrap(err, "replacing key file")
+	}
+
+	return nil
+}
+
+// RotateKeys adds a key to a directory, signing once published for the
+// publish duration, and retires the keys signing until then once the tokens
+// they signed expired, which takes the retire duration. It fails if the key
+// does not fit the algorithm tokens are signed with, or if a key already
+// waits to sign after the new one would.
+func RotateKeys(dir, algorithm string, k SigningKey, now time.Time, publish, retire time.Duration) (SigningKey, error) {
+
+	if err := checkAlgorithm(k.PrivateKey.Public(), algorithm); err != nil {
+		return SigningKey{}, errors.Wrapf(err, "key %q", k.ID)
+	}
+
+	keys, err := ReadKeys(dir)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	k.NotBefore = now.Add(publish).Truncate(time.Second)
+	k.RetireAfter = time.Time{}
+	retireAt := k.NotBefore.Add(retire)
+
+	for _, old := range keys {
+		if old.ID == k.ID {
+			return SigningKey{}, errors.Errorf("key id %q already used", k.ID)
+		}
+
+		// The newest key signs: one coming after the new key would replace it.
+		if old.NotBefore.After(k.NotBefore) && old.verifies(k.NotBefore) {
+			return SigningKey{}, errors.Errorf("key %q signs from %s, later than a new key", old.ID, old.NotBefore.Format(time.RFC3339))
+		}
+	}
+
+	if err := WriteKey(dir, k); err != nil {
+		return SigningKey{}, err
+	}
+
+	for _, old := range keys {
+		if !old.verifies(retireAt) {
+			continue
+		}
+		old.RetireAfter = retireAt
+		if err := WriteKey(dir, old); err != nil {
+			return SigningKey{}, errors.Wrapf(err, "retiring key %q", old.ID)
+		}
+	}
+
+	return k, nil
+}
+
+// checkKeys makes sure the keys that are not retired at the time can be
+// used with the algorithm.
+func checkKeys(keys []SigningKey, algorithm string, now time.Time) error {
+
+	for _, k := range keys {
+		if !k.verifies(now) {
+			continue
+		}
+		if err := checkAlgorithm(k.PrivateKey.Public(), algorithm); err != nil {
+			return errors.Wrapf(err, "key %q", k.ID)
+		}
+	}
+
+	return nil
+}
+
+// sortKeys sorts keys by the time they become active.
+func sortKeys(keys []SigningKey) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].NotBefore.Before(keys[j].NotBefore)
+	})
+}
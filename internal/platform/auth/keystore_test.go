@@ -0,0 +1,128 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestKeyStore(t *testing.T) {
+
+	dir := t.TempDir()
+	now := time.Now()
+
+	newKey := func(id string) auth.SigningKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("generating key: %s", err)
+		}
+		return auth.SigningKey{ID: id, PrivateKey: key}
+	}
+
+	// kid gives the key id a token was signed with.
+	kid := func(tkn string) string {
+		parsed, _, err := new(jwt.Parser).ParseUnverified(tkn, &auth.Claims{})
+		if err != nil {
+			t.Fatalf("parsing token: %s", err)
+		}
+		id, _ := parsed.Header["kid"].(string)
+		return id
+	}
+
+	if err := auth.WriteKey(dir, newKey("a")); err != nil {
+		t.Fatalf("writing key: %s", err)
+	}
+	keys, err := auth.OpenKeyStore(dir, "RS256")
+	if err != nil {
+		t.Fatalf("opening key store: %s", err)
+	}
+	a, err := auth.NewKeyStoreAuthenticator(keys)
+	if err != nil {
+		t.Fatalf("creating authenticator: %s", err)
+	}
+
+	claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, now, time.Hour)
+	old, err := a.GenerateToken(claims)
+	if err != nil {
+		t.Fatalf("generating token: %s", err)
+	}
+
+	// A key to sign later is published, but does not sign yet.
+	if _, err := auth.RotateKeys(dir, "RS256", newKey("b"), now, time.Hour, time.Hour); err != nil {
+		t.Fatalf("rotating keys: %s", err)
+	}
+	if err := keys.Reload(); err != nil {
+		t.Fatalf("reloading keys: %s", err)
+	}
+	set, err := a.JWKS()
+	if err != nil {
+		t.Fatalf("publishing keys: %s", err)
+	}
+	if n := len(set.Keys); n != 2 {
+		t.Fatalf("expected 2 published keys, got %d", n)
+	}
+	tkn, err := a.GenerateToken(claims)
+	if err != nil {
+		t.Fatalf("generating token: %s", err)
+	}
+	if got := kid(tkn); got != "a" {
+		t.Fatalf("expected the token to be signed with key a, got %q", got)
+	}
+
+	if _, err := auth.RotateKeys(dir, "RS256", newKey("c"), now, 0, time.Hour); err == nil {
+		t.Fatal("expected a rotation to be refused while a later key waits")
+	}
+
+	// Once active, the new key signs and the old one still verifies.
+	current, err := keys.Current(now.Add(time.Hour + time.Second))
+	if err != nil {
+		t.Fatalf("getting current key: %s", err)
+	}
+	if current.ID != "b" {
+		t.Fatalf("expected key b to sign once active, got %q", current.ID)
+	}
+	if _, err := a.ParseClaims(old); err != nil {
+		t.Fatalf("parsing token of the replaced key: %s", err)
+	}
+
+	published := keys.Published(now.Add(3 * time.Hour))
+	if len(published) != 1 || published[0].ID != "b" {
+		t.Fatalf("expected key a to be retired, got %+v", published)
+	}
+
+	// Keys not fitting the algorithm are refused, and a directory holding one
+	// leaves the keys as they were.
+	ed, err := auth.GenerateKey(auth.KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	if _, err := auth.RotateKeys(dir, "RS256", auth.SigningKey{ID: "d", PrivateKey: ed}, now.Add(2*time.Hour), 0, time.Hour); err == nil {
+		t.Fatal("expected a rotation to an Ed25519 key to be refused for RS256")
+	}
+	if err := auth.WriteKey(dir, auth.SigningKey{ID: "d", PrivateKey: ed}); err != nil {
+		t.Fatalf("writing key: %s", err)
+	}
+	if err := keys.Reload(); err == nil {
+		t.Fatal("expected reloading with a key not fitting the algorithm to fail")
+	}
+	if err := os.Remove(filepath.Join(dir, "d.pem")); err != nil {
+		t.Fatalf("removing key: %s", err)
+	}
+
+	// A directory without key to sign with leaves the keys as they were.
+	if err := os.Remove(filepath.Join(dir, "a.pem")); err != nil {
+		t.Fatalf("removing key: %s", err)
+	}
+	if err := keys.Reload(); err == nil {
+		t.Fatal("expected reloading without active key to fail")
+	}
+	if _, err := a.GenerateToken(claims); err != nil {
+		t.Fatalf("generating token after a failed reload: %s", err)
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+// These are the permissions roles can grant. Any authenticated user may do
+// what is not covered by a permission.
+const (
+	PermUserRead        = "user:read"
+	PermUserWrite       = "user:write"
+	PermRoleManage      = "role:manage"
+	PermProductDelete   = "product:delete"
+	PermProductManage   = "product:manage"
+	PermSaleCreate      = "sale:create"
+	PermPaymentCreate   = "payment:create"
+	PermCustomerDelete  = "customer:delete"
+	PermTaxWrite        = "tax:write"
+	PermEventWrite      = "event:write"
+	PermReportRead      = "report:read"
+	PermDrawerManage    = "drawer:manage"
+	PermLockoutManage   = "lockout:manage"
+	PermOrgManage       = "org:manage"
+	PermUserImpersonate = "user:impersonate"
+)
+
+// Permissions lists all known permissions.
+var Permissions = []string{
+	PermUserRead,
+	PermUserWrite,
+	PermRoleManage,
+	PermProductDelete,
+	PermProductManage,
+	PermSaleCreate,
+	PermPaymentCreate,
+	PermCustomerDelete,
+	PermTaxWrite,
+	PermEventWrite,
+	PermReportRead,
+	PermDrawerManage,
+	PermLockoutManage,
+	PermOrgManage,
+	PermUserImpersonate,
+}
+
+// AdminPermissions lists the permissions over other users, shared data or
+// the whole deployment, which may require two factor authentication.
+var AdminPermissions = []string{
+	PermUserRead,
+	PermUserWrite,
+	PermRoleManage,
+	PermProductManage,
+	PermDrawerManage,
+	PermLockoutManage,
+	PermOrgManage,
+	PermUserImpersonate,
+}
+
+// HasPermission returns true if the claims grant the permission.
+func (c Claims) HasPermission(permission string) bool {
+
+	for _, has := range c.Permissions {
+		if has == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAdminPermission returns true if the claims grant any of the admin
+// permissions.
+func (c Claims) HasAdminPermission() bool {
+
+	for _, permission := range AdminPermissions {
+		if c.HasPermission(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tenant gives the organization the claims restrict data to, or nil when they
+// may cross tenants.
+func (c Claims) Tenant() *string {
+
+	if c.HasPermission(PermOrgManage) {
+		return nil
+	}
+	return &c.OrgID
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
 // ctxKey represents the type of value for the context key.
@@ -14,24 +15,41 @@ const Key ctxKey = 1
 
 // These are the expected values for Claims.Roles.
 const (
-	RoleAdmin = "ADMIN"
-	RoleUser  = "USER"
+	RoleSuperAdmin = "SUPERADMIN"
+	RoleAdmin      = "ADMIN"
+	RoleUser       = "USER"
 )
 
-// Claims represents the authorization claims transmitted via a JWT.
+// Claims represents the authorization claims transmitted via a JWT. The
+// Permissions are the ones granted by the Roles when the claims were made.
+// OrgID is the organization of the user, the only one whose data they may
+// reach unless they may cross tenants. Act is set when someone else acts as
+// the user, and KeyID when the claims come from an API key.
 type Claims struct {
-	Roles []string `json:"roles"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	OrgID       string   `json:"org_id"`
+	Act         *Actor   `json:"act,omitempty"`
+	KeyID       string   `json:"key_id,omitempty"`
 	jwt.StandardClaims
 }
 
+// Actor identifies who really acts when impersonating the subject of Claims,
+// as the act claim of RFC 8693.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
 // NewClaims constructs a Claims value for the identified user. The Claims
-// expire within a specified duration of the provided time. Additional fields
-// of the Claims can be set after calling NewClaims is desired.
+// expire within a specified duration of the provided time and get a unique id
+// allowing to revoke them. Additional fields of the Claims can be set after
+// calling NewClaims is desired.
 func NewClaims(subject string, roles []string, now time.Time, expires time.Duration) Claims {
 
 	c := Claims{
 		Roles: roles,
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
 			Subject:   subject,
 			IssuedAt:  now.Unix(),
 			ExpiresAt: now.Add(expires).Unix(),
@@ -41,6 +59,18 @@ func NewClaims(subject string, roles []string, now time.Time, expires time.Durat
 	return c
 }
 
+// Impersonated tells whether the claims are used by someone else than their
+// subject.
+func (c Claims) Impersonated() bool {
+	return c.Act != nil
+}
+
+// FromAPIKey tells whether the claims come from an API key rather than a
+// token.
+func (c Claims) FromAPIKey() bool {
+	return c.KeyID != ""
+}
+
 // HasRole returns true if the claims has at least one of the provided roles.
 func (c Claims) HasRole(roles ...string) bool {
 
@@ -0,0 +1,105 @@
+// Package mail provides support for sending emails through pluggable Mailer
+// implementations.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is an email to be sent. The Body is plain text.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is the behavior required for sending emails.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTPConfig is what we require to send emails through an SMTP server.
+type SMTPConfig struct {
+	Host     string // host:port
+	Username string
+	Password string
+	From     string
+}
+
+// SMTP is a Mailer that sends emails through an SMTP server.
+type SMTP struct {
+	cfg SMTPConfig
+}
+
+// NewSMTP creates an SMTP Mailer. Authentication is only used when a username
+// is provided.
+func NewSMTP(cfg SMTPConfig) *SMTP {
+	return &SMTP{cfg: cfg}
+}
+
+// Send delivers the message to the SMTP server.
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+
+	var a smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(s.cfg.Host)
+		if err != nil {
+			return errors.Wrap(err, "parsing smtp host")
+		}
+		a = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+
+	if err := smtp.SendMail(s.cfg.Host, a, s.cfg.From, []string{m.To}, s.format(m)); err != nil {
+		return errors.Wrapf(err, "sending mail to %q", m.To)
+	}
+	return nil
+}
+
+// format renders a message as an RFC 5322 document.
+func (s *SMTP) format(m Message) []byte {
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// Writer is a Mailer that writes emails to an io.Writer instead of sending
+// them, such as a file or the log. It is meant for development and tests.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter creates a Writer Mailer.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Send writes the message.
+func (w *Writer) Send(ctx context.Context, m Message) error {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := fmt.Fprintf(w.w, "To: %s\nSubject: %s\n\n%s\n----\n", m.To, m.Subject, m.Body)
+	if err != nil {
+		return errors.Wrap(err, "writing mail")
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidLogin occurs when the Login a user comes back from the provider
+// with is malformed, tampered with or expired.
+var ErrInvalidLogin = errors.New("invalid or expired login")
+
+// Login holds the secrets of a login in progress: the state binding the
+// response of the provider to the user agent that started it, the nonce
+// binding the ID token to it and the PKCE code verifier. It is kept by the
+// user agent meanwhile, signed.
+type Login struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Expires  int64  `json:"expires"`
+}
+
+// NewLogin starts a Login that has to complete before the expiration time.
+func NewLogin(expires time.Time) (Login, error) {
+
+	var secrets [3]string
+	for i := range secrets {
+
+		// 32 bytes give a 43 characters verifier, the minimum PKCE allows.
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return Login{}, errors.Wrap(err, "generating login secrets")
+		}
+		secrets[i] = base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	l := Login{
+		State:    secrets[0],
+		Nonce:    secrets[1],
+		Verifier: secrets[2],
+		Expires:  expires.Unix(),
+	}
+
+	return l, nil
+}
+
+// Encode gives the Login as a string signed with key.
+func (l Login) Encode(key []byte) string {
+
+	payload, _ := json.Marshal(l)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(key, payload))
+}
+
+// ParseLogin gives the Login encoded with key, provided it did not expire.
+func ParseLogin(key []byte, s string, now time.Time) (Login, error) {
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return Login{}, ErrInvalidLogin
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Login{}, ErrInvalidLogin
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Login{}, ErrInvalidLogin
+	}
+	if !hmac.Equal(sig, sign(key, payload)) {
+		return Login{}, ErrInvalidLogin
+	}
+
+	var l Login
+	if err := json.Unmarshal(payload, &l); err != nil {
+		return Login{}, ErrInvalidLogin
+	}
+	if now.Unix() > l.Expires {
+		return Login{}, ErrInvalidLogin
+	}
+
+	return l, nil
+}
+
+// Challenge gives the S256 PKCE code challenge of a code verifier.
+func Challenge(verifier string) string {
+
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// sign computes the HMAC-SHA256 of data with key. The key may sign other
+// tokens too, so the data is prefixed to tell them apart.
+func sign(key, data []byte) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("oidc|"))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
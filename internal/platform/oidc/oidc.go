@@ -0,0 +1,272 @@
+// Package oidc provides support for logging users in through an OpenID
+// Connect provider, using the authorization code flow with PKCE: the provider
+// is found by discovery and the ID tokens it issues are validated against the
+// keys it publishes.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidToken occurs when an ID token is malformed, not signed by the
+	// provider or not issued for us and this login.
+	ErrInvalidToken = errors.New("invalid id token")
+
+	// ErrCodeRefused occurs when the provider does not give an ID token for an
+	// authorization code, as it is unknown, used or given without its code
+	// verifier.
+	ErrCodeRefused = errors.New("authorization code refused")
+)
+
+// Config is what we require to use an OpenID Connect provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // openid is always requested
+}
+
+// Identity is who the provider tells the user is.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// metadata is the part of the provider configuration we use.
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Provider is an OpenID Connect provider we log users in with.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	meta   metadata
+	keys   *auth.KeySet
+}
+
+// Discover finds the endpoints and keys of the provider from its discovery
+// document.
+func Discover(ctx context.Context, client *http.Client, cfg Config) (*Provider, error) {
+
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("issuer, client id and redirect url are required")
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	var meta metadata
+	if err := getJSON(ctx, client, wellKnown, &meta); err != nil {
+		return nil, errors.Wrap(err, "discovering provider")
+	}
+
+	// The discovery document must be the one of the issuer we trust, as it
+	// tells which issuer to expect in ID tokens.
+	if meta.Issuer != cfg.Issuer {
+		return nil, errors.Errorf("discovered issuer %q does not match %q", meta.Issuer, cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery document misses endpoints")
+	}
+
+	p := Provider{
+		cfg:    cfg,
+		client: client,
+		meta:   meta,
+		keys:   auth.NewKeySet(client, meta.JWKSURI),
+	}
+
+	return &p, nil
+}
+
+// AuthCodeURL gives the URL of the provider to send the user to for starting
+// the Login.
+func (p *Provider) AuthCodeURL(l Login) string {
+
+	scopes := append([]string{"openid"}, p.cfg.Scopes...)
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {l.State},
+		"nonce":                 {l.Nonce},
+		"code_challenge":        {Challenge(l.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.meta.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange trades the authorization code the provider sent the user back
+// with for the Identity of the user, validating the ID token it comes with.
+func (p *Provider) Exchange(ctx context.Context, l Login, code string, now time.Time) (Identity, error) {
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {l.Verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "creating token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "requesting token")
+	}
+	defer resp.Body.Close()
+
+	var tkn struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tkn); err != nil {
+		return Identity{}, errors.Wrapf(err, "decoding token response with status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || tkn.IDToken == "" {
+		return Identity{}, errors.Wrapf(ErrCodeRefused, "status %d: %s %s", resp.StatusCode, tkn.Error, tkn.ErrorDescription)
+	}
+
+	return p.Verify(ctx, tkn.IDToken, l.Nonce, now)
+}
+
+// Verify validates an ID token: it has to be signed with a key of the
+// provider, issued by it for us and for the login using the nonce, and not
+// expired.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string, now time.Time) (Identity, error) {
+
+	// The algorithm used to sign the JWT must be validated to avoid a critical
+	// vulnerability, like for our own tokens. Time based claims are checked
+	// below, against now.
+	parser := jwt.Parser{
+		ValidMethods:         p.algorithms(),
+		SkipClaimsValidation: true,
+	}
+
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.Lookup(ctx, kid)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(idToken, claims, keyFunc); err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	nonceClaim, _ := claims["nonce"].(string)
+	switch {
+	case !claims.VerifyIssuer(p.meta.Issuer, true):
+		return Identity{}, errors.Wrap(ErrInvalidToken, "unexpected issuer")
+	case !hasAudience(claims["aud"], p.cfg.ClientID):
+		return Identity{}, errors.Wrap(ErrInvalidToken, "not issued for us")
+	case !claims.VerifyExpiresAt(now.Unix(), true):
+		return Identity{}, errors.Wrap(ErrInvalidToken, "expired")
+	case nonceClaim == "" || nonceClaim != nonce:
+		return Identity{}, errors.Wrap(ErrInvalidToken, "unexpected nonce")
+	}
+
+	id := Identity{Issuer: p.meta.Issuer}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+	if id.Subject == "" {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "missing subject")
+	}
+
+	// Some providers send the boolean as a string.
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		id.EmailVerified = v == "true"
+	}
+
+	return id, nil
+}
+
+// algorithms gives the signing algorithms accepted for ID tokens: the ones
+// the provider supports among those we verify, RS256 being mandatory.
+func (p *Provider) algorithms() []string {
+
+	algs := []string{"RS256"}
+	for _, alg := range p.meta.SigningAlgorithms {
+		switch alg {
+		case "RS384", "RS512", "ES256", "ES384", auth.SigningMethodEdDSA.Alg():
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// hasAudience tells whether the aud claim, a string or an array of them,
+// holds the client id.
+func hasAudience(aud interface{}, clientID string) bool {
+
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getJSON decodes the JSON document at the url into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
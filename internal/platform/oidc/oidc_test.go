@@ -0,0 +1,146 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/oidc"
+	"github.com/devisions/garagesale/internal/platform/oidc/oidctest"
+	"github.com/pkg/errors"
+)
+
+func TestLogin(t *testing.T) {
+
+	key := []byte("test key")
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	l, err := oidc.NewLogin(now.Add(10 * time.Minute))
+	if err != nil {
+		t.Fatalf("starting login: %s", err)
+	}
+	if len(l.Verifier) < 43 || l.State == l.Nonce {
+		t.Fatalf("unexpected login secrets: %+v", l)
+	}
+
+	s := l.Encode(key)
+	got, err := oidc.ParseLogin(key, s, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("parsing login: %s", err)
+	}
+	if got != l {
+		t.Fatalf("expected %+v, got %+v", l, got)
+	}
+
+	if _, err := oidc.ParseLogin([]byte("other key"), s, now); err != oidc.ErrInvalidLogin {
+		t.Fatalf("expected ErrInvalidLogin with another key, got %v", err)
+	}
+	if _, err := oidc.ParseLogin(key, s, now.Add(time.Hour)); err != oidc.ErrInvalidLogin {
+		t.Fatalf("expected ErrInvalidLogin once expired, got %v", err)
+	}
+}
+
+func TestProvider(t *testing.T) {
+
+	stub, err := oidctest.NewProvider("garagesale", "secret")
+	if err != nil {
+		t.Fatalf("starting provider: %s", err)
+	}
+	defer stub.Close()
+
+	want := oidc.Identity{
+		Issuer:        stub.Issuer(),
+		Subject:       "volunteer-1",
+		Email:         "volunteer@example.com",
+		EmailVerified: true,
+		Name:          "Volunteer",
+	}
+	stub.SetIdentity(want)
+
+	ctx := context.Background()
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	p, err := oidc.Discover(ctx, client, oidc.Config{
+		Issuer:       stub.Issuer(),
+		ClientID:     "garagesale",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8000/v1/users/oidc/callback",
+		Scopes:       []string{"email", "profile"},
+	})
+	if err != nil {
+		t.Fatalf("discovering: %s", err)
+	}
+
+	// authorize follows the user agent to the provider and back, giving the
+	// code it comes back with.
+	authorize := func(l oidc.Login) string {
+		resp, err := client.Get(p.AuthCodeURL(l))
+		if err != nil {
+			t.Fatalf("authorizing: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("authorizing: expected status code %v, got %v", http.StatusFound, resp.StatusCode)
+		}
+		back, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("parsing redirect: %s", err)
+		}
+		if back.Query().Get("state") != l.State {
+			t.Fatalf("expected state %q, got %q", l.State, back.Query().Get("state"))
+		}
+		return back.Query().Get("code")
+	}
+
+	now := time.Now()
+	l, err := oidc.NewLogin(now.Add(10 * time.Minute))
+	if err != nil {
+		t.Fatalf("starting login: %s", err)
+	}
+	code := authorize(l)
+
+	got, err := p.Exchange(ctx, l, code, now)
+	if err != nil {
+		t.Fatalf("exchanging code: %s", err)
+	}
+	if got != want {
+		t.Fatalf("expected identity %+v, got %+v", want, got)
+	}
+
+	if _, err := p.Exchange(ctx, l, code, now); err == nil {
+		t.Fatal("expected a code to be used only once")
+	}
+
+	// Without the code verifier, an intercepted code is useless.
+	other, err := oidc.NewLogin(now.Add(10 * time.Minute))
+	if err != nil {
+		t.Fatalf("starting login: %s", err)
+	}
+	code = authorize(l)
+	if _, err := p.Exchange(ctx, other, code, now); err == nil {
+		t.Fatal("expected the exchange to fail with another verifier")
+	}
+
+	idToken, err := stub.IDToken(want, l.Nonce, now)
+	if err != nil {
+		t.Fatalf("signing id token: %s", err)
+	}
+	tests := []struct {
+		name  string
+		nonce string
+		now   time.Time
+	}{
+		{"other nonce", other.Nonce, now},
+		{"expired", l.Nonce, now.Add(2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		if _, err := p.Verify(ctx, idToken, tt.nonce, tt.now); errors.Cause(err) != oidc.ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", tt.name, err)
+		}
+	}
+}
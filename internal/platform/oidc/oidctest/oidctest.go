@@ -0,0 +1,224 @@
+// Package oidctest provides a stub OpenID Connect provider for tests. It
+// approves every authorization request right away, for the Identity it is
+// told to, and enforces PKCE on the token endpoint.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/oidc"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// keyID is the id of the key the provider signs ID tokens with.
+const keyID = "stub"
+
+// grant is an authorization code waiting to be exchanged.
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	identity    oidc.Identity
+}
+
+// Provider is a stub OpenID Connect provider, running until closed.
+type Provider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu       sync.Mutex
+	identity oidc.Identity
+	grants   map[string]grant
+}
+
+// NewProvider starts a stub provider for a client.
+func NewProvider(clientID, clientSecret string) (*Provider, error) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		grants:       make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+
+	return &p, nil
+}
+
+// Issuer gives the issuer identifier of the provider.
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// SetIdentity tells who the users logging in from now on are. The Issuer of
+// the Identity is ignored.
+func (p *Provider) SetIdentity(id oidc.Identity) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.identity = id
+}
+
+// Close shuts the provider down.
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// IDToken signs an ID token for the client with the claims of the identity
+// and the nonce, issued at now.
+func (p *Provider) IDToken(id oidc.Identity, nonce string, now time.Time) (string, error) {
+
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer(),
+		"sub":            id.Subject,
+		"aud":            []string{p.ClientID},
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          id.Email,
+		"email_verified": id.EmailVerified,
+		"name":           id.Name,
+	}
+
+	tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tkn.Header["kid"] = keyID
+
+	return tkn.SignedString(p.key)
+}
+
+// discovery serves the provider configuration.
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+
+	doc := map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	}
+	respond(w, doc, http.StatusOK)
+}
+
+// jwks serves the public key of the provider.
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+
+	key, err := auth.NewJWK(keyID, "RS256", &p.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respond(w, auth.JWKS{Keys: []auth.JWK{key}}, http.StatusOK)
+}
+
+// authorize approves the request and sends the user agent back to the client
+// with a code.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+
+	q := r.URL.Query()
+	if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	code := uuid.New().String()
+	p.grants[code] = grant{
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		identity:    p.identity,
+	}
+	p.mu.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges a code for an ID token, given the code verifier.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	} else {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if id != p.ClientID || secret != p.ClientSecret {
+		respond(w, map[string]string{"error": "invalid_client"}, http.StatusUnauthorized)
+		return
+	}
+
+	// Codes can be used only once.
+	p.mu.Lock()
+	code := r.PostFormValue("code")
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != g.redirectURI ||
+		oidc.Challenge(r.PostFormValue("code_verifier")) != g.challenge {
+		respond(w, map[string]string{"error": "invalid_grant"}, http.StatusBadRequest)
+		return
+	}
+
+	idToken, err := p.IDToken(g.identity, g.nonce, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"access_token": uuid.New().String(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	}
+	respond(w, resp, http.StatusOK)
+}
+
+// respond writes v as JSON.
+func respond(w http.ResponseWriter, v interface{}, status int) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,107 @@
+// Package totp implements time-based one-time passwords as described in
+// RFC 6238, the way authenticator apps expect them: HMAC-SHA1, 6 digits and
+// 30 second steps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Period is the duration of a time step.
+const Period = 30 * time.Second
+
+// Digits is the number of digits of a code.
+const Digits = 6
+
+// encoding is how secrets are written for authenticator apps.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random secret, base32 encoded.
+func NewSecret() (string, error) {
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Wrap(err, "generating secret")
+	}
+	return encoding.EncodeToString(key), nil
+}
+
+// Step gives the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code gives the code of a base32 encoded secret for a time step.
+func Code(secret string, step int64) (string, error) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.Wrap(err, "decoding secret")
+	}
+	return hotp(key, step, Digits), nil
+}
+
+// Validate tells whether the code is the one of the secret for the time step
+// of now or an adjacent one, allowing for clock drift. It gives the matching
+// step, so that callers can refuse codes of steps that were already used.
+func Validate(secret, code string, now time.Time) (int64, bool) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	step := Step(now)
+	for _, s := range []int64{step, step - 1, step + 1} {
+		if subtle.ConstantTimeCompare([]byte(code), []byte(hotp(key, s, Digits))) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// URI gives the provisioning URI of a secret, to be shown as a QR code for
+// authenticator apps to scan.
+func URI(issuer, account, secret string) string {
+
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// hotp computes the HOTP value (RFC 4226) of the key for a counter.
+func hotp(key []byte, counter int64, digits int) string {
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,74 @@
+package totp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/totp"
+)
+
+// secret is the SHA1 key of the RFC 6238 test vectors, base32 encoded.
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+
+	// The RFC gives 8 digit codes, of which ours are the last 6.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := totp.Code(secret, totp.Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("computing code: %s", err)
+		}
+		if got != tt.want {
+			t.Errorf("at %d: expected %s, got %s", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	now := time.Unix(1111111111, 0)
+	step := totp.Step(now)
+
+	for _, s := range []int64{step - 1, step, step + 1} {
+		c, err := totp.Code(secret, s)
+		if err != nil {
+			t.Fatalf("computing code: %s", err)
+		}
+		got, ok := totp.Validate(secret, c, now)
+		if !ok || got != s {
+			t.Errorf("code of step %d: expected to match step %d, got %d, %v", s, s, got, ok)
+		}
+	}
+
+	c, err := totp.Code(secret, step+2)
+	if err != nil {
+		t.Fatalf("computing code: %s", err)
+	}
+	if _, ok := totp.Validate(secret, c, now); ok {
+		t.Error("expected a code two steps ahead to be refused")
+	}
+}
+
+func TestURI(t *testing.T) {
+
+	uri := totp.URI("Garage Sale", "admin@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Garage%20Sale:admin@example.com?") {
+		t.Errorf("unexpected label in %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("secret missing in %s", uri)
+	}
+}
@@ -23,11 +23,13 @@ type ctxKey int
 // KeyValues is how request values or stored/retrieved.
 const KeyValues ctxKey = 1
 
-// Values carries information about each request.
+// Values carries information about each request. Actor is who really made
+// the request when impersonating its authenticated user.
 type Values struct {
 	StatusCode int
 	Start      time.Time
 	TraceID    string
+	Actor      string
 }
 
 // -------------------------------------------------------
@@ -1,25 +1,35 @@
 package product
 
-import "time"
+import (
+	"time"
+
+	"github.com/devisions/garagesale/internal/payment"
+)
 
 // Product is something we sell.
 type Product struct {
 	ID          string    `db:"product_id"    json:"id"`
 	Name        string    `                   json:"name"`
+	Category    string    `db:"category"      json:"category"`
 	Cost        int       `                   json:"cost"`
 	Quantity    int       `                   json:"quantity"`
 	Sold        int       `db:"sold"          json:"sold"`
 	Revenue     int       `db:"revenue"       json:"revenue"`
 	UserID      string    `db:"user_id"       json:"user_id"`
+	OrgID       string    `db:"org_id"        json:"org_id"`
+	EventID     *string   `db:"event_id"      json:"event_id"`
 	DateCreated time.Time `db:"date_created"  json:"date_created"`
 	DateUpdated time.Time `db:"date_updated"  json:"date_updated"`
 }
 
-// NewProduct is the input request for creating a new Product.
+// NewProduct is the input request for creating a new Product. EventID is
+// optional and assigns the Product to the Event it will be sold at.
 type NewProduct struct {
-	Name     string `json:"name"      validate:"required"`
-	Cost     int    `json:"cost"      validate:"gte=0"`
-	Quantity int    `json:"quantity"  validate:"gte=1"`
+	Name     string  `json:"name"      validate:"required"`
+	Category string  `json:"category"`
+	Cost     int     `json:"cost"      validate:"gte=0"`
+	Quantity int     `json:"quantity"  validate:"gte=1"`
+	EventID  *string `json:"event_id"  validate:"omitempty,uuid"`
 }
 
 // UpdateProduct defines what information may be provided to modify an
@@ -27,27 +37,94 @@ type NewProduct struct {
 // fields they want changed. It uses pointer fields so we can differentiate
 // between a field that was not provided and a field that was provided as
 // explicitly blank. Normally we do not want to use pointers to basic types but
-// we make exceptions around marshalling/unmarshalling.
+// we make exceptions around marshalling/unmarshalling. An explicitly blank
+// EventID removes the Product from its Event.
 type UpdateProduct struct {
 	Name     *string `json:"name"`
+	Category *string `json:"category"`
 	Cost     *int    `json:"cost"      validate:"omitempty,gte=0"`
 	Quantity *int    `json:"quantity"  validate:"omitempty,gte=1"`
+	EventID  *string `json:"event_id"`
 }
 
 // Sale represents one item of a transaction where some amount of a product was
 // sold. Quantity is the number of units sold and Paid is the total price paid.
 // Note that due to haggling the Paid value might not equal Quantity sold *
 // Product cost.
+//
+// A Sale is attributed to the Event that was active when it was recorded.
+// Tax is the sales tax computed with the tax rate that applied when the Sale
+// was recorded. Depending on the rate it is either included in Paid or was
+// charged on top of it, as TaxInclusive tells.
 type Sale struct {
-	ID          string    `db:"sale_id"       json:"id"`
+	ID           string    `db:"sale_id"         json:"id"`
+	ProductID    string    `db:"product_id"      json:"product_id"`
+	Quantity     int       `db:"quantity"        json:"quantity"`
+	Paid         int       `db:"paid"            json:"paid"`
+	Tax          int       `db:"tax"             json:"tax"`
+	TaxInclusive bool      `db:"tax_inclusive"   json:"tax_inclusive"`
+	TaxRateID    *string   `db:"tax_rate_id"     json:"tax_rate_id"`
+	CustomerID   *string   `db:"customer_id"     json:"customer_id"`
+	EventID      *string   `db:"event_id"        json:"event_id"`
+	OrgID        string    `db:"org_id"          json:"org_id"`
+	DateCreated  time.Time `db:"date_created"    json:"date_created"`
+}
+
+// Due gives what the buyer owes in total for the Sale.
+func (s Sale) Due() int {
+	if s.TaxInclusive {
+		return s.Paid
+	}
+	return s.Paid + s.Tax
+}
+
+// NewSale is what we require from clients for recording new transactions.
+// CustomerID is optional and, if provided, links the Sale to the Customer
+// who bought the product.
+//
+// Payments are the payments made when the Sale is recorded. If omitted, the
+// Sale is considered fully paid in cash. An explicitly empty list records a
+// Sale that is still to be paid.
+type NewSale struct {
+	Quantity   int                  `json:"quantity"`
+	Paid       int                  `json:"paid"`
+	CustomerID *string              `json:"customer_id"  validate:"omitempty,uuid"`
+	Payments   []payment.NewPayment `json:"payments"     validate:"dive"`
+}
+
+// These are the possible values of Offer.Status. A pending Offer awaits a
+// response from the owner of the Product, a countered one awaits a response
+// from the buyer.
+const (
+	OfferPending   = "pending"
+	OfferCountered = "countered"
+	OfferAccepted  = "accepted"
+	OfferRejected  = "rejected"
+)
+
+// Offer is a proposal from a buyer to purchase some amount of a Product for a
+// total Amount. The owner of the Product and the buyer take turns to respond
+// to it, until one of them accepts or rejects it. Amount is always the last
+// price on the table. An accepted Offer becomes a Sale at that price.
+type Offer struct {
+	ID          string    `db:"offer_id"      json:"id"`
 	ProductID   string    `db:"product_id"    json:"product_id"`
+	BuyerID     string    `db:"buyer_id"      json:"buyer_id"`
 	Quantity    int       `db:"quantity"      json:"quantity"`
-	Paid        int       `db:"paid"          json:"paid"`
+	Amount      int       `db:"amount"        json:"amount"`
+	Status      string    `db:"status"        json:"status"`
+	SaleID      *string   `db:"sale_id"       json:"sale_id"`
 	DateCreated time.Time `db:"date_created"  json:"date_created"`
+	DateUpdated time.Time `db:"date_updated"  json:"date_updated"`
 }
 
-// NewSale is what we require from clients for recording new transactions.
-type NewSale struct {
-	Quantity int `json:"quantity"`
-	Paid     int `json:"paid"`
+// NewOffer is what we require from buyers for making an Offer.
+type NewOffer struct {
+	Quantity int `json:"quantity"  validate:"gte=1"`
+	Amount   int `json:"amount"    validate:"gte=0"`
+}
+
+// NewCounter is what we require for countering an Offer with a new Amount.
+type NewCounter struct {
+	Amount int `json:"amount"  validate:"gte=0"`
 }
@@ -0,0 +1,247 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devisions/garagesale/internal/payment"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// AddOffer records an Offer made by the user for a Product. Owners cannot
+// make offers on their own Products.
+func AddOffer(ctx context.Context, db *sqlx.DB, user auth.Claims, no NewOffer, productID string, now time.Time) (*Offer, error) {
+
+	p, err := Retrieve(ctx, db, user, productID)
+	if err != nil {
+		return nil, err
+	}
+	if p.UserID == user.Subject {
+		return nil, ErrForbidden
+	}
+
+	o := Offer{
+		ID:          uuid.New().String(),
+		ProductID:   p.ID,
+		BuyerID:     user.Subject,
+		Quantity:    no.Quantity,
+		Amount:      no.Amount,
+		Status:      OfferPending,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO offers
+		(offer_id, product_id, buyer_id, quantity, amount, status, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = db.ExecContext(ctx, q,
+		o.ID, o.ProductID, o.BuyerID,
+		o.Quantity, o.Amount, o.Status,
+		o.DateCreated, o.DateUpdated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting offer")
+	}
+
+	return &o, nil
+}
+
+// ListOffers gives the Offers made for a Product. Users allowed to manage
+// products and the owner of the Product see all of them, any other user sees
+// only their own.
+func ListOffers(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) ([]Offer, error) {
+
+	p, err := Retrieve(ctx, db, user, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	offers := []Offer{}
+
+	if user.HasPermission(auth.PermProductManage) || user.Subject == p.UserID {
+		const q = `SELECT * FROM offers WHERE product_id = $1 ORDER BY date_created`
+		if err := db.SelectContext(ctx, &offers, q, p.ID); err != nil {
+			return nil, errors.Wrap(err, "selecting offers")
+		}
+		return offers, nil
+	}
+
+	const q = `SELECT * FROM offers WHERE product_id = $1 AND buyer_id = $2 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &offers, q, p.ID, user.Subject); err != nil {
+		return nil, errors.Wrap(err, "selecting offers")
+	}
+	return offers, nil
+}
+
+// RetrieveOffer gives a single Offer of a Product. Only users allowed to
+// manage products, the owner of the Product and the buyer can see it.
+func RetrieveOffer(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, offerID string) (*Offer, error) {
+
+	o, p, err := retrieveOffer(ctx, db, user, productID, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.HasPermission(auth.PermProductManage) && user.Subject != p.UserID && user.Subject != o.BuyerID {
+		return nil, ErrForbidden
+	}
+
+	return o, nil
+}
+
+// AcceptOffer accepts the price currently on the table and records the Sale.
+// The Sale starts unpaid, payments are expected when the buyer picks it up.
+// The Offer is accepted along with recording the Sale, or not at all.
+func AcceptOffer(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, offerID string, now time.Time) (*Sale, error) {
+
+	o, p, err := retrieveOffer(ctx, db, user, productID, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	// The Offer is read again and locked, so that it cannot be accepted twice.
+	const ql = `SELECT * FROM offers WHERE offer_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, o, ql, o.ID); err != nil {
+		return nil, errors.Wrap(err, "locking offer")
+	}
+	if err := checkTurn(user, o, p); err != nil {
+		return nil, err
+	}
+
+	if err := setOfferStatus(ctx, tx, o, OfferAccepted, o.Amount, now); err != nil {
+		return nil, err
+	}
+
+	s, err := addSale(ctx, tx, user, NewSale{Quantity: o.Quantity, Paid: o.Amount, Payments: []payment.NewPayment{}}, o.ProductID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `UPDATE offers SET sale_id = $2 WHERE offer_id = $1`
+	if _, err := tx.ExecContext(ctx, q, o.ID, s.ID); err != nil {
+		return nil, errors.Wrap(err, "linking offer to sale")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing offer")
+	}
+
+	return s, nil
+}
+
+// RejectOffer closes an Offer without a Sale.
+func RejectOffer(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, offerID string, now time.Time) error {
+
+	o, p, err := retrieveOffer(ctx, db, user, productID, offerID)
+	if err != nil {
+		return err
+	}
+	if err := checkTurn(user, o, p); err != nil {
+		return err
+	}
+
+	return setOfferStatus(ctx, db, o, OfferRejected, o.Amount, now)
+}
+
+// CounterOffer puts a new price on the table and hands the turn over to the
+// other party.
+func CounterOffer(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, offerID string, nc NewCounter, now time.Time) (*Offer, error) {
+
+	o, p, err := retrieveOffer(ctx, db, user, productID, offerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTurn(user, o, p); err != nil {
+		return nil, err
+	}
+
+	status := OfferCountered
+	if o.Status == OfferCountered {
+		status = OfferPending
+	}
+	if err := setOfferStatus(ctx, db, o, status, nc.Amount, now); err != nil {
+		return nil, err
+	}
+
+	o.Status = status
+	o.Amount = nc.Amount
+	o.DateUpdated = now.UTC()
+	return o, nil
+}
+
+// retrieveOffer gives an Offer together with the Product it is made for.
+func retrieveOffer(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, offerID string) (*Offer, *Product, error) {
+
+	if _, err := uuid.Parse(offerID); err != nil {
+		return nil, nil, ErrInvalidID
+	}
+
+	p, err := Retrieve(ctx, db, user, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var o Offer
+	const q = `SELECT * FROM offers WHERE offer_id = $1 AND product_id = $2`
+	if err := db.GetContext(ctx, &o, q, offerID, p.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrOfferNotFound
+		}
+		return nil, nil, errors.Wrap(err, "selecting single offer")
+	}
+
+	return &o, p, nil
+}
+
+// checkTurn tells if the user is the party expected to respond to the Offer.
+// Users allowed to manage products may always respond on behalf of the owner.
+func checkTurn(user auth.Claims, o *Offer, p *Product) error {
+
+	switch o.Status {
+	case OfferPending:
+		if !user.HasPermission(auth.PermProductManage) && user.Subject != p.UserID {
+			return ErrForbidden
+		}
+	case OfferCountered:
+		if user.Subject != o.BuyerID {
+			return ErrForbidden
+		}
+	default:
+		return ErrOfferClosed
+	}
+	return nil
+}
+
+// setOfferStatus moves an Offer to a new status and amount. It fails with
+// ErrOfferClosed if the Offer changed since it was read.
+func setOfferStatus(ctx context.Context, db sqlx.ExecerContext, o *Offer, status string, amount int, now time.Time) error {
+
+	const q = `UPDATE offers SET
+		"status" = $3,
+		"amount" = $4,
+		"date_updated" = $5
+		WHERE offer_id = $1 AND status = $2`
+	res, err := db.ExecContext(ctx, q, o.ID, o.Status, status, amount, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "updating offer")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "updating offer")
+	}
+	if n == 0 {
+		return ErrOfferClosed
+	}
+
+	return nil
+}
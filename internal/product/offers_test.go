@@ -0,0 +1,84 @@
+package product_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/product"
+	"github.com/devisions/garagesale/internal/tests"
+)
+
+func TestOffers(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	owner := auth.NewClaims(
+		"718ffbea-f4a1-4667-8ae3-b349da52675e", // A random UUID.
+		[]string{auth.RoleUser},
+		now, time.Hour,
+	)
+	owner.OrgID = org.Default
+	buyer := auth.NewClaims(
+		"2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37", // Another random UUID.
+		[]string{auth.RoleUser},
+		now, time.Hour,
+	)
+	buyer.OrgID = org.Default
+
+	comics, err := product.Create(ctx, db, owner, product.NewProduct{Name: "Comic Books", Cost: 10, Quantity: 20}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+
+	if _, err := product.AddOffer(ctx, db, owner, product.NewOffer{Quantity: 1, Amount: 5}, comics.ID, now); err != product.ErrForbidden {
+		t.Fatalf("expected owner offer to be forbidden, got %v", err)
+	}
+
+	o, err := product.AddOffer(ctx, db, buyer, product.NewOffer{Quantity: 2, Amount: 12}, comics.ID, now)
+	if err != nil {
+		t.Fatalf("adding offer: %s", err)
+	}
+
+	// The buyer cannot respond to their own pending offer.
+	if err := product.RejectOffer(ctx, db, buyer, comics.ID, o.ID, now); err != product.ErrForbidden {
+		t.Fatalf("expected buyer reject to be forbidden, got %v", err)
+	}
+
+	o, err = product.CounterOffer(ctx, db, owner, comics.ID, o.ID, product.NewCounter{Amount: 16}, now)
+	if err != nil {
+		t.Fatalf("countering offer: %s", err)
+	}
+	if exp, got := product.OfferCountered, o.Status; exp != got {
+		t.Fatalf("expected offer status %q, got %q", exp, got)
+	}
+
+	s, err := product.AcceptOffer(ctx, db, buyer, comics.ID, o.ID, now)
+	if err != nil {
+		t.Fatalf("accepting offer: %s", err)
+	}
+	if exp, got := 16, s.Paid; exp != got {
+		t.Fatalf("expected sale paid %v, got %v", exp, got)
+	}
+
+	o, err = product.RetrieveOffer(ctx, db, owner, comics.ID, o.ID)
+	if err != nil {
+		t.Fatalf("retrieving offer: %s", err)
+	}
+	if exp, got := product.OfferAccepted, o.Status; exp != got {
+		t.Fatalf("expected offer status %q, got %q", exp, got)
+	}
+	if o.SaleID == nil || *o.SaleID != s.ID {
+		t.Fatalf("expected offer to be linked to sale %v, got %v", s.ID, o.SaleID)
+	}
+
+	if _, err := product.AcceptOffer(ctx, db, buyer, comics.ID, o.ID, now); err != product.ErrOfferClosed {
+		t.Fatalf("expected ErrOfferClosed, got %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/devisions/garagesale/internal/platform/auth"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
@@ -17,27 +18,66 @@ var (
 	ErrNotFound  = errors.New("product not found")
 	ErrInvalidID = errors.New("provided id is not a valid UUID")
 	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrUnknownCustomer is returned when a Sale references a customer that
+	// does not exist.
+	ErrUnknownCustomer = errors.New("sale references an unknown customer")
+
+	// ErrUnknownEvent is returned when a Product is assigned to an event that
+	// does not exist.
+	ErrUnknownEvent = errors.New("product references an unknown event")
+
+	// ErrOfferNotFound is returned when an Offer does not exist or is made
+	// for another Product.
+	ErrOfferNotFound = errors.New("offer not found")
+
+	// ErrOfferClosed is returned when responding to an Offer that was
+	// accepted or rejected already.
+	ErrOfferClosed = errors.New("offer is no longer open")
 )
 
-// List returns all known Products.
-func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
+// List returns all Products of the organization of the user.
+func List(ctx context.Context, db *sqlx.DB, user auth.Claims) ([]Product, error) {
+	return list(ctx, db, user, nil)
+}
 
-	list := []Product{}
+// ListActive returns the Products of the organization of the user, leaving
+// out those of sellers that are suspended or deactivated at the time.
+func ListActive(ctx context.Context, db *sqlx.DB, user auth.Claims, now time.Time) ([]Product, error) {
+	return list(ctx, db, user, &now)
+}
+
+// list returns the Products of the organization of the user, only those of
+// sellers active at the time if given.
+func list(ctx context.Context, db *sqlx.DB, user auth.Claims, activeAt *time.Time) ([]Product, error) {
+
+	products := []Product{}
+
+	var at *time.Time
+	if activeAt != nil {
+		utc := activeAt.UTC()
+		at = &utc
+	}
 
 	const q = `SELECT p.*,
 			   COALESCE(SUM(s.quantity), 0) AS sold, 
 			   COALESCE(SUM(s.paid), 0) AS revenue
 			   FROM products AS p
 			   LEFT JOIN sales AS s ON p.product_id = s.product_id
+			   WHERE ($1::uuid IS NULL OR p.org_id = $1)
+			   AND ($2::timestamp IS NULL OR NOT EXISTS (
+				   SELECT 1 FROM users AS u WHERE u.user_id = p.user_id
+				   AND u.status <> 'active' AND (u.status_until IS NULL OR u.status_until > $2)))
 			   GROUP BY p.product_id`
-	if err := db.SelectContext(ctx, &list, q); err != nil {
+	if err := db.SelectContext(ctx, &products, q, user.Tenant(), at); err != nil {
 		return nil, errors.Wrap(err, "selecting all products")
 	}
-	return list, nil
+	return products, nil
 }
 
-// Retrieve returns a single Product.
-func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
+// Retrieve returns a single Product. Products of other organizations than
+// the one of the user are not found.
+func Retrieve(ctx context.Context, db *sqlx.DB, user auth.Claims, id string) (*Product, error) {
 
 	if _, err := uuid.Parse(id); err != nil {
 		return nil, ErrInvalidID
@@ -48,9 +88,9 @@ func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 			   COALESCE(SUM(s.paid), 0) AS revenue
 			   FROM products AS p
 			   LEFT JOIN sales AS s ON p.product_id = s.product_id
-			   WHERE p.product_id = $1
+			   WHERE p.product_id = $1 AND ($2::uuid IS NULL OR p.org_id = $2)
 			   GROUP BY p.product_id`
-	if err := db.GetContext(ctx, &p, q, id); err != nil {
+	if err := db.GetContext(ctx, &p, q, id, user.Tenant()); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -59,22 +99,33 @@ func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 	return &p, nil
 }
 
-// Create makes a new Product.
+// Create makes a new Product in the organization of the user. The Event it is
+// assigned to, if any, has to belong to the same organization.
 func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, np NewProduct, now time.Time) (*Product, error) {
 
 	p := Product{
 		ID:          uuid.New().String(),
 		Name:        np.Name,
+		Category:    np.Category,
 		Cost:        np.Cost,
 		Quantity:    np.Quantity,
 		UserID:      user.Subject,
+		OrgID:       user.OrgID,
+		EventID:     np.EventID,
 		DateCreated: now.UTC(),
 		DateUpdated: now.UTC(),
 	}
+	if err := checkEvent(ctx, db, p.OrgID, p.EventID); err != nil {
+		return nil, err
+	}
+
 	const q = `INSERT INTO products 
-		 (product_id, user_id, name, cost, quantity, date_created, date_updated)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	if _, err := db.ExecContext(ctx, q, p.ID, p.UserID, p.Name, p.Cost, p.Quantity, p.DateCreated, p.DateUpdated); err != nil {
+		 (product_id, user_id, org_id, name, category, cost, quantity, event_id, date_created, date_updated)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	if _, err := db.ExecContext(ctx, q, p.ID, p.UserID, p.OrgID, p.Name, p.Category, p.Cost, p.Quantity, p.EventID, p.DateCreated, p.DateUpdated); err != nil {
+		if isUnknownEvent(err) {
+			return nil, ErrUnknownEvent
+		}
 		return nil, errors.Wrapf(err, "inserting product: %v", np)
 	}
 
@@ -85,56 +136,146 @@ func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, np NewProduct, n
 // invalid or does not reference an existing Product.
 func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, update UpdateProduct, now time.Time) error {
 
-	p, err := Retrieve(ctx, db, id)
+	p, err := Retrieve(ctx, db, user, id)
 	if err != nil {
 		return err
 	}
 
 	log.Printf("product Update > user.Subject='%+v' p.UserID='%v'", user.Subject, p.UserID)
-	// If user is not admin and owner of that product, then action is forbidden.
-	if !user.HasRole(auth.RoleAdmin) && user.Subject != p.UserID {
+	// If user may not manage products and is not the owner of that product,
+	// then action is forbidden.
+	if !user.HasPermission(auth.PermProductManage) && user.Subject != p.UserID {
 		return ErrForbidden
 	}
 
 	if update.Name != nil {
 		p.Name = *update.Name
 	}
+	if update.Category != nil {
+		p.Category = *update.Category
+	}
 	if update.Cost != nil {
 		p.Cost = *update.Cost
 	}
 	if update.Quantity != nil {
 		p.Quantity = *update.Quantity
 	}
+	if update.EventID != nil {
+		switch *update.EventID {
+		case "":
+			p.EventID = nil
+		default:
+			if _, err := uuid.Parse(*update.EventID); err != nil {
+				return ErrInvalidID
+			}
+			p.EventID = update.EventID
+		}
+	}
+	if err := checkEvent(ctx, db, p.OrgID, p.EventID); err != nil {
+		return err
+	}
 	p.DateUpdated = now
 
 	const q = `UPDATE products SET
 		"name" = $2,
-		"cost" = $3,
-		"quantity" = $4,
-		"date_updated" = $5
+		"category" = $3,
+		"cost" = $4,
+		"quantity" = $5,
+		"event_id" = $6,
+		"date_updated" = $7
 		WHERE product_id = $1`
 	_, err = db.ExecContext(ctx, q, id,
-		p.Name, p.Cost,
-		p.Quantity, p.DateUpdated,
+		p.Name, p.Category, p.Cost,
+		p.Quantity, p.EventID, p.DateUpdated,
 	)
 	if err != nil {
+		if isUnknownEvent(err) {
+			return ErrUnknownEvent
+		}
 		return errors.Wrap(err, "updating product")
 	}
 
 	return nil
 }
 
-// Delete removes the product identified by a given ID.
-func Delete(ctx context.Context, db *sqlx.DB, id string) error {
+// Delete removes the product identified by a given ID. Products of other
+// organizations than the one of the user are left alone.
+func Delete(ctx context.Context, db *sqlx.DB, user auth.Claims, id string) error {
 
 	if _, err := uuid.Parse(id); err != nil {
 		return ErrInvalidID
 	}
 
-	const q = `DELETE FROM products WHERE product_id = $1`
-	if _, err := db.ExecContext(ctx, q, id); err != nil {
+	const q = `DELETE FROM products WHERE product_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if _, err := db.ExecContext(ctx, q, id, user.Tenant()); err != nil {
 		return errors.Wrapf(err, "deleting product %s", id)
 	}
 
 	return nil
 }
+
+// ListByEvent returns the Products of the organization of the user assigned
+// to an Event.
+func ListByEvent(ctx context.Context, db *sqlx.DB, user auth.Claims, eventID string) ([]Product, error) {
+
+	list := []Product{}
+
+	const q = `SELECT p.*,
+			   COALESCE(SUM(s.quantity), 0) AS sold,
+			   COALESCE(SUM(s.paid), 0) AS revenue
+			   FROM products AS p
+			   LEFT JOIN sales AS s ON p.product_id = s.product_id
+			   WHERE p.event_id = $1 AND ($2::uuid IS NULL OR p.org_id = $2)
+			   GROUP BY p.product_id`
+	if err := db.SelectContext(ctx, &list, q, eventID, user.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting event products")
+	}
+	return list, nil
+}
+
+// ListByUser returns the Products the user is selling.
+func ListByUser(ctx context.Context, db *sqlx.DB, user auth.Claims) ([]Product, error) {
+
+	list := []Product{}
+
+	const q = `SELECT p.*,
+			   COALESCE(SUM(s.quantity), 0) AS sold,
+			   COALESCE(SUM(s.paid), 0) AS revenue
+			   FROM products AS p
+			   LEFT JOIN sales AS s ON p.product_id = s.product_id
+			   WHERE p.user_id = $1
+			   GROUP BY p.product_id
+			   ORDER BY p.date_created`
+	if err := db.SelectContext(ctx, &list, q, user.Subject); err != nil {
+		return nil, errors.Wrap(err, "selecting user products")
+	}
+	return list, nil
+}
+
+// checkEvent makes sure the Event a Product is assigned to, if any, belongs to
+// the organization of the Product. Events of other organizations are unknown.
+func checkEvent(ctx context.Context, db *sqlx.DB, orgID string, eventID *string) error {
+
+	if eventID == nil {
+		return nil
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS (SELECT 1 FROM events WHERE event_id = $1 AND org_id = $2)`
+	if err := db.GetContext(ctx, &exists, q, *eventID, orgID); err != nil {
+		return errors.Wrap(err, "looking for product event")
+	}
+	if !exists {
+		return ErrUnknownEvent
+	}
+
+	return nil
+}
+
+// isUnknownEvent tells if err is the violation of the foreign key that links a
+// Product to its Event.
+func isUnknownEvent(err error) bool {
+
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Constraint == "products_event_id_fkey"
+}
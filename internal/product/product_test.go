@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/devisions/garagesale/internal/org"
 	"github.com/devisions/garagesale/internal/platform/auth"
 	"github.com/devisions/garagesale/internal/product"
 	"github.com/devisions/garagesale/internal/schema"
@@ -27,13 +28,14 @@ func TestProducts(t *testing.T) {
 		[]string{auth.RoleAdmin, auth.RoleUser},
 		now, time.Hour,
 	)
+	claims.OrgID = org.Default
 
 	saved, err := product.Create(ctx, db, claims, np, now)
 	if err != nil {
 		t.Fatalf("could not create product: %v", err)
 	}
 
-	fetched, err := product.Retrieve(ctx, db, saved.ID)
+	fetched, err := product.Retrieve(ctx, db, claims, saved.ID)
 	if err != nil {
 		t.Fatalf("could not retrieve product: %v", err)
 	}
@@ -54,7 +56,9 @@ func TestProductList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ps, err := product.List(ctx, db)
+	claims := auth.Claims{OrgID: org.Default}
+
+	ps, err := product.List(ctx, db, claims)
 	if err != nil {
 		t.Fatalf("failed on listing products: %s", err)
 	}
@@ -62,3 +66,119 @@ func TestProductList(t *testing.T) {
 		t.Fatalf("expected product list size %v, got %v", exp, got)
 	}
 }
+
+func TestProductTenancy(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	other, err := org.Create(ctx, db, org.NewOrg{Name: "Other Gophers"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	owner := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, now, time.Hour)
+	owner.OrgID = org.Default
+	stranger := auth.NewClaims("2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37", []string{auth.RoleAdmin}, now, time.Hour)
+	stranger.OrgID = other.ID
+	stranger.Permissions = []string{auth.PermProductDelete, auth.PermProductManage}
+	super := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleSuperAdmin}, now, time.Hour)
+	super.OrgID = other.ID
+	super.Permissions = []string{auth.PermOrgManage}
+
+	comics, err := product.Create(ctx, db, owner, product.NewProduct{Name: "Comic Books", Cost: 10, Quantity: 20}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	if _, err := product.AddSale(ctx, db, owner, product.NewSale{Quantity: 1, Paid: 10}, comics.ID, now); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	if _, err := product.Retrieve(ctx, db, stranger, comics.ID); err != product.ErrNotFound {
+		t.Fatalf("expected ErrNotFound retrieving another organization's product, got %v", err)
+	}
+	list, err := product.List(ctx, db, stranger)
+	if err != nil {
+		t.Fatalf("listing products: %s", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no products of another organization, got %d", len(list))
+	}
+	sales, err := product.ListSales(ctx, db, stranger, comics.ID)
+	if err != nil {
+		t.Fatalf("listing sales: %s", err)
+	}
+	if len(sales) != 0 {
+		t.Fatalf("expected no sales of another organization, got %d", len(sales))
+	}
+	if _, err := product.AddSale(ctx, db, stranger, product.NewSale{Quantity: 1, Paid: 10}, comics.ID, now); err != product.ErrNotFound {
+		t.Fatalf("expected ErrNotFound selling another organization's product, got %v", err)
+	}
+	name := "Stolen"
+	if err := product.Update(ctx, db, stranger, comics.ID, product.UpdateProduct{Name: &name}, now); err != product.ErrNotFound {
+		t.Fatalf("expected ErrNotFound updating another organization's product, got %v", err)
+	}
+	if err := product.Delete(ctx, db, stranger, comics.ID); err != nil {
+		t.Fatalf("deleting product: %s", err)
+	}
+
+	// A super admin reaches every organization.
+	fetched, err := product.Retrieve(ctx, db, super, comics.ID)
+	if err != nil {
+		t.Fatalf("super admin retrieving product: %s", err)
+	}
+	if fetched.Name != comics.Name || fetched.Sold != 1 {
+		t.Fatalf("unexpected product %+v", fetched)
+	}
+}
+
+func TestListActive(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	seller := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, now, time.Hour)
+	seller.OrgID = org.Default
+
+	if _, err := product.Create(ctx, db, seller, product.NewProduct{Name: "Comic Books", Cost: 10, Quantity: 20}, now); err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	const q = `INSERT INTO users (user_id, name, email, roles, password_hash, date_created, date_updated, status, status_until)
+		VALUES ($1, 'Seller', 'seller@example.com', '{USER}', '', $2, $2, 'suspended', $3)`
+	if _, err := db.ExecContext(ctx, q, seller.Subject, now, now.Add(time.Hour)); err != nil {
+		t.Fatalf("suspending seller: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"suspended", now, 0},
+		{"suspension expired", now.Add(time.Hour), 1},
+	}
+	for _, tt := range tests {
+		list, err := product.ListActive(ctx, db, seller, tt.at)
+		if err != nil {
+			t.Fatalf("%s: listing products: %s", tt.name, err)
+		}
+		if len(list) != tt.want {
+			t.Fatalf("%s: expected %d products, got %d", tt.name, tt.want, len(list))
+		}
+	}
+
+	list, err := product.List(ctx, db, seller)
+	if err != nil {
+		t.Fatalf("listing products: %s", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("expected List to keep the products of suspended sellers, got %d", len(list))
+	}
+}
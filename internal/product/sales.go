@@ -2,46 +2,180 @@ package product
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
+	"github.com/devisions/garagesale/internal/event"
+	"github.com/devisions/garagesale/internal/payment"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/tax"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
-// AddSale records a sales transaction for a single Product.
-func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID string, now time.Time) (*Sale, error) {
+// AddSale records a sales transaction for a single Product. The Sale is
+// attributed to the event the Product is assigned to, if that event is active
+// and takes place at the time. The tax rate that applies to the Product's
+// category at that event is used to compute the tax of the Sale. The Product
+// has to belong to the organization of the user, and so do the Sale and its
+// customer. The Sale is recorded along with its payments, or not at all.
+func AddSale(ctx context.Context, db *sqlx.DB, user auth.Claims, ns NewSale, productID string, now time.Time) (*Sale, error) {
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	s, err := addSale(ctx, tx, user, ns, productID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing sale")
+	}
+
+	return s, nil
+}
+
+// addSale is AddSale within a transaction.
+func addSale(ctx context.Context, tx *sqlx.Tx, user auth.Claims, ns NewSale, productID string, now time.Time) (*Sale, error) {
+
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var p struct {
+		Category string  `db:"category"`
+		EventID  *string `db:"event_id"`
+		OrgID    string  `db:"org_id"`
+	}
+	const qp = `SELECT category, event_id, org_id FROM products
+		WHERE product_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if err := tx.GetContext(ctx, &p, qp, productID, user.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting product")
+	}
+
 	s := Sale{
 		ID:          uuid.New().String(),
 		ProductID:   productID,
 		Quantity:    ns.Quantity,
 		Paid:        ns.Paid,
+		CustomerID:  ns.CustomerID,
+		OrgID:       p.OrgID,
 		DateCreated: now,
 	}
 
+	if s.CustomerID != nil {
+		var known bool
+		const qc = `SELECT EXISTS (SELECT 1 FROM customers WHERE customer_id = $1 AND org_id = $2)`
+		if err := tx.GetContext(ctx, &known, qc, *s.CustomerID, s.OrgID); err != nil {
+			return nil, errors.Wrap(err, "looking for customer")
+		}
+		if !known {
+			return nil, ErrUnknownCustomer
+		}
+	}
+
+	ev, err := event.Active(ctx, tx, s.OrgID, p.EventID, now)
+	if err != nil {
+		return nil, err
+	}
+	if ev != nil {
+		s.EventID = &ev.ID
+	}
+
+	rate, err := tax.Applicable(ctx, tx, s.OrgID, p.Category, s.EventID)
+	if err != nil {
+		return nil, err
+	}
+	if rate != nil {
+		s.Tax = rate.Compute(s.Paid)
+		s.TaxInclusive = rate.Inclusive
+		s.TaxRateID = &rate.ID
+	}
+
+	payments := ns.Payments
+	if payments == nil && s.Due() > 0 {
+		payments = []payment.NewPayment{{Method: payment.MethodCash, Amount: s.Due()}}
+	}
+	var total int
+	for _, np := range payments {
+		total += np.Amount
+	}
+	if total > s.Due() {
+		return nil, payment.ErrOverpayment
+	}
+
 	const q = `INSERT INTO sales
-		(sale_id, product_id, quantity, paid, date_created)
-		VALUES ($1, $2, $3, $4, $5)`
+		(sale_id, product_id, quantity, paid, tax, tax_inclusive, tax_rate_id, customer_id, event_id, org_id, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 
-	_, err := db.ExecContext(ctx, q,
+	_, err = tx.ExecContext(ctx, q,
 		s.ID, s.ProductID, s.Quantity,
-		s.Paid, s.DateCreated,
+		s.Paid, s.Tax, s.TaxInclusive, s.TaxRateID,
+		s.CustomerID, s.EventID, s.OrgID, s.DateCreated,
 	)
 	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "sales_customer_id_fkey" {
+			return nil, ErrUnknownCustomer
+		}
 		return nil, errors.Wrap(err, "inserting sale")
 	}
 
+	for _, np := range payments {
+		if _, err := payment.AddTx(ctx, tx, user, s.ID, np, now); err != nil {
+			return nil, errors.Wrap(err, "adding sale payment")
+		}
+	}
+
 	return &s, nil
 }
 
-// ListSales gives all Sales for a Product.
-func ListSales(ctx context.Context, db *sqlx.DB, productID string) ([]Sale, error) {
+// ListSales gives all Sales for a Product of the organization of the user.
+func ListSales(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) ([]Sale, error) {
 	sales := []Sale{}
 
-	const q = `SELECT * FROM sales WHERE product_id = $1`
-	if err := db.SelectContext(ctx, &sales, q, productID); err != nil {
+	const q = `SELECT * FROM sales WHERE product_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if err := db.SelectContext(ctx, &sales, q, productID, user.Tenant()); err != nil {
 		return nil, errors.Wrap(err, "selecting sales")
 	}
 
 	return sales, nil
 }
+
+// ListEventSales gives the Sales of the organization of the user attributed
+// to an Event.
+func ListEventSales(ctx context.Context, db *sqlx.DB, user auth.Claims, eventID string) ([]Sale, error) {
+	sales := []Sale{}
+
+	const q = `SELECT * FROM sales WHERE event_id = $1 AND ($2::uuid IS NULL OR org_id = $2)
+		ORDER BY date_created`
+	if err := db.SelectContext(ctx, &sales, q, eventID, user.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting event sales")
+	}
+
+	return sales, nil
+}
+
+// ListUserSales gives the Sales of the Products the user is selling, latest
+// first.
+func ListUserSales(ctx context.Context, db *sqlx.DB, user auth.Claims) ([]Sale, error) {
+	sales := []Sale{}
+
+	const q = `SELECT s.* FROM sales AS s
+		JOIN products AS p ON p.product_id = s.product_id
+		WHERE p.user_id = $1
+		ORDER BY s.date_created DESC`
+	if err := db.SelectContext(ctx, &sales, q, user.Subject); err != nil {
+		return nil, errors.Wrap(err, "selecting user sales")
+	}
+
+	return sales, nil
+}
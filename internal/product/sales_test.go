@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/devisions/garagesale/internal/org"
 	"github.com/devisions/garagesale/internal/platform/auth"
 	"github.com/devisions/garagesale/internal/product"
 	"github.com/devisions/garagesale/internal/tests"
@@ -25,6 +26,7 @@ func TestSales(t *testing.T) {
 		[]string{auth.RoleAdmin, auth.RoleUser},
 		now, time.Hour,
 	)
+	claims.OrgID = org.Default
 
 	comics, err := product.Create(ctx, db, claims, newComics, now)
 	if err != nil {
@@ -45,13 +47,13 @@ func TestSales(t *testing.T) {
 			Paid:     60,
 		}
 
-		s, err := product.AddSale(ctx, db, ns, comics.ID, now)
+		s, err := product.AddSale(ctx, db, claims, ns, comics.ID, now)
 		if err != nil {
 			t.Fatalf("adding sale: %s", err)
 		}
 
 		// Puzzles should show the 1 sale.
-		sales, err := product.ListSales(ctx, db, comics.ID)
+		sales, err := product.ListSales(ctx, db, claims, comics.ID)
 		if err != nil {
 			t.Fatalf("listing sales: %s", err)
 		}
@@ -64,7 +66,7 @@ func TestSales(t *testing.T) {
 		}
 
 		// Toys should have 0 sales.
-		sales, err = product.ListSales(ctx, db, toys.ID)
+		sales, err = product.ListSales(ctx, db, claims, toys.ID)
 		if err != nil {
 			t.Fatalf("listing sales: %s", err)
 		}
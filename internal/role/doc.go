@@ -0,0 +1,3 @@
+// Package role implements all business logic regarding roles, each granting
+// a set of named permissions to the users having it.
+package role
@@ -0,0 +1,216 @@
+package role
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrNotFound          = errors.New("role not found")
+	ErrExists            = errors.New("role already exists")
+	ErrInUse             = errors.New("role is given to users")
+	ErrBuiltIn           = errors.New("built-in roles cannot be deleted")
+	ErrUnknownPermission = errors.New("unknown permission")
+
+	// ErrForbidden occurs when a user that may not cross tenants attempts to
+	// grant the permission to do so, or to change the roles every tenant
+	// shares.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// List gives all Roles.
+func List(ctx context.Context, db *sqlx.DB) ([]Role, error) {
+
+	roles := []Role{}
+
+	const q = `SELECT * FROM roles ORDER BY name`
+	if err := db.SelectContext(ctx, &roles, q); err != nil {
+		return nil, errors.Wrap(err, "selecting roles")
+	}
+	return roles, nil
+}
+
+// Retrieve gives a single Role.
+func Retrieve(ctx context.Context, db *sqlx.DB, name string) (*Role, error) {
+
+	var r Role
+	const q = `SELECT * FROM roles WHERE name = $1`
+	if err := db.GetContext(ctx, &r, q, name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting role %q", name)
+	}
+	return &r, nil
+}
+
+// Create adds a Role. Roles are shared by every tenant, so only users that
+// may cross tenants may create one.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, nr NewRole, now time.Time) (*Role, error) {
+
+	if err := checkShared(user); err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(nr.Permissions); err != nil {
+		return nil, err
+	}
+
+	r := Role{
+		Name:        nr.Name,
+		Description: nr.Description,
+		Permissions: nr.Permissions,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO roles (name, description, permissions, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err := db.ExecContext(ctx, q, r.Name, r.Description, r.Permissions, r.DateCreated, r.DateUpdated)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "roles_pkey" {
+			return nil, ErrExists
+		}
+		return nil, errors.Wrap(err, "inserting role")
+	}
+
+	return &r, nil
+}
+
+// Update modifies a Role. The users having it get the new permissions with
+// their next token. Roles are shared by every tenant, so only users that may
+// cross tenants may modify one.
+func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, name string, ur UpdateRole, now time.Time) error {
+
+	if err := checkShared(user); err != nil {
+		return err
+	}
+	r, err := Retrieve(ctx, db, name)
+	if err != nil {
+		return err
+	}
+
+	if ur.Description != nil {
+		r.Description = *ur.Description
+	}
+	if ur.Permissions != nil {
+		if err := checkPermissions(ur.Permissions); err != nil {
+			return err
+		}
+		r.Permissions = ur.Permissions
+	}
+	r.DateUpdated = now.UTC()
+
+	const q = `UPDATE roles SET description = $2, permissions = $3, date_updated = $4 WHERE name = $1`
+	if _, err := db.ExecContext(ctx, q, name, r.Description, r.Permissions, r.DateUpdated); err != nil {
+		return errors.Wrapf(err, "updating role %q", name)
+	}
+	return nil
+}
+
+// Delete removes a Role that no user has. The built-in roles stay. Roles are
+// shared by every tenant, so only users that may cross tenants may delete one.
+func Delete(ctx context.Context, db *sqlx.DB, user auth.Claims, name string) error {
+
+	if err := checkShared(user); err != nil {
+		return err
+	}
+	if name == auth.RoleSuperAdmin || name == auth.RoleAdmin || name == auth.RoleUser {
+		return ErrBuiltIn
+	}
+
+	var inUse bool
+	const q = `SELECT EXISTS (SELECT 1 FROM users WHERE $1 = ANY(roles))`
+	if err := db.GetContext(ctx, &inUse, q, name); err != nil {
+		return errors.Wrapf(err, "checking use of role %q", name)
+	}
+	if inUse {
+		return ErrInUse
+	}
+
+	const qd = `DELETE FROM roles WHERE name = $1`
+	if _, err := db.ExecContext(ctx, qd, name); err != nil {
+		return errors.Wrapf(err, "deleting role %q", name)
+	}
+	return nil
+}
+
+// Resolve gives the permissions granted by a set of roles. Unknown roles
+// grant nothing.
+func Resolve(ctx context.Context, db *sqlx.DB, roles []string) ([]string, error) {
+
+	permissions := []string{}
+
+	const q = `SELECT DISTINCT unnest(permissions) AS permission FROM roles
+		WHERE name = ANY($1) ORDER BY permission`
+	if err := db.SelectContext(ctx, &permissions, q, pq.StringArray(roles)); err != nil {
+		return nil, errors.Wrap(err, "resolving permissions")
+	}
+	return permissions, nil
+}
+
+// Unprivileged gives the roles of a set that grant none of the admin
+// permissions. Unknown roles are left out.
+func Unprivileged(ctx context.Context, db *sqlx.DB, roles []string) ([]string, error) {
+
+	names := []string{}
+
+	const q = `SELECT name FROM roles
+		WHERE name = ANY($1) AND NOT permissions && $2 ORDER BY name`
+	if err := db.SelectContext(ctx, &names, q, pq.StringArray(roles), pq.StringArray(auth.AdminPermissions)); err != nil {
+		return nil, errors.Wrap(err, "selecting unprivileged roles")
+	}
+	return names, nil
+}
+
+// Grantable ensures the user may give the roles to someone. Only users that
+// may cross tenants may give roles that do too.
+func Grantable(ctx context.Context, db *sqlx.DB, user auth.Claims, roles []string) error {
+
+	permissions, err := Resolve(ctx, db, roles)
+	if err != nil {
+		return err
+	}
+	return checkGrant(user, permissions)
+}
+
+// checkPermissions ensures all the permissions are known ones.
+func checkPermissions(permissions []string) error {
+
+next:
+	for _, p := range permissions {
+		for _, known := range auth.Permissions {
+			if p == known {
+				continue next
+			}
+		}
+		return ErrUnknownPermission
+	}
+	return nil
+}
+
+// checkShared ensures the user may change what every tenant shares.
+func checkShared(user auth.Claims) error {
+
+	if !user.HasPermission(auth.PermOrgManage) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// checkGrant ensures the user may cross tenants if the permissions allow to.
+func checkGrant(user auth.Claims, permissions []string) error {
+
+	for _, p := range permissions {
+		if p == auth.PermOrgManage && !user.HasPermission(auth.PermOrgManage) {
+			return ErrForbidden
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package role_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/role"
+	"github.com/devisions/garagesale/internal/tests"
+	"github.com/devisions/garagesale/internal/user"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRole(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	admin := auth.Claims{Permissions: []string{auth.PermRoleManage, auth.PermOrgManage}}
+	tenantAdmin := auth.Claims{Permissions: []string{auth.PermRoleManage}}
+
+	nr := role.NewRole{
+		Name:        "CASHIER",
+		Permissions: []string{auth.PermSaleCreate, auth.PermPaymentCreate},
+	}
+	if _, err := role.Create(ctx, db, admin, role.NewRole{Name: "BAD", Permissions: []string{"everything"}}, now); err != role.ErrUnknownPermission {
+		t.Fatalf("expected ErrUnknownPermission, got %v", err)
+	}
+	if _, err := role.Create(ctx, db, admin, nr, now); err != nil {
+		t.Fatalf("creating role: %s", err)
+	}
+	if _, err := role.Create(ctx, db, admin, nr, now); err != role.ErrExists {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+
+	// Roles are shared by every tenant, so the admin of one cannot change
+	// them.
+	if _, err := role.Create(ctx, db, tenantAdmin, role.NewRole{Name: "CLERK"}, now); err != role.ErrForbidden {
+		t.Fatalf("expected ErrForbidden creating a role as tenant admin, got %v", err)
+	}
+	if err := role.Update(ctx, db, tenantAdmin, auth.RoleUser, role.UpdateRole{Permissions: []string{auth.PermUserWrite}}, now); err != role.ErrForbidden {
+		t.Fatalf("expected ErrForbidden changing a shared role as tenant admin, got %v", err)
+	}
+	if err := role.Delete(ctx, db, tenantAdmin, "CASHIER"); err != role.ErrForbidden {
+		t.Fatalf("expected ErrForbidden deleting a role as tenant admin, got %v", err)
+	}
+	if err := role.Grantable(ctx, db, tenantAdmin, []string{auth.RoleSuperAdmin}); err != role.ErrForbidden {
+		t.Fatalf("expected ErrForbidden granting the super admin role as tenant admin, got %v", err)
+	}
+
+	got, err := role.Resolve(ctx, db, []string{"CASHIER", auth.RoleUser, "UNKNOWN"})
+	if err != nil {
+		t.Fatalf("resolving permissions: %s", err)
+	}
+	if diff := cmp.Diff([]string{auth.PermPaymentCreate, auth.PermSaleCreate}, got); diff != "" {
+		t.Fatalf("unexpected permissions:\n%s", diff)
+	}
+
+	upd := role.UpdateRole{Permissions: []string{auth.PermSaleCreate}}
+	if err := role.Update(ctx, db, admin, "CASHIER", upd, now); err != nil {
+		t.Fatalf("updating role: %s", err)
+	}
+
+	nu := user.NewUser{
+		Name:            "Cashier",
+		Email:           "cashier@example.com",
+		Roles:           []string{"CASHIER"},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	u, err := user.Create(ctx, db, user.Policy{}, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	claims, err := user.Authenticate(ctx, db, user.Policy{}, now, "cashier@example.com", "gophers")
+	if err != nil {
+		t.Fatalf("authenticating: %s", err)
+	}
+	if !claims.HasPermission(auth.PermSaleCreate) || claims.HasPermission(auth.PermPaymentCreate) {
+		t.Fatalf("unexpected permissions in claims: %v", claims.Permissions)
+	}
+
+	if err := role.Delete(ctx, db, admin, "CASHIER"); err != role.ErrInUse {
+		t.Fatalf("expected ErrInUse, got %v", err)
+	}
+	if err := user.Delete(ctx, db, claims, u.ID); err != nil {
+		t.Fatalf("deleting user: %s", err)
+	}
+	if err := role.Delete(ctx, db, admin, "CASHIER"); err != nil {
+		t.Fatalf("deleting role: %s", err)
+	}
+	if err := role.Delete(ctx, db, admin, auth.RoleAdmin); err != role.ErrBuiltIn {
+		t.Fatalf("expected ErrBuiltIn, got %v", err)
+	}
+}
@@ -67,6 +67,411 @@ ALTER TABLE products
 	ADD COLUMN user_id UUID DEFAULT '00000000-0000-0000-0000-000000000000'
 `,
 	},
+	{
+		Version:     5,
+		Description: "Add customers",
+		Script: `
+CREATE TABLE customers (
+	customer_id  UUID,
+	name         TEXT,
+	contact      TEXT,
+	notes        TEXT,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (customer_id)
+);`,
+	},
+	{
+		Version:     6,
+		Description: "Add customer column to sales",
+		Script: `
+ALTER TABLE sales
+	ADD COLUMN customer_id UUID REFERENCES customers(customer_id) ON DELETE SET NULL
+`,
+	},
+	{
+		Version:     7,
+		Description: "Add tax rates",
+		Script: `
+CREATE TABLE tax_rates (
+	tax_rate_id  UUID,
+	name         TEXT,
+	rate         INT,
+	inclusive    BOOLEAN,
+	category     TEXT,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (tax_rate_id)
+);`,
+	},
+	{
+		Version:     8,
+		Description: "Add category column to products",
+		Script: `
+ALTER TABLE products
+	ADD COLUMN category TEXT DEFAULT ''
+`,
+	},
+	{
+		Version:     9,
+		Description: "Add tax columns to sales",
+		Script: `
+ALTER TABLE sales
+	ADD COLUMN tax INT DEFAULT 0,
+	ADD COLUMN tax_rate_id UUID REFERENCES tax_rates(tax_rate_id) ON DELETE SET NULL
+`,
+	},
+	{
+		Version:     10,
+		Description: "Add offers",
+		Script: `
+CREATE TABLE offers (
+	offer_id     UUID,
+	product_id   UUID,
+	buyer_id     UUID,
+	quantity     INT,
+	amount       INT,
+	status       TEXT,
+	sale_id      UUID,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (offer_id),
+	FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE,
+	FOREIGN KEY (sale_id) REFERENCES sales(sale_id) ON DELETE SET NULL
+);`,
+	},
+	{
+		Version:     11,
+		Description: "Add events",
+		Script: `
+CREATE TABLE events (
+	event_id     UUID,
+	name         TEXT,
+	location     TEXT,
+	starts_at    TIMESTAMP,
+	ends_at      TIMESTAMP,
+	status       TEXT,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (event_id)
+);`,
+	},
+	{
+		Version:     12,
+		Description: "Add event columns to products, sales and tax rates",
+		Script: `
+ALTER TABLE products
+	ADD COLUMN event_id UUID REFERENCES events(event_id) ON DELETE SET NULL;
+ALTER TABLE sales
+	ADD COLUMN event_id UUID REFERENCES events(event_id) ON DELETE SET NULL;
+ALTER TABLE tax_rates
+	ADD COLUMN event_id UUID REFERENCES events(event_id) ON DELETE CASCADE;
+`,
+	},
+	{
+		Version:     13,
+		Description: "Add tax_inclusive column to sales",
+		Script: `
+ALTER TABLE sales
+	ADD COLUMN tax_inclusive BOOLEAN DEFAULT false;
+
+-- Sales recorded so far were taxed the way their tax rate says.
+UPDATE sales AS s SET tax_inclusive = r.inclusive
+	FROM tax_rates AS r
+	WHERE r.tax_rate_id = s.tax_rate_id;
+`,
+	},
+	{
+		Version:     14,
+		Description: "Add payments",
+		Script: `
+CREATE TABLE payments (
+	payment_id   UUID,
+	sale_id      UUID,
+	method       TEXT,
+	amount       INT,
+	reference    TEXT,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (payment_id),
+	FOREIGN KEY (sale_id) REFERENCES sales(sale_id) ON DELETE CASCADE
+);
+
+-- Sales recorded so far were paid in full at the time of the sale, the tax
+-- being part of the price when inclusive.
+INSERT INTO payments (payment_id, sale_id, method, amount, reference, date_created)
+	SELECT md5(sale_id::TEXT)::UUID, sale_id, 'cash', total, '', date_created
+	FROM (SELECT *, CASE WHEN tax_inclusive THEN paid ELSE paid + tax END AS total FROM sales) AS s
+	WHERE total > 0;
+`,
+	},
+	{
+		Version:     15,
+		Description: "Add drawer sessions",
+		Script: `
+CREATE TABLE drawer_sessions (
+	session_id  UUID,
+	float       INT,
+	opened_by   UUID,
+	opened_at   TIMESTAMP,
+	closed_by   UUID,
+	closed_at   TIMESTAMP,
+	expected    INT,
+	counted     INT,
+	discrepancy INT,
+	notes       TEXT,
+
+	PRIMARY KEY (session_id)
+);`,
+	},
+	{
+		Version:     16,
+		Description: "Add verified column to users",
+		Script: `
+ALTER TABLE users
+	ADD COLUMN verified BOOLEAN DEFAULT true
+`,
+	},
+	{
+		Version:     17,
+		Description: "Add password resets",
+		Script: `
+CREATE TABLE password_resets (
+	token_hash   TEXT,
+	user_id      UUID,
+	expires_at   TIMESTAMP,
+	used_at      TIMESTAMP,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`,
+	},
+	{
+		Version:     18,
+		Description: "Add password history",
+		Script: `
+CREATE TABLE password_history (
+	user_id       UUID,
+	password_hash TEXT,
+	date_created  TIMESTAMP,
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+INSERT INTO password_history (user_id, password_hash, date_created)
+	SELECT user_id, password_hash, date_updated FROM users;`,
+	},
+	{
+		Version:     19,
+		Description: "Add login failures",
+		Script: `
+CREATE TABLE login_failures (
+	kind         TEXT,
+	subject      TEXT,
+	failures     INT,
+	last_failure TIMESTAMP,
+	locked_until TIMESTAMP,
+
+	PRIMARY KEY (kind, subject)
+);`,
+	},
+	{
+		Version:     20,
+		Description: "Add refresh tokens and revoked tokens",
+		Script: `
+CREATE TABLE refresh_tokens (
+	token_hash        TEXT,
+	family_id         UUID,
+	user_id           UUID,
+	access_jti        TEXT,
+	access_expires_at TIMESTAMP,
+	expires_at        TIMESTAMP,
+	used_at           TIMESTAMP,
+	revoked_at        TIMESTAMP,
+	date_created      TIMESTAMP,
+
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE revoked_tokens (
+	jti        TEXT,
+	expires_at TIMESTAMP,
+
+	PRIMARY KEY (jti)
+);`,
+	},
+	{
+		Version:     21,
+		Description: "Add two factor authentication",
+		Script: `
+CREATE TABLE user_totp (
+	user_id      UUID,
+	secret       TEXT,
+	enabled      BOOLEAN,
+	last_step    BIGINT,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (user_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes (
+	user_id   UUID,
+	code_hash TEXT,
+	used_at   TIMESTAMP,
+
+	PRIMARY KEY (user_id, code_hash),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`,
+	},
+	{
+		Version:     22,
+		Description: "Add api keys",
+		Script: `
+CREATE TABLE api_keys (
+	key_id       UUID,
+	user_id      UUID,
+	name         TEXT,
+	prefix       TEXT,
+	key_hash     TEXT UNIQUE,
+	roles        TEXT[],
+	date_created TIMESTAMP,
+	last_used    TIMESTAMP,
+	revoked_at   TIMESTAMP,
+
+	PRIMARY KEY (key_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`,
+	},
+	{
+		Version:     23,
+		Description: "Add roles",
+		Script: `
+CREATE TABLE roles (
+	name         TEXT,
+	description  TEXT,
+	permissions  TEXT[],
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (name)
+);
+
+INSERT INTO roles (name, description, permissions, date_created, date_updated) VALUES
+	('ADMIN', 'Runs the garage sale', '{user:read,user:write,product:delete,product:manage,sale:create,payment:create,customer:delete,tax:write,event:write,report:read,drawer:manage,lockout:manage}', now(), now()),
+	('USER', 'Sells and buys products', '{}', now(), now());`,
+	},
+	{
+		Version:     24,
+		Description: "Add organizations",
+		Script: `
+CREATE TABLE organizations (
+	org_id       UUID,
+	name         TEXT UNIQUE,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (org_id)
+);
+
+INSERT INTO organizations (org_id, name, date_created, date_updated) VALUES
+	('8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01', 'Default', now(), now());
+
+ALTER TABLE users ADD COLUMN org_id UUID NOT NULL DEFAULT '8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01' REFERENCES organizations(org_id);
+ALTER TABLE products ADD COLUMN org_id UUID NOT NULL DEFAULT '8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01' REFERENCES organizations(org_id);
+ALTER TABLE sales ADD COLUMN org_id UUID NOT NULL DEFAULT '8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01' REFERENCES organizations(org_id);
+ALTER TABLE customers ADD COLUMN org_id UUID NOT NULL DEFAULT '8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01' REFERENCES organizations(org_id);
+ALTER TABLE events ADD COLUMN org_id UUID NOT NULL DEFAULT '8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01' REFERENCES organizations(org_id);
+ALTER TABLE tax_rates ADD COLUMN org_id UUID NOT NULL DEFAULT '8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01' REFERENCES organizations(org_id);
+ALTER TABLE drawer_sessions ADD COLUMN org_id UUID NOT NULL DEFAULT '8d1e2f5a-6c1b-4a0e-9f2d-3b7c4e5a6f01' REFERENCES organizations(org_id);
+
+-- Tax rate names are unique within an organization.
+ALTER TABLE tax_rates ADD CONSTRAINT tax_rates_name_key UNIQUE (org_id, name);
+
+-- Every organization has a cash drawer of its own, and only one session of
+-- it can be open at a time.
+CREATE UNIQUE INDEX drawer_sessions_single_open ON drawer_sessions (org_id) WHERE closed_at IS NULL;
+
+INSERT INTO roles (name, description, permissions, date_created, date_updated) VALUES
+	('SUPERADMIN', 'Runs the deployment across organizations', '{user:read,user:write,role:manage,product:delete,product:manage,sale:create,payment:create,customer:delete,tax:write,event:write,report:read,drawer:manage,lockout:manage,org:manage}', now(), now());`,
+	},
+	{
+		Version:     25,
+		Description: "Add pending email column to users",
+		Script: `
+ALTER TABLE users
+	ADD COLUMN pending_email TEXT`,
+	},
+	{
+		Version:     26,
+		Description: "Add login events and last seen times",
+		Script: `
+CREATE TABLE login_events (
+	event_id     UUID,
+	user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+	email        TEXT,
+	success      BOOLEAN,
+	reason       TEXT,
+	ip           TEXT,
+	user_agent   TEXT,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (event_id)
+);
+
+CREATE TABLE last_seen (
+	user_id   UUID REFERENCES users(user_id) ON DELETE CASCADE,
+	ip        TEXT,
+	date_seen TIMESTAMP,
+
+	PRIMARY KEY (user_id)
+);`,
+	},
+	{
+		Version:     27,
+		Description: "Add user identities",
+		Script: `
+CREATE TABLE user_identities (
+	issuer       TEXT,
+	subject      TEXT,
+	user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (issuer, subject)
+);`,
+	},
+	{
+		Version:     28,
+		Description: "Add impersonations",
+		Script: `
+CREATE TABLE impersonations (
+	token_id     UUID,
+	actor_id     UUID REFERENCES users(user_id) ON DELETE CASCADE,
+	user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+	date_created TIMESTAMP,
+	expires_at   TIMESTAMP,
+
+	PRIMARY KEY (token_id)
+);
+
+UPDATE roles SET permissions = array_append(permissions, 'user:impersonate')
+	WHERE name IN ('ADMIN', 'SUPERADMIN');`,
+	},
+	{
+		Version:     29,
+		Description: "Add status columns to users",
+		Script: `
+ALTER TABLE users
+	ADD COLUMN status TEXT NOT NULL DEFAULT 'active',
+	ADD COLUMN status_reason TEXT,
+	ADD COLUMN status_until TIMESTAMP`,
+	},
 }
 
 // Migrate attempts to bring the schema for db up to date with the migrations
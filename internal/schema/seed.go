@@ -29,11 +29,22 @@ INSERT INTO sales (sale_id, product_id, quantity, paid, date_created) VALUES
 	('a235be9e-ab5d-44e6-a987-fa1c749264c7', '72f8b983-3eb4-48db-9ed0-e45cc6bd716b', 3, 225, '2019-01-01 00:00:05.000001+00')
 	ON CONFLICT DO NOTHING;
 
--- Create admin and regular User with password "gophers"
+INSERT INTO payments (payment_id, sale_id, method, amount, reference, date_created) VALUES
+	('2f2c5a53-6d0f-4bf4-8d7b-f6a3c8ae1b10', '98b6d4b8-f04b-4c79-8c2e-a0aef46854b7', 'cash', 100, '', '2019-01-01 00:00:03.000001+00'),
+	('c4a0b1c2-3d25-4a18-9d0e-5b7a3e0f9c21', '85f6fb09-eb05-4874-ae39-82d1a30fe0d7', 'card', 250, '', '2019-01-01 00:00:04.000001+00'),
+	('9e1d7f40-8b3c-4e62-a5f1-0c2d4b6e8a32', 'a235be9e-ab5d-44e6-a987-fa1c749264c7', 'cash', 225, '', '2019-01-01 00:00:05.000001+00')
+	ON CONFLICT DO NOTHING;
+
+-- Create super admin, admin and regular User with password "gophers"
 INSERT INTO users (user_id, name, email, roles, password_hash, date_created, date_updated) VALUES
+	('0b6c3f2e-7d4a-4e59-b1c8-2f9a6d3e4b70', 'Super Gopher', 'superadmin@example.com', '{SUPERADMIN}', '$2a$10$1ggfMVZV6Js0ybvJufLRUOWHS5f6KneuP0XwwHpJ8L8ipdry9f2/a', '2019-03-24 00:00:00', '2019-03-24 00:00:00'),
 	('5cf37266-3473-4006-984f-9325122678b7', 'Admin Gopher', 'admin@example.com', '{ADMIN,USER}', '$2a$10$1ggfMVZV6Js0ybvJufLRUOWHS5f6KneuP0XwwHpJ8L8ipdry9f2/a', '2019-03-24 00:00:00', '2019-03-24 00:00:00'),
 	('45b5fbd3-755f-4379-8f07-a58d4a30fa2f', 'User Gopher', 'user@example.com', '{USER}', '$2a$10$9/XASPKBbJKVfCAZKDH.UuhsuALDr5vVm6VrYA9VFR8rccK86C1hW', '2019-03-24 00:00:00', '2019-03-24 00:00:00')
 	ON CONFLICT DO NOTHING;
+
+INSERT INTO password_history (user_id, password_hash, date_created)
+	SELECT u.user_id, u.password_hash, u.date_updated FROM users u
+	WHERE NOT EXISTS (SELECT 1 FROM password_history h WHERE h.user_id = u.user_id);
 `
 
 // Seed runs the set of seed-data queries against db. The queries are ran in a
@@ -0,0 +1,4 @@
+// Package session implements all business logic regarding the sessions of
+// authenticated users: the rotating refresh tokens used to get new access
+// tokens and the revocation of access tokens before they expire.
+package session
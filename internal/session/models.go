@@ -0,0 +1,24 @@
+package session
+
+import "time"
+
+// RefreshToken is the stored form of a refresh token, of which only a hash is
+// kept. Every refresh token is used once, being replaced by a new one of the
+// same family. The access token issued along is tracked by its id (jti), so it
+// can be revoked with the family.
+type RefreshToken struct {
+	TokenHash       string     `db:"token_hash"`
+	FamilyID        string     `db:"family_id"`
+	UserID          string     `db:"user_id"`
+	AccessJTI       string     `db:"access_jti"`
+	AccessExpiresAt time.Time  `db:"access_expires_at"`
+	ExpiresAt       time.Time  `db:"expires_at"`
+	UsedAt          *time.Time `db:"used_at"`
+	RevokedAt       *time.Time `db:"revoked_at"`
+	DateCreated     time.Time  `db:"date_created"`
+}
+
+// Refresh is what we require for getting a new access token.
+type Refresh struct {
+	RefreshToken string `json:"refresh_token"  validate:"required"`
+}
@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	// ErrInvalidToken occurs when a refresh token is unknown, expired, revoked
+	// or was already used.
+	ErrInvalidToken = errors.New("refresh token is invalid")
+)
+
+// Issue creates a refresh token for the user the claims of an access token
+// were issued to, valid for the given duration. An empty family starts a new
+// one, like when logging in.
+func Issue(ctx context.Context, db *sqlx.DB, claims auth.Claims, familyID string, ttl time.Duration, now time.Time) (string, error) {
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", errors.Wrap(err, "generating refresh token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+
+	rt := RefreshToken{
+		TokenHash:       hashToken(token),
+		FamilyID:        familyID,
+		UserID:          claims.Subject,
+		AccessJTI:       claims.Id,
+		AccessExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		ExpiresAt:       now.Add(ttl).UTC(),
+		DateCreated:     now.UTC(),
+	}
+
+	const q = `INSERT INTO refresh_tokens
+		(token_hash, family_id, user_id, access_jti, access_expires_at, expires_at, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.ExecContext(ctx, q,
+		rt.TokenHash, rt.FamilyID, rt.UserID,
+		rt.AccessJTI, rt.AccessExpiresAt,
+		rt.ExpiresAt, rt.DateCreated,
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "inserting refresh token")
+	}
+
+	return token, nil
+}
+
+// Use consumes a refresh token so that a new one of the same family can be
+// issued. Using a token a second time means it leaked: the whole family is
+// then revoked, logging out both the legitimate user and whoever has it.
+func Use(ctx context.Context, db *sqlx.DB, token string, now time.Time) (*RefreshToken, error) {
+
+	var rt RefreshToken
+	const q = `SELECT * FROM refresh_tokens WHERE token_hash = $1`
+	if err := db.GetContext(ctx, &rt, q, hashToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidToken
+		}
+		return nil, errors.Wrap(err, "selecting refresh token")
+	}
+
+	if rt.UsedAt != nil {
+		if err := revokeFamilies(ctx, db, `family_id = $1`, rt.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidToken
+	}
+	if rt.RevokedAt != nil || !rt.ExpiresAt.After(now) {
+		return nil, ErrInvalidToken
+	}
+
+	// Another request could use the same token meanwhile, so only one of
+	// them gets to consume it.
+	const qu = `UPDATE refresh_tokens SET used_at = $2 WHERE token_hash = $1 AND used_at IS NULL`
+	res, err := db.ExecContext(ctx, qu, rt.TokenHash, now.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "consuming refresh token")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "consuming refresh token")
+	}
+	if n == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return &rt, nil
+}
+
+// Logout ends the session the access token with the given claims belongs
+// to. The access token and the refresh tokens issued along are revoked.
+func Logout(ctx context.Context, db *sqlx.DB, claims auth.Claims, now time.Time) error {
+
+	if err := Revoke(ctx, db, claims.Id, time.Unix(claims.ExpiresAt, 0), now); err != nil {
+		return err
+	}
+
+	const q = `family_id IN (SELECT family_id FROM refresh_tokens WHERE access_jti = $1)`
+	return revokeFamilies(ctx, db, q, claims.Id, now)
+}
+
+// RevokeUser ends all the sessions of a user, revoking their refresh tokens
+// and the access tokens issued along.
+func RevokeUser(ctx context.Context, db *sqlx.DB, userID string, now time.Time) error {
+	return revokeFamilies(ctx, db, `user_id = $1`, userID, now)
+}
+
+// Revoke adds the id of an access token to the revocation list, until the
+// token expires. Expired entries are removed meanwhile.
+func Revoke(ctx context.Context, db *sqlx.DB, jti string, expires, now time.Time) error {
+
+	if jti == "" {
+		return nil
+	}
+
+	const q = `INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	if _, err := db.ExecContext(ctx, q, jti, expires.UTC()); err != nil {
+		return errors.Wrap(err, "revoking token")
+	}
+
+	const qd = `DELETE FROM revoked_tokens WHERE expires_at < $1`
+	if _, err := db.ExecContext(ctx, qd, now.UTC()); err != nil {
+		return errors.Wrap(err, "deleting expired revoked tokens")
+	}
+
+	return nil
+}
+
+// Revoked tells whether the access token with the given id was revoked.
+func Revoked(ctx context.Context, db *sqlx.DB, jti string) (bool, error) {
+
+	var revoked bool
+	const q = `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+	if err := db.GetContext(ctx, &revoked, q, jti); err != nil {
+		return false, errors.Wrap(err, "checking revoked token")
+	}
+	return revoked, nil
+}
+
+// revokeFamilies revokes the refresh tokens of the families matching the
+// condition, along with the access tokens issued with them that did not
+// expire yet.
+func revokeFamilies(ctx context.Context, db *sqlx.DB, cond string, arg interface{}, now time.Time) error {
+
+	var tokens []RefreshToken
+	q := `UPDATE refresh_tokens SET revoked_at = $2 WHERE revoked_at IS NULL AND ` + cond + ` RETURNING *`
+	if err := db.SelectContext(ctx, &tokens, q, arg, now.UTC()); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+
+	for _, rt := range tokens {
+		if rt.AccessExpiresAt.After(now) {
+			if err := Revoke(ctx, db, rt.AccessJTI, rt.AccessExpiresAt, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hashToken gives the hex encoded SHA-256 of a token.
+func hashToken(token string) string {
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
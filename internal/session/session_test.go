@@ -0,0 +1,95 @@
+package session_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/session"
+	"github.com/devisions/garagesale/internal/tests"
+	"github.com/devisions/garagesale/internal/user"
+)
+
+func TestSession(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	nu := user.NewUser{
+		Name:            "Gopher",
+		Email:           "gopher@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	u, err := user.Create(ctx, db, user.Policy{}, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+	userID := u.ID
+
+	claims := auth.NewClaims(userID, []string{auth.RoleUser}, now, time.Hour)
+	first, err := session.Issue(ctx, db, claims, "", 24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("issuing refresh token: %s", err)
+	}
+
+	rt, err := session.Use(ctx, db, first, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("using refresh token: %s", err)
+	}
+	if rt.UserID != userID {
+		t.Fatalf("expected the token of user %s, got %s", userID, rt.UserID)
+	}
+
+	next := auth.NewClaims(userID, []string{auth.RoleUser}, now.Add(time.Minute), time.Hour)
+	second, err := session.Issue(ctx, db, next, rt.FamilyID, 24*time.Hour, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("issuing refresh token: %s", err)
+	}
+
+	// Using the first token again revokes the family, including the
+	// access token issued with the second one.
+	if _, err := session.Use(ctx, db, first, now.Add(2*time.Minute)); err != session.ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+	if _, err := session.Use(ctx, db, second, now.Add(2*time.Minute)); err != session.ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+	revoked, err := session.Revoked(ctx, db, next.Id)
+	if err != nil {
+		t.Fatalf("checking revocation: %s", err)
+	}
+	if !revoked {
+		t.Fatal("expected the access token to be revoked")
+	}
+
+	// Expired refresh tokens cannot be used.
+	third, err := session.Issue(ctx, db, claims, "", time.Hour, now)
+	if err != nil {
+		t.Fatalf("issuing refresh token: %s", err)
+	}
+	if _, err := session.Use(ctx, db, third, now.Add(2*time.Hour)); err != session.ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	// Logging out revokes the access token and its refresh token.
+	fourthClaims := auth.NewClaims(userID, []string{auth.RoleUser}, now, time.Hour)
+	fourth, err := session.Issue(ctx, db, fourthClaims, "", time.Hour, now)
+	if err != nil {
+		t.Fatalf("issuing refresh token: %s", err)
+	}
+	if err := session.Logout(ctx, db, fourthClaims, now.Add(time.Minute)); err != nil {
+		t.Fatalf("logging out: %s", err)
+	}
+	if revoked, err := session.Revoked(ctx, db, fourthClaims.Id); err != nil || !revoked {
+		t.Fatalf("expected the access token to be revoked, got %v, %v", revoked, err)
+	}
+	if _, err := session.Use(ctx, db, fourth, now.Add(time.Minute)); err != session.ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+}
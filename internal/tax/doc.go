@@ -0,0 +1,3 @@
+// Package tax implements all business logic regarding sales tax: the rates
+// admins configure and the amounts collected on each sale.
+package tax
@@ -0,0 +1,53 @@
+package tax
+
+import "time"
+
+// Rate is a named sales tax rate. Rates are expressed in basis points, so a
+// Rate of 825 means 8.25%.
+//
+// A Rate with an empty Category applies to every product that has no more
+// specific Rate for its category. A Rate with an EventID only applies to the
+// sales of that event, and wins over the Rates that apply to every event.
+// Inclusive rates are already part of the price paid, exclusive rates are
+// charged on top of it.
+type Rate struct {
+	ID          string    `db:"tax_rate_id"   json:"id"`
+	OrgID       string    `db:"org_id"        json:"org_id"`
+	Name        string    `db:"name"          json:"name"`
+	Rate        int       `db:"rate"          json:"rate"`
+	Inclusive   bool      `db:"inclusive"     json:"inclusive"`
+	Category    string    `db:"category"      json:"category"`
+	EventID     *string   `db:"event_id"      json:"event_id"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+	DateUpdated time.Time `db:"date_updated"  json:"date_updated"`
+}
+
+// NewRate is the input request for creating a new Rate.
+type NewRate struct {
+	Name      string  `json:"name"       validate:"required"`
+	Rate      int     `json:"rate"       validate:"gte=0,lte=10000"`
+	Inclusive bool    `json:"inclusive"`
+	Category  string  `json:"category"`
+	EventID   *string `json:"event_id"   validate:"omitempty,uuid"`
+}
+
+// UpdateRate defines what information may be provided to modify an existing
+// Rate. All fields are optional so clients can send just the fields they want
+// changed. An explicitly blank EventID makes the Rate apply to every event.
+type UpdateRate struct {
+	Name      *string `json:"name"       validate:"omitempty,min=1"`
+	Rate      *int    `json:"rate"       validate:"omitempty,gte=0,lte=10000"`
+	Inclusive *bool   `json:"inclusive"`
+	Category  *string `json:"category"`
+	EventID   *string `json:"event_id"`
+}
+
+// PeriodTotal summarizes the tax collected during one report period. Period
+// is the start of the period, Taxed is the sum of Paid of the sales that were
+// charged some tax, and Tax is the tax collected on them.
+type PeriodTotal struct {
+	Period time.Time `db:"period"  json:"period"`
+	Sales  int       `db:"sales"   json:"sales"`
+	Taxed  int       `db:"taxed"   json:"taxed"`
+	Tax    int       `db:"tax"     json:"tax"`
+}
@@ -0,0 +1,248 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for know failure scenarios.
+var (
+	ErrNotFound      = errors.New("tax rate not found")
+	ErrInvalidID     = errors.New("provided id is not a valid UUID")
+	ErrInvalidPeriod = errors.New("report period must be one of day, week, month or year")
+	ErrUnknownEvent  = errors.New("tax rate references an unknown event")
+)
+
+// Compute gives the tax portion of amount according to this Rate. For
+// inclusive rates the tax is the part of amount that is tax, for exclusive
+// rates it is what has to be charged on top of amount. Results are rounded
+// half up to the nearest unit.
+func (r Rate) Compute(amount int) int {
+
+	if r.Inclusive {
+		net := (amount*10000 + (10000+r.Rate)/2) / (10000 + r.Rate)
+		return amount - net
+	}
+	return (amount*r.Rate + 5000) / 10000
+}
+
+// List returns all Rates of the organization of the user.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]Rate, error) {
+
+	list := []Rate{}
+
+	const q = `SELECT * FROM tax_rates WHERE ($1::uuid IS NULL OR org_id = $1) ORDER BY category, name`
+	if err := db.SelectContext(ctx, &list, q, claims.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting all tax rates")
+	}
+	return list, nil
+}
+
+// Retrieve returns a single Rate. Rates of other organizations than the one
+// of the user are not found.
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) (*Rate, error) {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var r Rate
+	const q = `SELECT * FROM tax_rates WHERE tax_rate_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if err := db.GetContext(ctx, &r, q, id, claims.Tenant()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting single tax rate")
+	}
+	return &r, nil
+}
+
+// Applicable finds the Rate to apply to a sale of a product of the given
+// category of an organization, at the given event if any. A Rate for that
+// very event wins over one for every event, then a Rate for that very category
+// wins over a general one. It returns nil if no Rate applies, meaning no tax
+// is collected. It can be used within a transaction.
+func Applicable(ctx context.Context, db sqlx.QueryerContext, orgID, category string, eventID *string) (*Rate, error) {
+
+	var r Rate
+	const q = `SELECT * FROM tax_rates
+			   WHERE org_id = $1
+			   AND (category = $2 OR category = '')
+			   AND (event_id IS NULL OR event_id = $3)
+			   ORDER BY event_id IS NULL, category DESC, date_created DESC
+			   LIMIT 1`
+	if err := sqlx.GetContext(ctx, db, &r, q, orgID, category, eventID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "selecting applicable tax rate")
+	}
+	return &r, nil
+}
+
+// Create makes a new Rate in the organization of the user. The event it is
+// for, if any, has to belong to the same organization.
+func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, nr NewRate, now time.Time) (*Rate, error) {
+
+	r := Rate{
+		ID:          uuid.New().String(),
+		OrgID:       claims.OrgID,
+		Name:        nr.Name,
+		Rate:        nr.Rate,
+		Inclusive:   nr.Inclusive,
+		Category:    nr.Category,
+		EventID:     nr.EventID,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	if err := checkEvent(ctx, db, r.OrgID, r.EventID); err != nil {
+		return nil, err
+	}
+
+	const q = `INSERT INTO tax_rates
+		(tax_rate_id, org_id, name, rate, inclusive, category, event_id, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	if _, err := db.ExecContext(ctx, q, r.ID, r.OrgID, r.Name, r.Rate, r.Inclusive, r.Category, r.EventID, r.DateCreated, r.DateUpdated); err != nil {
+		if isUnknownEvent(err) {
+			return nil, ErrUnknownEvent
+		}
+		return nil, errors.Wrapf(err, "inserting tax rate: %v", nr)
+	}
+
+	return &r, nil
+}
+
+// Update modifies a Rate. Sales already recorded keep the tax they were
+// charged with.
+func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, update UpdateRate, now time.Time) error {
+
+	r, err := Retrieve(ctx, db, claims, id)
+	if err != nil {
+		return err
+	}
+
+	if update.Name != nil {
+		r.Name = *update.Name
+	}
+	if update.Rate != nil {
+		r.Rate = *update.Rate
+	}
+	if update.Inclusive != nil {
+		r.Inclusive = *update.Inclusive
+	}
+	if update.Category != nil {
+		r.Category = *update.Category
+	}
+	if update.EventID != nil {
+		switch *update.EventID {
+		case "":
+			r.EventID = nil
+		default:
+			if _, err := uuid.Parse(*update.EventID); err != nil {
+				return ErrInvalidID
+			}
+			r.EventID = update.EventID
+		}
+	}
+	if err := checkEvent(ctx, db, r.OrgID, r.EventID); err != nil {
+		return err
+	}
+	r.DateUpdated = now.UTC()
+
+	const q = `UPDATE tax_rates SET
+		"name" = $2,
+		"rate" = $3,
+		"inclusive" = $4,
+		"category" = $5,
+		"event_id" = $6,
+		"date_updated" = $7
+		WHERE tax_rate_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, r.Name, r.Rate, r.Inclusive, r.Category, r.EventID, r.DateUpdated); err != nil {
+		if isUnknownEvent(err) {
+			return ErrUnknownEvent
+		}
+		return errors.Wrap(err, "updating tax rate")
+	}
+
+	return nil
+}
+
+// Delete removes the Rate identified by a given ID. Rates of other
+// organizations than the one of the user are left alone.
+func Delete(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) error {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM tax_rates WHERE tax_rate_id = $1 AND ($2::uuid IS NULL OR org_id = $2)`
+	if _, err := db.ExecContext(ctx, q, id, claims.Tenant()); err != nil {
+		return errors.Wrapf(err, "deleting tax rate %s", id)
+	}
+
+	return nil
+}
+
+// Report gives the tax collected by the organization of the user between
+// from (inclusive) and to (exclusive), totalled per period. The period is one
+// of "day", "week", "month" or "year".
+func Report(ctx context.Context, db *sqlx.DB, claims auth.Claims, period string, from, to time.Time) ([]PeriodTotal, error) {
+
+	switch period {
+	case "day", "week", "month", "year":
+	default:
+		return nil, ErrInvalidPeriod
+	}
+
+	totals := []PeriodTotal{}
+
+	const q = `SELECT date_trunc($1, date_created) AS period,
+			   COUNT(*) AS sales,
+			   COALESCE(SUM(paid) FILTER (WHERE tax > 0), 0) AS taxed,
+			   COALESCE(SUM(tax), 0) AS tax
+			   FROM sales
+			   WHERE date_created >= $2 AND date_created < $3
+			   AND ($4::uuid IS NULL OR org_id = $4)
+			   GROUP BY period
+			   ORDER BY period`
+	if err := db.SelectContext(ctx, &totals, q, period, from.UTC(), to.UTC(), claims.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting tax report")
+	}
+
+	return totals, nil
+}
+
+// checkEvent makes sure the event a Rate is for, if any, belongs to the
+// organization of the Rate. Events of other organizations are unknown.
+func checkEvent(ctx context.Context, db *sqlx.DB, orgID string, eventID *string) error {
+
+	if eventID == nil {
+		return nil
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS (SELECT 1 FROM events WHERE event_id = $1 AND org_id = $2)`
+	if err := db.GetContext(ctx, &exists, q, *eventID, orgID); err != nil {
+		return errors.Wrap(err, "looking for tax rate event")
+	}
+	if !exists {
+		return ErrUnknownEvent
+	}
+
+	return nil
+}
+
+// isUnknownEvent tells if err is the violation of the foreign key that links a
+// Rate to its event.
+func isUnknownEvent(err error) bool {
+
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Constraint == "tax_rates_event_id_fkey"
+}
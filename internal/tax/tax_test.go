@@ -0,0 +1,190 @@
+package tax_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/product"
+	"github.com/devisions/garagesale/internal/tax"
+	"github.com/devisions/garagesale/internal/tests"
+)
+
+func TestCompute(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		rate   tax.Rate
+		amount int
+		want   int
+	}{
+		{"exclusive", tax.Rate{Rate: 800}, 100, 8},
+		{"exclusive rounds half up", tax.Rate{Rate: 825}, 200, 17},
+		{"inclusive", tax.Rate{Rate: 800, Inclusive: true}, 108, 8},
+		{"inclusive rounds", tax.Rate{Rate: 825, Inclusive: true}, 100, 8},
+		{"zero rate", tax.Rate{Rate: 0}, 100, 0},
+		{"zero amount", tax.Rate{Rate: 2000}, 0, 0},
+	}
+
+	for _, tc := range cases {
+		if got := tc.rate.Compute(tc.amount); got != tc.want {
+			t.Errorf("%s: expected tax %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestSalesTax(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	claims := auth.NewClaims(
+		"718ffbea-f4a1-4667-8ae3-b349da52675e", // A random UUID.
+		[]string{auth.RoleAdmin, auth.RoleUser},
+		now, time.Hour,
+	)
+	claims.OrgID = org.Default
+
+	if _, err := tax.Create(ctx, db, claims, tax.NewRate{Name: "General", Rate: 1000}, now); err != nil {
+		t.Fatalf("creating tax rate: %s", err)
+	}
+	books, err := tax.Create(ctx, db, claims, tax.NewRate{Name: "Books", Rate: 500, Inclusive: true, Category: "books"}, now)
+	if err != nil {
+		t.Fatalf("creating tax rate: %s", err)
+	}
+
+	comics, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Comic Books", Category: "books", Cost: 10, Quantity: 20}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+	toys, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Toys", Cost: 40, Quantity: 30}, now)
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+
+	// The category specific rate applies to comics.
+	s, err := product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1, Paid: 105}, comics.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if exp, got := 5, s.Tax; exp != got {
+		t.Fatalf("expected comics tax %v, got %v", exp, got)
+	}
+	if s.TaxRateID == nil || *s.TaxRateID != books.ID {
+		t.Fatalf("expected comics sale to use the %q rate, got %v", books.Name, s.TaxRateID)
+	}
+
+	// The general rate applies to toys.
+	s, err = product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1, Paid: 40}, toys.ID, now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if exp, got := 4, s.Tax; exp != got {
+		t.Fatalf("expected toys tax %v, got %v", exp, got)
+	}
+
+	totals, err := tax.Report(ctx, db, claims, "day", now.AddDate(0, 0, -1), now.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("getting tax report: %s", err)
+	}
+	if exp, got := 2, len(totals); exp != got {
+		t.Fatalf("expected %v report periods, got %v", exp, got)
+	}
+	if exp, got := 5, totals[0].Tax; exp != got {
+		t.Fatalf("expected tax of first day %v, got %v", exp, got)
+	}
+
+	if _, err := tax.Report(ctx, db, claims, "fortnight", now, now); err != tax.ErrInvalidPeriod {
+		t.Fatalf("expected ErrInvalidPeriod, got %v", err)
+	}
+}
+
+func TestTaxTenancy(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	other, err := org.Create(ctx, db, org.NewOrg{Name: "Other Gophers"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	owner := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+	owner.OrgID = org.Default
+	stranger := auth.NewClaims("2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37", []string{auth.RoleAdmin}, now, time.Hour)
+	stranger.OrgID = other.ID
+	stranger.Permissions = []string{auth.PermTaxWrite, auth.PermReportRead}
+	super := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleSuperAdmin}, now, time.Hour)
+	super.OrgID = other.ID
+	super.Permissions = []string{auth.PermOrgManage}
+
+	general, err := tax.Create(ctx, db, owner, tax.NewRate{Name: "General", Rate: 1000}, now)
+	if err != nil {
+		t.Fatalf("creating tax rate: %s", err)
+	}
+	toys, err := product.Create(ctx, db, owner, product.NewProduct{Name: "Toys", Cost: 40, Quantity: 30}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	if _, err := product.AddSale(ctx, db, owner, product.NewSale{Quantity: 1, Paid: 40}, toys.ID, now); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	if _, err := tax.Retrieve(ctx, db, stranger, general.ID); err != tax.ErrNotFound {
+		t.Fatalf("expected ErrNotFound retrieving another organization's tax rate, got %v", err)
+	}
+	list, err := tax.List(ctx, db, stranger)
+	if err != nil {
+		t.Fatalf("listing tax rates: %s", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no tax rates of another organization, got %d", len(list))
+	}
+	totals, err := tax.Report(ctx, db, stranger, "day", now.AddDate(0, 0, -1), now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("getting tax report: %s", err)
+	}
+	if len(totals) != 0 {
+		t.Fatalf("expected no tax collected by another organization, got %+v", totals)
+	}
+	rate := 0
+	if err := tax.Update(ctx, db, stranger, general.ID, tax.UpdateRate{Rate: &rate}, now); err != tax.ErrNotFound {
+		t.Fatalf("expected ErrNotFound updating another organization's tax rate, got %v", err)
+	}
+	if err := tax.Delete(ctx, db, stranger, general.ID); err != nil {
+		t.Fatalf("deleting tax rate: %s", err)
+	}
+
+	// The rate of one organization does not tax the sales of another.
+	games, err := product.Create(ctx, db, stranger, product.NewProduct{Name: "Games", Cost: 40, Quantity: 30}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	s, err := product.AddSale(ctx, db, stranger, product.NewSale{Quantity: 1, Paid: 40}, games.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.Tax != 0 || s.TaxRateID != nil {
+		t.Fatalf("expected no tax on the sale of another organization, got %+v", s)
+	}
+
+	// A super admin reaches every organization.
+	if _, err := tax.Retrieve(ctx, db, super, general.ID); err != nil {
+		t.Fatalf("super admin retrieving tax rate: %s", err)
+	}
+	totals, err = tax.Report(ctx, db, super, "day", now.AddDate(0, 0, -1), now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("getting tax report: %s", err)
+	}
+	if len(totals) != 1 || totals[0].Sales != 2 {
+		t.Fatalf("expected the sales of both organizations, got %+v", totals)
+	}
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"log"
@@ -12,6 +13,7 @@ import (
 	"github.com/devisions/garagesale/internal/platform/database"
 	"github.com/devisions/garagesale/internal/platform/database/databasetest"
 	"github.com/devisions/garagesale/internal/schema"
+	"github.com/devisions/garagesale/internal/user"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -123,6 +125,23 @@ func New(t *testing.T) *Test {
 	}
 }
 
+// Token generates an authenticated token for a user.
+func (test *Test) Token(email, pass string) string {
+	test.t.Helper()
+
+	claims, err := user.Authenticate(context.Background(), test.DB, user.Policy{}, time.Now(), email, pass)
+	if err != nil {
+		test.t.Fatal(err)
+	}
+
+	tkn, err := test.Authenticator.GenerateToken(claims)
+	if err != nil {
+		test.t.Fatal(err)
+	}
+
+	return tkn
+}
+
 // Teardown releases any resources used for the test.
 func (test *Test) Teardown() {
 	test.cleanup()
@@ -0,0 +1,102 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/oidc"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for the failures of federated logins.
+var (
+	// ErrIdentityNotVerified occurs when an identity provider vouches for a
+	// user without having verified their email, which is then useless to us.
+	ErrIdentityNotVerified = errors.New("identity provider did not verify the email")
+
+	// ErrAccountNotVerified occurs when an identity has the email of an
+	// account that was never verified. Anyone could have registered it with a
+	// password of their own, so it is not linked until its owner verifies it.
+	ErrAccountNotVerified = errors.New("an account with this email awaits verification")
+)
+
+// Federate gives the Claims of the user an identity provider logged in. The
+// identity is linked to a user the first time: the verified one having its
+// email, or a new user with the user role.
+func Federate(ctx context.Context, db *sqlx.DB, p Policy, id oidc.Identity, now time.Time) (auth.Claims, error) {
+
+	const q = `SELECT u.* FROM users AS u
+		JOIN user_identities AS i ON i.user_id = u.user_id
+		WHERE i.issuer = $1 AND i.subject = $2`
+	var u User
+	err := db.GetContext(ctx, &u, q, id.Issuer, id.Subject)
+	if err == nil {
+		return newClaims(ctx, db, &u, now)
+	}
+	if err != sql.ErrNoRows {
+		return auth.Claims{}, errors.Wrap(err, "selecting linked user")
+	}
+
+	if !id.EmailVerified || id.Email == "" {
+		return auth.Claims{}, ErrIdentityNotVerified
+	}
+
+	const qe = `SELECT * FROM users WHERE email = $1`
+	err = db.GetContext(ctx, &u, qe, id.Email)
+	switch err {
+	case nil:
+		if !u.Verified {
+			return auth.Claims{}, ErrAccountNotVerified
+		}
+	case sql.ErrNoRows:
+		nu, err := provision(ctx, db, p, id, now)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+		u = *nu
+	default:
+		return auth.Claims{}, errors.Wrap(err, "selecting user by email")
+	}
+
+	const ql = `INSERT INTO user_identities (issuer, subject, user_id, date_created)
+		VALUES ($1, $2, $3, $4)`
+	if _, err := db.ExecContext(ctx, ql, id.Issuer, id.Subject, u.ID, now.UTC()); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "linking identity")
+	}
+
+	return newClaims(ctx, db, &u, now)
+}
+
+// provision creates the user of an identity, in the default organization.
+// Nobody knows their password: they log in through the provider unless they
+// reset it.
+func provision(ctx context.Context, db *sqlx.DB, p Policy, id oidc.Identity, now time.Time) (*User, error) {
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, errors.Wrap(err, "generating password")
+	}
+	password := base64.RawURLEncoding.EncodeToString(raw)
+
+	name := id.Name
+	if name == "" {
+		name = id.Email
+	}
+
+	n := NewUser{
+		Name:            name,
+		Email:           id.Email,
+		Roles:           []string{auth.RoleUser},
+		Password:        password,
+		PasswordConfirm: password,
+	}
+
+	// The random password would not follow every policy, only its hasher
+	// matters.
+	return create(ctx, db, Policy{Hasher: p.Hasher}, n, true, now)
+}
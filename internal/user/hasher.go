@@ -0,0 +1,192 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher turns passwords into the hashes we store. It also tells when
+// a stored hash was made with another algorithm or other parameters than its
+// own, so that the password gets hashed again.
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	NeedsRehash(hash []byte) bool
+}
+
+// NewPasswordHasher gives the hasher of an algorithm, "bcrypt" or "argon2id",
+// set up with the parameters given for it. A bcrypt cost out of the range
+// bcrypt supports is refused.
+func NewPasswordHasher(algorithm string, b Bcrypt, a Argon2id) (PasswordHasher, error) {
+
+	switch algorithm {
+	case "bcrypt":
+		if b.Cost != 0 && (b.Cost < bcrypt.MinCost || b.Cost > bcrypt.MaxCost) {
+			return nil, errors.Errorf("bcrypt cost %d not within %d and %d", b.Cost, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return b, nil
+	case "argon2id":
+		return a, nil
+	default:
+		return nil, errors.Errorf("unknown password hashing algorithm %q", algorithm)
+	}
+}
+
+// ComparePassword checks a password against a hash made by any of the
+// supported algorithms. It gives ErrAuthenticationFailure when they do not
+// match.
+func ComparePassword(hash []byte, password string) error {
+
+	if strings.HasPrefix(string(hash), argon2idPrefix) {
+		a, salt, key, err := parseArgon2id(string(hash))
+		if err != nil {
+			return ErrAuthenticationFailure
+		}
+		other := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrAuthenticationFailure
+		}
+		return nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return ErrAuthenticationFailure
+	}
+	return nil
+}
+
+// Bcrypt hashes passwords with bcrypt. A zero Cost stands for the default
+// cost of bcrypt.
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash implements the PasswordHasher interface.
+func (b Bcrypt) Hash(password string) ([]byte, error) {
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost())
+	if err != nil {
+		return nil, errors.Wrap(err, "generating password hash")
+	}
+	return hash, nil
+}
+
+// NeedsRehash implements the PasswordHasher interface.
+func (b Bcrypt) NeedsRehash(hash []byte) bool {
+
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != b.cost()
+}
+
+// cost gives the cost to hash passwords with.
+func (b Bcrypt) cost() int {
+
+	if b.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return b.Cost
+}
+
+// argon2idPrefix starts the PHC strings of argon2id hashes.
+const argon2idPrefix = "$argon2id$"
+
+// Argon2id hashes passwords with argon2id, storing them as PHC strings like
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>. Memory is in KiB. Zero fields
+// stand for the parameters recommended by RFC 9106 for constrained
+// environments.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// Hash implements the PasswordHasher interface.
+func (a Argon2id) Hash(password string) ([]byte, error) {
+
+	a = a.withDefaults()
+
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	phc := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+	return []byte(phc), nil
+}
+
+// NeedsRehash implements the PasswordHasher interface.
+func (a Argon2id) NeedsRehash(hash []byte) bool {
+
+	a = a.withDefaults()
+
+	got, salt, key, err := parseArgon2id(string(hash))
+	if err != nil {
+		return true
+	}
+	return got.Time != a.Time || got.Memory != a.Memory || got.Threads != a.Threads ||
+		uint32(len(salt)) != a.SaltLen || uint32(len(key)) != a.KeyLen
+}
+
+// withDefaults sets the zero parameters to their default value.
+func (a Argon2id) withDefaults() Argon2id {
+
+	if a.Time == 0 {
+		a.Time = 3
+	}
+	if a.Memory == 0 {
+		a.Memory = 64 * 1024
+	}
+	if a.Threads == 0 {
+		a.Threads = 4
+	}
+	if a.SaltLen == 0 {
+		a.SaltLen = 16
+	}
+	if a.KeyLen == 0 {
+		a.KeyLen = 32
+	}
+	return a
+}
+
+// parseArgon2id reads the parameters, salt and key of an argon2id PHC string.
+func parseArgon2id(phc string) (Argon2id, []byte, []byte, error) {
+
+	var a Argon2id
+
+	parts := strings.Split(phc, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return a, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return a, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.Memory, &a.Time, &a.Threads); err != nil {
+		return a, nil, nil, errors.Wrap(err, "parsing argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return a, nil, nil, errors.Wrap(err, "decoding argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return a, nil, nil, errors.Wrap(err, "decoding argon2id key")
+	}
+
+	return a, salt, key, nil
+}
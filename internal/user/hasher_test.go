@@ -0,0 +1,67 @@
+package user_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devisions/garagesale/internal/user"
+)
+
+func TestPasswordHashers(t *testing.T) {
+
+	// Cheap parameters keep the test fast.
+	b := user.Bcrypt{Cost: 4}
+	a := user.Argon2id{Time: 1, Memory: 1024, Threads: 1}
+
+	tests := []struct {
+		name   string
+		hasher user.PasswordHasher
+		prefix string
+		other  user.PasswordHasher
+	}{
+		{"bcrypt", b, "$2a$04$", user.Bcrypt{Cost: 5}},
+		{"argon2id", a, "$argon2id$v=19$m=1024,t=1,p=1$", user.Argon2id{Time: 2, Memory: 1024, Threads: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hasher.Hash("gophers")
+			if err != nil {
+				t.Fatalf("hashing: %s", err)
+			}
+			if !strings.HasPrefix(string(hash), tt.prefix) {
+				t.Fatalf("expected hash to start with %q, got %q", tt.prefix, hash)
+			}
+
+			if err := user.ComparePassword(hash, "gophers"); err != nil {
+				t.Fatalf("comparing the right password: %s", err)
+			}
+			if err := user.ComparePassword(hash, "gopher"); err != user.ErrAuthenticationFailure {
+				t.Fatalf("expected ErrAuthenticationFailure comparing a wrong password, got %v", err)
+			}
+
+			if tt.hasher.NeedsRehash(hash) {
+				t.Fatal("expected a hash with the same parameters not to need a rehash")
+			}
+			if !tt.other.NeedsRehash(hash) {
+				t.Fatal("expected a hash with other parameters to need a rehash")
+			}
+		})
+	}
+
+	hash, err := b.Hash("gophers")
+	if err != nil {
+		t.Fatalf("hashing: %s", err)
+	}
+	if !a.NeedsRehash(hash) {
+		t.Fatal("expected a bcrypt hash to need a rehash with argon2id")
+	}
+	if _, err := user.NewPasswordHasher("md5", b, a); err == nil {
+		t.Fatal("expected an unknown algorithm to be refused")
+	}
+	for _, cost := range []int{3, 32} {
+		if _, err := user.NewPasswordHasher("bcrypt", user.Bcrypt{Cost: cost}, a); err == nil {
+			t.Fatalf("expected bcrypt cost %d to be refused", cost)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Impersonation is the record of someone acting as a user.
+type Impersonation struct {
+	TokenID     string    `db:"token_id"      json:"token_id"`
+	ActorID     string    `db:"actor_id"      json:"actor_id"`
+	UserID      string    `db:"user_id"       json:"user_id"`
+	DateCreated time.Time `db:"date_created"  json:"date_created"`
+	ExpiresAt   time.Time `db:"expires_at"    json:"expires_at"`
+}
+
+// Impersonate gives Claims for the user of the actor claims to act as the
+// specified user, within the organization of the actor, for the duration.
+// The Claims grant no permission, whatever the roles of the user: they let
+// see what the user sees, not do what admins do. Every impersonation is
+// recorded.
+func Impersonate(ctx context.Context, db *sqlx.DB, actor auth.Claims, id string, now time.Time, expires time.Duration) (auth.Claims, error) {
+
+	if actor.Impersonated() || actor.Subject == id {
+		return auth.Claims{}, ErrForbidden
+	}
+
+	u, err := Retrieve(ctx, db, actor, id)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	if !u.Active(now) {
+		return auth.Claims{}, ErrInactive
+	}
+
+	claims := auth.NewClaims(u.ID, u.Roles, now, expires)
+	claims.OrgID = u.OrgID
+	claims.Permissions = []string{}
+	claims.Act = &auth.Actor{Subject: actor.Subject}
+
+	const q = `INSERT INTO impersonations
+		(token_id, actor_id, user_id, date_created, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := db.ExecContext(ctx, q, claims.Id, actor.Subject, u.ID, now.UTC(), now.Add(expires).UTC()); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "recording impersonation")
+	}
+
+	return claims, nil
+}
+
+// ListImpersonations gives the times the specified user was impersonated, the
+// latest first. It is up to the caller to make sure the user may be looked at.
+func ListImpersonations(ctx context.Context, db *sqlx.DB, id string) ([]Impersonation, error) {
+
+	list := []Impersonation{}
+
+	const q = `SELECT * FROM impersonations WHERE user_id = $1 ORDER BY date_created DESC`
+	if err := db.SelectContext(ctx, &list, q, id); err != nil {
+		return nil, errors.Wrap(err, "selecting impersonations")
+	}
+
+	return list, nil
+}
@@ -12,16 +12,120 @@ type User struct {
 	Name         string         `db:"name"           json:"name"`
 	Email        string         `db:"email"          json:"email"`
 	Roles        pq.StringArray `db:"roles"          json:"roles"`
+	OrgID        string         `db:"org_id"         json:"org_id"`
+	PendingEmail *string        `db:"pending_email"  json:"pending_email"`
 	PasswordHash []byte         `db:"password_hash"  json:"-"`
+	Verified     bool           `db:"verified"       json:"verified"`
+	Status       string         `db:"status"         json:"status"`
+	StatusReason *string        `db:"status_reason"  json:"status_reason"`
+	StatusUntil  *time.Time     `db:"status_until"   json:"status_until"`
 	DateCreated  time.Time      `db:"date_created"   json:"date_created"`
 	DateUpdated  time.Time      `db:"date_updated"   json:"date_updated"`
 }
 
-// NewUser contains information needed to create a new User.
+// These are the statuses a User can have. Only active users may authenticate.
+const (
+	StatusActive      = "active"
+	StatusSuspended   = "suspended"
+	StatusDeactivated = "deactivated"
+)
+
+// Active tells whether the User may use the system at the time: their status
+// is active, or the one they were given expired.
+func (u User) Active(now time.Time) bool {
+	return u.Status == StatusActive || (u.StatusUntil != nil && !now.Before(*u.StatusUntil))
+}
+
+// StatusChange is what we require from admins to change the status of a
+// User. The Reason is kept for the record, and the status lasts until the
+// time Until, if given.
+type StatusChange struct {
+	Status string     `json:"status"  validate:"required,oneof=active suspended deactivated"`
+	Reason *string    `json:"reason"`
+	Until  *time.Time `json:"until"`
+}
+
+// ProfileChange defines what Users may change about themselves. All fields
+// are optional so clients can send just the fields they want changed. A new
+// email only replaces the current one once verified, and requires the current
+// password.
+type ProfileChange struct {
+	Name            *string `json:"name"              validate:"omitempty,min=1"`
+	Email           *string `json:"email"             validate:"omitempty,email"`
+	CurrentPassword *string `json:"current_password"  validate:"required_with=Email"`
+}
+
+// NewUser contains information needed to create a new User. OrgID is
+// optional and names the organization the User belongs to.
 type NewUser struct {
 	Name            string   `json:"name"              validate:"required"`
-	Email           string   `json:"email"             validate:"required"`
+	Email           string   `json:"email"             validate:"required,email"`
 	Roles           []string `json:"roles"             validate:"required"`
+	OrgID           string   `json:"org_id"            validate:"omitempty,uuid"`
 	Password        string   `json:"password"          validate:"required"`
 	PasswordConfirm string   `json:"password_confirm"  validate:"eqfield=Password"`
 }
+
+// NewRegistration contains information needed for someone to sign up as a
+// User on their own. Such users only get the USER role.
+type NewRegistration struct {
+	Name            string `json:"name"              validate:"required"`
+	Email           string `json:"email"             validate:"required,email"`
+	Password        string `json:"password"          validate:"required"`
+	PasswordConfirm string `json:"password_confirm"  validate:"eqfield=Password"`
+}
+
+// PasswordForgot is what we require for someone to reset their password.
+type PasswordForgot struct {
+	Email string `json:"email"  validate:"required"`
+}
+
+// PasswordReset contains information needed to set a new password using a
+// password reset token.
+type PasswordReset struct {
+	Token           string `json:"token"             validate:"required"`
+	Password        string `json:"password"          validate:"required"`
+	PasswordConfirm string `json:"password_confirm"  validate:"eqfield=Password"`
+}
+
+// PasswordChange contains information needed for a User to change their
+// password.
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"  validate:"required"`
+	Password        string `json:"password"          validate:"required"`
+	PasswordConfirm string `json:"password_confirm"  validate:"eqfield=Password"`
+}
+
+// Enrollment is what a User gets when enrolling in two factor
+// authentication: the TOTP secret, along with its provisioning URI to be
+// shown as a QR code, and single use recovery codes for when the
+// authenticator app is lost.
+type Enrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// SecondFactor holds a TOTP or recovery code.
+type SecondFactor struct {
+	Code string `json:"code"  validate:"required"`
+}
+
+// Challenge is what we require for completing an authentication with the
+// second factor.
+type Challenge struct {
+	Token string `json:"token"  validate:"required"`
+	Code  string `json:"code"   validate:"required"`
+}
+
+// UpdateUser defines what information may be provided to modify an existing
+// User. All fields are optional so clients can send just the fields they want
+// changed. It uses pointer fields so we can differentiate between a field that
+// was not provided and a field that was provided as explicitly blank.
+type UpdateUser struct {
+	Name            *string  `json:"name"`
+	Email           *string  `json:"email"             validate:"omitempty,email"`
+	Roles           []string `json:"roles"`
+	Password        *string  `json:"password"          validate:"omitempty,min=1"`
+	PasswordConfirm *string  `json:"password_confirm"  validate:"required_with=Password,omitempty,eqfield=Password"`
+}
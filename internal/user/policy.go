@@ -0,0 +1,185 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// commonPasswords are always refused, on top of the deny list of a Policy.
+var commonPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "111111", "000000",
+	"password", "password1", "password123", "passw0rd", "qwerty", "qwerty123",
+	"qwertyuiop", "abc123", "letmein", "welcome", "welcome1", "admin",
+	"admin123", "iloveyou", "monkey", "dragon", "sunshine", "princess",
+	"football", "baseball", "superman", "trustno1", "master", "shadow",
+	"changeme", "secret", "login", "starwars", "whatever", "1q2w3e4r",
+}
+
+// ReadDenyList reads a file of passwords to refuse, one per line. Empty lines
+// are skipped.
+func ReadDenyList(path string) ([]string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening deny list")
+	}
+	defer f.Close()
+
+	var list []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			list = append(list, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading deny list")
+	}
+
+	return list, nil
+}
+
+// Policy holds the rules passwords have to follow.
+type Policy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// DenyList holds passwords that are refused, whatever the case.
+	DenyList []string
+
+	// History is the number of previous passwords of a user that cannot be
+	// used again. Zero allows reusing any of them.
+	History int
+
+	// Hasher hashes the passwords to store. Bcrypt with its default cost is
+	// used when nil.
+	Hasher PasswordHasher
+}
+
+// hasher gives the PasswordHasher of the policy.
+func (p Policy) hasher() PasswordHasher {
+
+	if p.Hasher == nil {
+		return Bcrypt{}
+	}
+	return p.Hasher
+}
+
+// PolicyError occurs when a password does not follow the Policy. It tells
+// every rule that was broken.
+type PolicyError struct {
+	Violations []string
+}
+
+// Error implements the error interface.
+func (pe *PolicyError) Error() string {
+	return "password does not follow the policy: " + strings.Join(pe.Violations, ", ")
+}
+
+// Check tells whether the password follows the rules of the policy that do
+// not depend on the user's previous passwords.
+func (p Policy) Check(password string) error {
+
+	var violations []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must have at least %d characters", p.MinLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must have an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "must have a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must have a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must have a symbol")
+	}
+
+	if p.denied(password) {
+		violations = append(violations, "is too common")
+	}
+
+	if violations != nil {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// denied tells whether the password is a common one or in the deny list.
+func (p Policy) denied(password string) bool {
+
+	for _, list := range [][]string{commonPasswords, p.DenyList} {
+		for _, d := range list {
+			if strings.EqualFold(password, d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkChange tells whether the new password of an existing user follows the
+// policy, including not being one of the last ones the user had.
+func (p Policy) checkChange(ctx context.Context, db *sqlx.DB, userID, password string) error {
+
+	if err := p.Check(password); err != nil {
+		return err
+	}
+
+	if p.History <= 0 {
+		return nil
+	}
+
+	var hashes [][]byte
+	const q = `SELECT password_hash FROM password_history
+		WHERE user_id = $1 ORDER BY date_created DESC LIMIT $2`
+	if err := db.SelectContext(ctx, &hashes, q, userID, p.History); err != nil {
+		return errors.Wrap(err, "selecting password history")
+	}
+
+	for _, h := range hashes {
+		if ComparePassword(h, password) == nil {
+			return &PolicyError{Violations: []string{fmt.Sprintf("must differ from the last %d passwords", p.History)}}
+		}
+	}
+	return nil
+}
+
+// recordPassword adds a password hash to the history of a user.
+func recordPassword(ctx context.Context, db *sqlx.DB, userID string, hash []byte, now time.Time) error {
+
+	const q = `INSERT INTO password_history (user_id, password_hash, date_created) VALUES ($1, $2, $3)`
+	if _, err := db.ExecContext(ctx, q, userID, hash, now.UTC()); err != nil {
+		return errors.Wrap(err, "recording password")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package user_test
+
+import (
+	"testing"
+
+	"github.com/devisions/garagesale/internal/user"
+)
+
+func TestPolicyCheck(t *testing.T) {
+
+	p := user.Policy{
+		MinLength:     10,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		DenyList:      []string{"Garage-Sale-1"},
+	}
+
+	tests := []struct {
+		password   string
+		violations int
+	}{
+		{"Gopher-2-Gopher", 0},
+		{"Gö-2-phër-Gopher", 0},
+		{"Gopher-2", 1},
+		{"gopher-2-gopher", 1},
+		{"GOPHER-2-GOPHER", 1},
+		{"Gopher-Two-Gopher", 1},
+		{"Gopher2Gopher", 1},
+		{"garage-sale-1", 2},
+		{"password", 5},
+	}
+
+	for _, tt := range tests {
+		err := p.Check(tt.password)
+		if tt.violations == 0 {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", tt.password, err)
+			}
+			continue
+		}
+
+		pe, ok := err.(*user.PolicyError)
+		if !ok {
+			t.Errorf("%q: expected a policy error, got %v", tt.password, err)
+			continue
+		}
+		if len(pe.Violations) != tt.violations {
+			t.Errorf("%q: expected %d violations, got %v", tt.password, tt.violations, pe.Violations)
+		}
+	}
+}
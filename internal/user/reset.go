@@ -0,0 +1,93 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// RequestPasswordReset issues a single use token allowing to reset the
+// password of the user with the given email, valid for the given duration.
+// Only a hash of the token is stored. It returns ErrNotFound if there is no
+// such user, which callers must not reveal to unauthenticated clients.
+func RequestPasswordReset(ctx context.Context, db *sqlx.DB, email string, ttl time.Duration, now time.Time) (string, *User, error) {
+
+	u, err := RetrieveByEmail(ctx, db, email)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, errors.Wrap(err, "generating reset token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	const qi = `INSERT INTO password_resets
+		(token_hash, user_id, expires_at, date_created)
+		VALUES ($1, $2, $3, $4)`
+	if _, err := db.ExecContext(ctx, qi, hashToken(token), u.ID, now.Add(ttl).UTC(), now.UTC()); err != nil {
+		return "", nil, errors.Wrap(err, "inserting password reset")
+	}
+
+	return token, u, nil
+}
+
+// ResetPassword consumes a password reset token and sets the new password of
+// the user it was issued for. The new password must follow the policy; the
+// token stays usable if it does not. Any other token issued for that user is
+// consumed as well. It gives the id of the user, whose sessions should end.
+func ResetPassword(ctx context.Context, db *sqlx.DB, p Policy, token, password string, now time.Time) (string, error) {
+
+	var userID string
+	const q = `SELECT user_id FROM password_resets
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2`
+	if err := db.GetContext(ctx, &userID, q, hashToken(token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrInvalidToken
+		}
+		return "", errors.Wrap(err, "selecting password reset")
+	}
+
+	if err := p.checkChange(ctx, db, userID, password); err != nil {
+		return "", err
+	}
+
+	// The token is consumed only if nobody else did it in between.
+	const qu = `UPDATE password_resets SET used_at = $2 WHERE token_hash = $1 AND used_at IS NULL`
+	res, err := db.ExecContext(ctx, qu, hashToken(token), now.UTC())
+	if err != nil {
+		return "", errors.Wrap(err, "consuming password reset")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return "", errors.Wrap(err, "consuming password reset")
+	} else if n == 0 {
+		return "", ErrInvalidToken
+	}
+
+	if err := setPassword(ctx, db, p, userID, password, now); err != nil {
+		return "", err
+	}
+
+	const qc = `UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL`
+	if _, err := db.ExecContext(ctx, qc, userID, now.UTC()); err != nil {
+		return "", errors.Wrap(err, "consuming other password resets")
+	}
+
+	return userID, nil
+}
+
+// hashToken gives the hex encoded SHA-256 of a token. Tokens are random and
+// long enough that a fast hash is as good as a slow one.
+func hashToken(token string) string {
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
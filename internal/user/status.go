@@ -0,0 +1,113 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// SetStatus changes the status of the specified user. Users of other
+// organizations than the one of the claims are not found, and users cannot
+// change their own status. Becoming active clears the reason and expiry.
+func SetStatus(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, sc StatusChange, now time.Time) (*User, error) {
+
+	if id == claims.Subject {
+		return nil, ErrForbidden
+	}
+
+	u, err := Retrieve(ctx, db, claims, id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Status, u.StatusReason, u.StatusUntil = sc.Status, sc.Reason, sc.Until
+	if u.Status == StatusActive {
+		u.StatusReason, u.StatusUntil = nil, nil
+	}
+	if u.StatusUntil != nil {
+		until := u.StatusUntil.UTC()
+		u.StatusUntil = &until
+	}
+	u.DateUpdated = now.UTC()
+
+	const q = `UPDATE users SET status = $2, status_reason = $3, status_until = $4, date_updated = $5
+		WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, u.ID, u.Status, u.StatusReason, u.StatusUntil, u.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "updating user status")
+	}
+
+	return u, nil
+}
+
+// StatusCache tells whether users are active, looking their status up in the
+// database at most once per TTL. It lets tokens be refused as soon as their
+// user is suspended without a query per request.
+type StatusCache struct {
+	db  *sqlx.DB
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]statusEntry
+}
+
+// statusEntry is the status of a user as of when it was looked up.
+type statusEntry struct {
+	user    User
+	fetched time.Time
+}
+
+// NewStatusCache creates a StatusCache keeping statuses for the ttl.
+func NewStatusCache(db *sqlx.DB, ttl time.Duration) *StatusCache {
+	return &StatusCache{db: db, ttl: ttl, entries: make(map[string]statusEntry)}
+}
+
+// Active tells whether the specified user is active at the time. Users that
+// do not exist are not.
+func (c *StatusCache) Active(ctx context.Context, id string, now time.Time) (bool, error) {
+
+	c.mu.Lock()
+	e, ok := c.entries[id]
+	c.mu.Unlock()
+
+	if !ok || now.Sub(e.fetched) >= c.ttl {
+		const q = `SELECT status, status_until FROM users WHERE user_id = $1`
+		e = statusEntry{fetched: now}
+		err := c.db.QueryRowContext(ctx, q, id).Scan(&e.user.Status, &e.user.StatusUntil)
+		switch {
+		case err == sql.ErrNoRows:
+			e.user.Status = StatusDeactivated
+		case err != nil:
+			return false, errors.Wrap(err, "selecting user status")
+		}
+
+		c.mu.Lock()
+		c.entries[id] = e
+
+		// Expired entries are dropped now and then so the cache does not grow
+		// with every user ever seen.
+		if len(c.entries) > 1000 {
+			for k, old := range c.entries {
+				if now.Sub(old.fetched) >= c.ttl {
+					delete(c.entries, k)
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	return e.user.Active(now), nil
+}
+
+// Forget drops the cached status of a user, for a change to apply right away.
+func (c *StatusCache) Forget(id string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, id)
+}
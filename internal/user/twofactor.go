@@ -0,0 +1,349 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/totp"
+	"github.com/devisions/garagesale/internal/role"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidCode occurs when a second factor code is wrong, expired or
+	// already used.
+	ErrInvalidCode = errors.New("invalid second factor code")
+
+	// ErrTwoFactorEnabled occurs when enrolling a User that already has two
+	// factor authentication enabled.
+	ErrTwoFactorEnabled = errors.New("two factor authentication is already enabled")
+
+	// ErrTwoFactorDisabled occurs when a User without two factor
+	// authentication attempts to use it.
+	ErrTwoFactorDisabled = errors.New("two factor authentication is not enabled")
+)
+
+// recoveryCodes is the number of recovery codes given on enrollment.
+const recoveryCodes = 10
+
+// EnrollTwoFactor starts the two factor authentication enrollment of a user.
+// It has to be confirmed with a code of the returned secret before taking
+// effect. Starting again replaces an enrollment that was not confirmed.
+func EnrollTwoFactor(ctx context.Context, db *sqlx.DB, id, issuer string, now time.Time) (*Enrollment, error) {
+
+	u, err := retrieve(ctx, db, nil, id)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, err := TwoFactorEnabled(ctx, db, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	e := Enrollment{
+		Secret: secret,
+		URI:    totp.URI(issuer, u.Email, secret),
+	}
+
+	// The secret and recovery codes are replaced together, so an enrollment
+	// never holds the codes of another one.
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `INSERT INTO user_totp (user_id, secret, enabled, last_step, date_created)
+		VALUES ($1, $2, false, 0, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_step = 0, date_created = $3
+		WHERE NOT user_totp.enabled`
+	res, err := tx.ExecContext(ctx, q, u.ID, secret, now.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting totp secret")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting totp secret")
+	}
+	if n == 0 {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	const qd = `DELETE FROM recovery_codes WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qd, u.ID); err != nil {
+		return nil, errors.Wrap(err, "deleting recovery codes")
+	}
+
+	for i := 0; i < recoveryCodes; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, errors.Wrap(err, "generating recovery code")
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		c = c[:4] + "-" + c[4:]
+
+		const qi = `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, qi, u.ID, hashToken(normalizeCode(c))); err != nil {
+			return nil, errors.Wrap(err, "inserting recovery code")
+		}
+		e.RecoveryCodes = append(e.RecoveryCodes, c)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing enrollment")
+	}
+
+	return &e, nil
+}
+
+// ConfirmTwoFactor enables two factor authentication for a user that entered
+// a code of the secret they enrolled.
+func ConfirmTwoFactor(ctx context.Context, db *sqlx.DB, id, code string, now time.Time) error {
+
+	// The secret is locked so that enrolling again at the same time cannot
+	// replace it between checking the code and enabling it.
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	t, err := retrieveTOTP(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+	if t.Enabled {
+		return ErrTwoFactorEnabled
+	}
+
+	step, ok := totp.Validate(t.Secret, code, now)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	const q = `UPDATE user_totp SET enabled = true, last_step = $2 WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id, step); err != nil {
+		return errors.Wrap(err, "enabling totp")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing totp")
+	}
+	return nil
+}
+
+// DisableTwoFactor turns two factor authentication off for a user that gives
+// a valid code.
+func DisableTwoFactor(ctx context.Context, db *sqlx.DB, id, code string, now time.Time) error {
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	if err := checkSecondFactor(ctx, tx, id, code, now); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM user_totp WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrap(err, "deleting totp secret")
+	}
+	const qd = `DELETE FROM recovery_codes WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qd, id); err != nil {
+		return errors.Wrap(err, "deleting recovery codes")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing totp")
+	}
+	return nil
+}
+
+// TwoFactorEnabled tells whether a user has two factor authentication
+// enabled.
+func TwoFactorEnabled(ctx context.Context, db *sqlx.DB, id string) (bool, error) {
+
+	var enabled bool
+	const q = `SELECT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1 AND enabled)`
+	if err := db.GetContext(ctx, &enabled, q, id); err != nil {
+		return false, errors.Wrap(err, "checking totp")
+	}
+	return enabled, nil
+}
+
+// RestrictClaims removes the roles granting admin permissions from the claims
+// of a user without two factor authentication enabled.
+func RestrictClaims(ctx context.Context, db *sqlx.DB, claims auth.Claims) (auth.Claims, error) {
+
+	if !claims.HasAdminPermission() {
+		return claims, nil
+	}
+
+	enabled, err := TwoFactorEnabled(ctx, db, claims.Subject)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	if enabled {
+		return claims, nil
+	}
+
+	claims.Roles, err = role.Unprivileged(ctx, db, claims.Roles)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims.Permissions, err = role.Resolve(ctx, db, claims.Roles)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	return claims, nil
+}
+
+// CheckSecondFactor verifies a code of a user with two factor authentication
+// enabled. The code is either a TOTP code, that cannot be used twice, or one
+// of the recovery codes, which is consumed.
+func CheckSecondFactor(ctx context.Context, db *sqlx.DB, id, code string, now time.Time) error {
+	return checkSecondFactor(ctx, db, id, code, now)
+}
+
+// checkSecondFactor verifies a code of a user with two factor authentication
+// enabled, possibly as part of a transaction.
+func checkSecondFactor(ctx context.Context, db sqlx.ExtContext, id, code string, now time.Time) error {
+
+	t, err := retrieveTOTP(ctx, db, id, false)
+	if err != nil {
+		return err
+	}
+	if !t.Enabled {
+		return ErrTwoFactorDisabled
+	}
+
+	if step, ok := totp.Validate(t.Secret, code, now); ok {
+
+		// The step only moves forward, so a code seen once is refused.
+		const q = `UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2`
+		res, err := db.ExecContext(ctx, q, id, step)
+		if err != nil {
+			return errors.Wrap(err, "updating totp step")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "updating totp step")
+		}
+		if n == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	const q = `UPDATE recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	res, err := db.ExecContext(ctx, q, id, hashToken(normalizeCode(code)), now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "using recovery code")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "using recovery code")
+	}
+	if n == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// ChallengeToken creates a token, signed with key, that proves its holder
+// gave the password of the user and may now give their second factor. It is
+// only valid until the expiration time.
+func ChallengeToken(key []byte, id string, expires time.Time) string {
+
+	payload := []byte(strings.Join([]string{"2fa", id, strconv.FormatInt(expires.Unix(), 10)}, "|"))
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(key, payload))
+}
+
+// ParseChallenge gives the id of the user a challenge token was created for.
+func ParseChallenge(key []byte, token string, now time.Time) (string, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if !hmac.Equal(sig, sign(key, payload)) {
+		return "", ErrInvalidToken
+	}
+
+	fields := bytes.Split(payload, []byte("|"))
+	if len(fields) != 3 || string(fields[0]) != "2fa" {
+		return "", ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(string(fields[2]), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", ErrInvalidToken
+	}
+
+	return string(fields[1]), nil
+}
+
+// userTOTP is the stored TOTP secret of a user.
+type userTOTP struct {
+	UserID      string    `db:"user_id"`
+	Secret      string    `db:"secret"`
+	Enabled     bool      `db:"enabled"`
+	LastStep    int64     `db:"last_step"`
+	DateCreated time.Time `db:"date_created"`
+}
+
+// retrieveTOTP gets the TOTP secret of a user, locking it until the end of the
+// transaction if asked to.
+func retrieveTOTP(ctx context.Context, db sqlx.QueryerContext, id string, lock bool) (*userTOTP, error) {
+
+	q := `SELECT * FROM user_totp WHERE user_id = $1`
+	if lock {
+		q += ` FOR UPDATE`
+	}
+
+	var t userTOTP
+	if err := sqlx.GetContext(ctx, db, &t, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTwoFactorDisabled
+		}
+		return nil, errors.Wrap(err, "selecting totp secret")
+	}
+	return &t, nil
+}
+
+// normalizeCode removes what users may type around a recovery code.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -5,25 +5,140 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/devisions/garagesale/internal/org"
 	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/role"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	// ErrAuthenticationFailure occurs when a user attempts to authenticate but
 	// anything goes wrong.
 	ErrAuthenticationFailure = errors.New("Authentication failed")
+
+	// ErrNotFound is used when a specific User is requested but does not exist.
+	ErrNotFound = errors.New("user not found")
+
+	// ErrInvalidID occurs when an ID is not in a valid form.
+	ErrInvalidID = errors.New("provided id is not a valid UUID")
+
+	// ErrEmailTaken occurs when a User would get the email of another User.
+	ErrEmailTaken = errors.New("email is already in use")
+
+	// ErrNotVerified occurs when a User that did not verify their email yet
+	// attempts to authenticate.
+	ErrNotVerified = errors.New("email address is not verified")
+
+	// ErrForbidden occurs when a User attempts to reach another organization
+	// than their own without being allowed to cross tenants.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrUnknownOrg occurs when a User would belong to an organization that
+	// does not exist.
+	ErrUnknownOrg = errors.New("user references an unknown organization")
+
+	// ErrInactive occurs when a User that is suspended or deactivated
+	// attempts to authenticate.
+	ErrInactive = errors.New("user account is not active")
 )
 
-// Create inserts a new user into the database.
-func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User, error) {
+// List retrieves the users of the organization of the claims from the
+// database.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]User, error) {
+
+	users := []User{}
+
+	const q = `SELECT * FROM users WHERE ($1::uuid IS NULL OR (org_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM roles AS r WHERE r.name = ANY(users.roles) AND 'org:manage' = ANY(r.permissions))))
+		ORDER BY email`
+	if err := db.SelectContext(ctx, &users, q, claims.Tenant()); err != nil {
+		return nil, errors.Wrap(err, "selecting users")
+	}
+
+	return users, nil
+}
+
+// Retrieve gets the specified user from the database. Users of other
+// organizations than the one of the claims are not found, nor are users that
+// may cross tenants unless the claims may too.
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) (*User, error) {
+	return retrieve(ctx, db, claims.Tenant(), id)
+}
+
+// retrieve gets the specified user from the database, provided they belong
+// to the organization unless it is nil. Users that may cross tenants belong
+// to none.
+func retrieve(ctx context.Context, db *sqlx.DB, orgID *string, id string) (*User, error) {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var u User
+	const q = `SELECT * FROM users WHERE user_id = $1 AND ($2::uuid IS NULL OR (org_id = $2 AND NOT EXISTS (
+			SELECT 1 FROM roles AS r WHERE r.name = ANY(users.roles) AND 'org:manage' = ANY(r.permissions))))`
+	if err := db.GetContext(ctx, &u, q, id, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	return &u, nil
+}
+
+// RetrieveByEmail gets the user with the given email from the database,
+// whatever their organization. It returns ErrNotFound if there is no such
+// user, which callers must not reveal to unauthenticated clients.
+func RetrieveByEmail(ctx context.Context, db *sqlx.DB, email string) (*User, error) {
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(n.Password), bcrypt.DefaultCost)
+	var u User
+	const q = `SELECT * FROM users WHERE email = $1`
+	if err := db.GetContext(ctx, &u, q, email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting single user")
+	}
+
+	return &u, nil
+}
+
+// Create inserts a new user into the database. Users created this way are
+// trusted to have a valid email. The password must follow the policy. The
+// user joins the default organization unless told otherwise.
+func Create(ctx context.Context, db *sqlx.DB, p Policy, n NewUser, now time.Time) (*User, error) {
+	return create(ctx, db, p, n, true, now)
+}
+
+// Register inserts a new user that signed up on their own. The user has to
+// verify their email before being able to authenticate. They join the default
+// organization.
+func Register(ctx context.Context, db *sqlx.DB, p Policy, nr NewRegistration, now time.Time) (*User, error) {
+
+	n := NewUser{
+		Name:            nr.Name,
+		Email:           nr.Email,
+		Roles:           []string{auth.RoleUser},
+		Password:        nr.Password,
+		PasswordConfirm: nr.PasswordConfirm,
+	}
+	return create(ctx, db, p, n, false, now)
+}
+
+// create inserts a new user into the database.
+func create(ctx context.Context, db *sqlx.DB, p Policy, n NewUser, verified bool, now time.Time) (*User, error) {
+
+	if err := p.Check(n.Password); err != nil {
+		return nil, err
+	}
+
+	hash, err := p.hasher().Hash(n.Password)
 	if err != nil {
-		return nil, errors.Wrap(err, "generating password hash")
+		return nil, err
 	}
 
 	u := User{
@@ -32,30 +147,200 @@ func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User,
 		Email:        n.Email,
 		PasswordHash: hash,
 		Roles:        n.Roles,
+		OrgID:        n.OrgID,
+		Verified:     verified,
+		Status:       StatusActive,
 		DateCreated:  now.UTC(),
 		DateUpdated:  now.UTC(),
 	}
 
+	if u.OrgID == "" {
+		u.OrgID = org.Default
+	}
+
 	const q = `INSERT INTO users
-		(user_id, name, email, password_hash, roles, date_created, date_updated)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		(user_id, name, email, password_hash, roles, org_id, verified, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 	_, err = db.ExecContext(
 		ctx, q,
 		u.ID, u.Name, u.Email,
-		u.PasswordHash, u.Roles,
+		u.PasswordHash, u.Roles, u.OrgID, u.Verified,
 		u.DateCreated, u.DateUpdated,
 	)
 	if err != nil {
+		if isEmailTaken(err) {
+			return nil, ErrEmailTaken
+		}
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "users_org_id_fkey" {
+			return nil, ErrUnknownOrg
+		}
 		return nil, errors.Wrap(err, "inserting user")
 	}
 
+	if err := recordPassword(ctx, db, u.ID, u.PasswordHash, now); err != nil {
+		return nil, err
+	}
+
 	return &u, nil
 }
 
+// Update replaces a user document in the database. A new password must follow
+// the policy. Users of other organizations than the one of the claims are not
+// found.
+func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, p Policy, id string, upd UpdateUser, now time.Time) error {
+
+	u, err := Retrieve(ctx, db, claims, id)
+	if err != nil {
+		return err
+	}
+
+	if upd.Name != nil {
+		u.Name = *upd.Name
+	}
+	if upd.Email != nil {
+		u.Email = *upd.Email
+	}
+	if upd.Roles != nil {
+		u.Roles = upd.Roles
+	}
+	if upd.Password != nil {
+		if err := p.checkChange(ctx, db, u.ID, *upd.Password); err != nil {
+			return err
+		}
+		pw, err := p.hasher().Hash(*upd.Password)
+		if err != nil {
+			return err
+		}
+		u.PasswordHash = pw
+	}
+	u.DateUpdated = now.UTC()
+
+	const q = `UPDATE users SET
+		"name" = $2,
+		"email" = $3,
+		"roles" = $4,
+		"password_hash" = $5,
+		"date_updated" = $6
+		WHERE user_id = $1`
+	_, err = db.ExecContext(ctx, q, id,
+		u.Name, u.Email, u.Roles,
+		u.PasswordHash, u.DateUpdated,
+	)
+	if err != nil {
+		if isEmailTaken(err) {
+			return ErrEmailTaken
+		}
+		return errors.Wrap(err, "updating user")
+	}
+
+	if upd.Password != nil {
+		if err := recordPassword(ctx, db, u.ID, u.PasswordHash, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ChangeProfile modifies what a user may change about themselves. A new email
+// requires the current password of the user and is kept pending, to become
+// the email of the user once verified. Whether another user has that email
+// only shows when verifying it, so that it cannot be used to find out which
+// emails are in the system.
+func ChangeProfile(ctx context.Context, db *sqlx.DB, id string, pc ProfileChange, now time.Time) (*User, error) {
+
+	u, err := retrieve(ctx, db, nil, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if pc.Name != nil {
+		u.Name = *pc.Name
+	}
+	if pc.Email != nil {
+		switch *pc.Email {
+		case u.Email:
+			u.PendingEmail = nil
+		default:
+			if pc.CurrentPassword == nil {
+				return nil, ErrAuthenticationFailure
+			}
+			if err := ComparePassword(u.PasswordHash, *pc.CurrentPassword); err != nil {
+				return nil, err
+			}
+			u.PendingEmail = pc.Email
+		}
+	}
+	u.DateUpdated = now.UTC()
+
+	const q = `UPDATE users SET name = $2, pending_email = $3, date_updated = $4 WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, u.Name, u.PendingEmail, u.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "updating profile")
+	}
+
+	return u, nil
+}
+
+// ChangePassword sets a new password for a user that knows their current
+// one. The new password must follow the policy.
+func ChangePassword(ctx context.Context, db *sqlx.DB, p Policy, id string, pc PasswordChange, now time.Time) error {
+
+	u, err := retrieve(ctx, db, nil, id)
+	if err != nil {
+		return err
+	}
+
+	if err := ComparePassword(u.PasswordHash, pc.CurrentPassword); err != nil {
+		return err
+	}
+
+	if err := p.checkChange(ctx, db, u.ID, pc.Password); err != nil {
+		return err
+	}
+
+	return setPassword(ctx, db, p, u.ID, pc.Password, now)
+}
+
+// setPassword stores the hash of a new password of a user and records it in
+// the password history.
+func setPassword(ctx context.Context, db *sqlx.DB, p Policy, userID, password string, now time.Time) error {
+
+	hash, err := p.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	const q = `UPDATE users SET password_hash = $2, date_updated = $3 WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, userID, hash, now.UTC()); err != nil {
+		return errors.Wrap(err, "updating password")
+	}
+
+	return recordPassword(ctx, db, userID, hash, now)
+}
+
+// Delete removes a user from the database. Users of other organizations than
+// the one of the claims are left alone.
+func Delete(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) error {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM users WHERE user_id = $1 AND ($2::uuid IS NULL OR (org_id = $2 AND NOT EXISTS (
+			SELECT 1 FROM roles AS r WHERE r.name = ANY(users.roles) AND 'org:manage' = ANY(r.permissions))))`
+	if _, err := db.ExecContext(ctx, q, id, claims.Tenant()); err != nil {
+		return errors.Wrapf(err, "deleting user %s", id)
+	}
+
+	return nil
+}
+
 // Authenticate finds a user by their email and verifies their password. On
 // success it returns a Claims value representing this user. The claims can be
-// used to generate a token for future authentication.
-func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, password string) (auth.Claims, error) {
+// used to generate a token for future authentication. A password hashed with
+// another algorithm or other parameters than the ones of the policy is hashed
+// again.
+func Authenticate(ctx context.Context, db *sqlx.DB, p Policy, now time.Time, email, password string) (auth.Claims, error) {
 
 	const q = `SELECT * FROM users WHERE email = $1`
 	var u User
@@ -70,14 +355,73 @@ func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, passwo
 		return auth.Claims{}, errors.Wrap(err, "selecting single user")
 	}
 
-	// Compare the provided password with the saved hash. Use the bcrypt
-	// comparison function so it is cryptographically secure.
-	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
-		return auth.Claims{}, ErrAuthenticationFailure
+	// Compare the provided password with the saved hash. The comparison
+	// functions of the algorithms are used so it is cryptographically secure.
+	if err := ComparePassword(u.PasswordHash, password); err != nil {
+		return auth.Claims{}, err
+	}
+
+	// Knowing the password is the only chance to move its hash to the current
+	// algorithm and parameters. The password history keeps the old hash, as
+	// it still tells the password was used.
+	if p.hasher().NeedsRehash(u.PasswordHash) {
+		hash, err := p.hasher().Hash(password)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+		const q = `UPDATE users SET password_hash = $2 WHERE user_id = $1`
+		if _, err := db.ExecContext(ctx, q, u.ID, hash); err != nil {
+			return auth.Claims{}, errors.Wrap(err, "rehashing password")
+		}
+	}
+
+	// Only now that the password is known to be right, we can tell why the
+	// user cannot get in.
+	if !u.Verified {
+		return auth.Claims{}, ErrNotVerified
 	}
 
 	// If we are this far the request is valid. Create some claims for the user
 	// and generate their token.
+	return newClaims(ctx, db, &u, now)
+}
+
+// RefreshClaims gives new Claims for a user that authenticated before, as
+// proven by a refresh token. The Claims reflect the current roles of the user.
+func RefreshClaims(ctx context.Context, db *sqlx.DB, now time.Time, id string) (auth.Claims, error) {
+
+	u, err := retrieve(ctx, db, nil, id)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	return newClaims(ctx, db, u, now)
+}
+
+// newClaims creates the Claims of a user, with the permissions their roles
+// grant within their organization. Users that are not active get none.
+func newClaims(ctx context.Context, db *sqlx.DB, u *User, now time.Time) (auth.Claims, error) {
+
+	if !u.Active(now) {
+		return auth.Claims{}, ErrInactive
+	}
+
 	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+	claims.OrgID = u.OrgID
+
+	var err error
+	claims.Permissions, err = role.Resolve(ctx, db, u.Roles)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
 	return claims, nil
 }
+
+// isEmailTaken tells if err is the violation of the unique email constraint
+// of the users table.
+func isEmailTaken(err error) bool {
+
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Constraint == "users_email_key"
+}
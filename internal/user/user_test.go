@@ -0,0 +1,427 @@
+package user_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devisions/garagesale/internal/org"
+	"github.com/devisions/garagesale/internal/platform/auth"
+	"github.com/devisions/garagesale/internal/platform/oidc"
+	"github.com/devisions/garagesale/internal/tests"
+	"github.com/devisions/garagesale/internal/user"
+)
+
+func TestUserTenancy(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	other, err := org.Create(ctx, db, org.NewOrg{Name: "Other Gophers"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	create := func(email, orgID string, roles ...string) *user.User {
+		nu := user.NewUser{
+			Name:            email,
+			Email:           email,
+			Roles:           roles,
+			OrgID:           orgID,
+			Password:        "gophers",
+			PasswordConfirm: "gophers",
+		}
+		u, err := user.Create(ctx, db, user.Policy{}, nu, now)
+		if err != nil {
+			t.Fatalf("creating user %s: %s", email, err)
+		}
+		return u
+	}
+	home := create("home@example.com", "", auth.RoleAdmin)
+	away := create("away@example.com", other.ID, auth.RoleAdmin)
+	super := create("super@example.com", "", auth.RoleSuperAdmin)
+
+	if home.OrgID != org.Default {
+		t.Fatalf("expected user to join the default organization, got %q", home.OrgID)
+	}
+	nu := user.NewUser{Email: "lost@example.com", Roles: []string{auth.RoleUser}, OrgID: "2b4d8f7e-29c4-4a6c-9f0b-a5d6f1ab2c37"}
+	if _, err := user.Create(ctx, db, user.Policy{}, nu, now); err != user.ErrUnknownOrg {
+		t.Fatalf("expected ErrUnknownOrg, got %v", err)
+	}
+
+	claims, err := user.Authenticate(ctx, db, user.Policy{}, now, "away@example.com", "gophers")
+	if err != nil {
+		t.Fatalf("authenticating: %s", err)
+	}
+	if claims.OrgID != other.ID {
+		t.Fatalf("expected claims of organization %q, got %q", other.ID, claims.OrgID)
+	}
+
+	if _, err := user.Retrieve(ctx, db, claims, home.ID); err != user.ErrNotFound {
+		t.Fatalf("expected ErrNotFound retrieving a user of another organization, got %v", err)
+	}
+	if _, err := user.Retrieve(ctx, db, claims, away.ID); err != nil {
+		t.Fatalf("retrieving user of the same organization: %s", err)
+	}
+	users, err := user.List(ctx, db, claims)
+	if err != nil {
+		t.Fatalf("listing users: %s", err)
+	}
+	if len(users) != 1 || users[0].ID != away.ID {
+		t.Fatalf("expected only the users of the organization, got %+v", users)
+	}
+	name := "Stolen"
+	if err := user.Update(ctx, db, claims, user.Policy{}, home.ID, user.UpdateUser{Name: &name}, now); err != user.ErrNotFound {
+		t.Fatalf("expected ErrNotFound updating a user of another organization, got %v", err)
+	}
+	if err := user.Delete(ctx, db, claims, home.ID); err != nil {
+		t.Fatalf("deleting user: %s", err)
+	}
+
+	// Super admins belong to no organization but reach all of them.
+	homeClaims, err := user.Authenticate(ctx, db, user.Policy{}, now, "home@example.com", "gophers")
+	if err != nil {
+		t.Fatalf("authenticating: %s", err)
+	}
+	if _, err := user.Retrieve(ctx, db, homeClaims, super.ID); err != user.ErrNotFound {
+		t.Fatalf("expected ErrNotFound retrieving a super admin, got %v", err)
+	}
+	superClaims, err := user.Authenticate(ctx, db, user.Policy{}, now, "super@example.com", "gophers")
+	if err != nil {
+		t.Fatalf("authenticating: %s", err)
+	}
+	if !superClaims.HasPermission(auth.PermOrgManage) {
+		t.Fatalf("expected super admin to cross tenants, got permissions %v", superClaims.Permissions)
+	}
+	users, err = user.List(ctx, db, superClaims)
+	if err != nil {
+		t.Fatalf("listing users: %s", err)
+	}
+	if exp, got := 3, len(users); exp != got {
+		t.Fatalf("expected %d users across organizations, got %d", exp, got)
+	}
+}
+
+func TestChangeProfile(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+	key := []byte("test verify key")
+
+	nu := user.NewUser{
+		Name:            "Seller",
+		Email:           "seller@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	u, err := user.Create(ctx, db, user.Policy{}, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	taken := "seller@example.com"
+	if _, err := user.ChangeProfile(ctx, db, u.ID, user.ProfileChange{Email: &taken}, now); err != nil {
+		t.Fatalf("keeping the same email: %s", err)
+	}
+
+	name, email, pass := "Top Seller", "top@example.com", "bad"
+	if _, err := user.ChangeProfile(ctx, db, u.ID, user.ProfileChange{Email: &email}, now); err != user.ErrAuthenticationFailure {
+		t.Fatalf("expected ErrAuthenticationFailure without the current password, got %v", err)
+	}
+	if _, err := user.ChangeProfile(ctx, db, u.ID, user.ProfileChange{Email: &email, CurrentPassword: &pass}, now); err != user.ErrAuthenticationFailure {
+		t.Fatalf("expected ErrAuthenticationFailure with a wrong password, got %v", err)
+	}
+
+	pass = "gophers"
+	changed, err := user.ChangeProfile(ctx, db, u.ID, user.ProfileChange{Name: &name, Email: &email, CurrentPassword: &pass}, now)
+	if err != nil {
+		t.Fatalf("changing profile: %s", err)
+	}
+	if changed.Name != name || changed.Email != nu.Email || changed.PendingEmail == nil || *changed.PendingEmail != email {
+		t.Fatalf("expected the new email to be pending, got %+v", changed)
+	}
+	if _, err := user.Authenticate(ctx, db, user.Policy{}, now, email, "gophers"); err != user.ErrAuthenticationFailure {
+		t.Fatalf("expected ErrAuthenticationFailure with the pending email, got %v", err)
+	}
+
+	token := user.VerificationToken(key, changed, now.Add(time.Hour))
+	if err := user.Verify(ctx, db, key, token, now); err != nil {
+		t.Fatalf("verifying: %s", err)
+	}
+	if _, err := user.Authenticate(ctx, db, user.Policy{}, now, email, "gophers"); err != nil {
+		t.Fatalf("authenticating with the new email: %s", err)
+	}
+
+	other := nu
+	other.Email = "other@example.com"
+	o, err := user.Create(ctx, db, user.Policy{}, other, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	// Taken emails are only refused once verified, so changing the profile
+	// does not tell which emails are in the system.
+	pending, err := user.ChangeProfile(ctx, db, o.ID, user.ProfileChange{Email: &email, CurrentPassword: &pass}, now)
+	if err != nil {
+		t.Fatalf("changing profile: %s", err)
+	}
+	token = user.VerificationToken(key, pending, now.Add(time.Hour))
+	if err := user.Verify(ctx, db, key, token, now); err != user.ErrEmailTaken {
+		t.Fatalf("expected ErrEmailTaken, got %v", err)
+	}
+}
+
+func TestAuthenticateRehash(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	old := user.Policy{Hasher: user.Bcrypt{Cost: 4}}
+	current := user.Policy{Hasher: user.Argon2id{Time: 1, Memory: 1024, Threads: 1}}
+
+	nu := user.NewUser{
+		Name:            "Seller",
+		Email:           "seller@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	if _, err := user.Create(ctx, db, old, nu, now); err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	if _, err := user.Authenticate(ctx, db, current, now, "seller@example.com", "gopher"); err != user.ErrAuthenticationFailure {
+		t.Fatalf("expected ErrAuthenticationFailure, got %v", err)
+	}
+	claims, err := user.Authenticate(ctx, db, current, now, "seller@example.com", "gophers")
+	if err != nil {
+		t.Fatalf("authenticating: %s", err)
+	}
+
+	u, err := user.Retrieve(ctx, db, claims, claims.Subject)
+	if err != nil {
+		t.Fatalf("retrieving user: %s", err)
+	}
+	if !strings.HasPrefix(string(u.PasswordHash), "$argon2id$") {
+		t.Fatalf("expected the password to be rehashed with argon2id, got %q", u.PasswordHash)
+	}
+
+	// The old hash stays in the history, so the password cannot be reused.
+	pc := user.PasswordChange{CurrentPassword: "gophers", Password: "gophers", PasswordConfirm: "gophers"}
+	if err := user.ChangePassword(ctx, db, user.Policy{History: 1, Hasher: current.Hasher}, u.ID, pc, now); err == nil {
+		t.Fatal("expected reusing the password to be refused")
+	}
+	if _, err := user.Authenticate(ctx, db, current, now, "seller@example.com", "gophers"); err != nil {
+		t.Fatalf("authenticating again: %s", err)
+	}
+}
+
+func TestFederate(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	nr := user.NewRegistration{
+		Name:            "Seller",
+		Email:           "seller@example.com",
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	seller, err := user.Register(ctx, db, user.Policy{}, nr, now)
+	if err != nil {
+		t.Fatalf("registering user: %s", err)
+	}
+
+	id := oidc.Identity{Issuer: "https://id.example.com", Subject: "1", Email: "seller@example.com"}
+	if _, err := user.Federate(ctx, db, user.Policy{}, id, now); err != user.ErrIdentityNotVerified {
+		t.Fatalf("expected ErrIdentityNotVerified, got %v", err)
+	}
+
+	// Whoever registered an account that was never verified may not be the
+	// owner of its email, so the identity is not linked to it and the
+	// password they chose does not get verified.
+	id.EmailVerified = true
+	if _, err := user.Federate(ctx, db, user.Policy{}, id, now); err != user.ErrAccountNotVerified {
+		t.Fatalf("expected ErrAccountNotVerified, got %v", err)
+	}
+	if _, err := user.Authenticate(ctx, db, user.Policy{}, now, "seller@example.com", "gophers"); err != user.ErrNotVerified {
+		t.Fatalf("expected the user to stay unverified, got %v", err)
+	}
+
+	// A verified email links the identity to the verified user having it.
+	key := []byte("secret")
+	if err := user.Verify(ctx, db, key, user.VerificationToken(key, seller, now.Add(time.Hour)), now); err != nil {
+		t.Fatalf("verifying user: %s", err)
+	}
+	claims, err := user.Federate(ctx, db, user.Policy{}, id, now)
+	if err != nil {
+		t.Fatalf("federating: %s", err)
+	}
+	if claims.Subject != seller.ID {
+		t.Fatalf("expected to be linked to %s, got %s", seller.ID, claims.Subject)
+	}
+
+	// Once linked, the email the provider gives does not matter anymore.
+	id.Email = "seller@elsewhere.com"
+	if claims, err = user.Federate(ctx, db, user.Policy{}, id, now); err != nil || claims.Subject != seller.ID {
+		t.Fatalf("expected to stay linked to %s, got %s and %v", seller.ID, claims.Subject, err)
+	}
+
+	// Unknown emails get a new user.
+	other := oidc.Identity{Issuer: "https://id.example.com", Subject: "2", Email: "volunteer@example.com", EmailVerified: true, Name: "Volunteer"}
+	claims, err = user.Federate(ctx, db, user.Policy{MinLength: 100}, other, now)
+	if err != nil {
+		t.Fatalf("federating: %s", err)
+	}
+	u, err := user.Retrieve(ctx, db, claims, claims.Subject)
+	if err != nil {
+		t.Fatalf("retrieving user: %s", err)
+	}
+	if u.Email != other.Email || u.Name != other.Name || !u.Verified || len(u.Roles) != 1 || u.Roles[0] != auth.RoleUser {
+		t.Fatalf("unexpected provisioned user: %+v", u)
+	}
+	if u.OrgID != org.Default {
+		t.Fatalf("expected the default organization, got %s", u.OrgID)
+	}
+}
+
+func TestImpersonate(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	nu := user.NewUser{
+		Name:            "Seller",
+		Email:           "seller@example.com",
+		Roles:           []string{auth.RoleAdmin, auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	seller, err := user.Create(ctx, db, user.Policy{}, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	nu.Email = "admin@example.com"
+	a, err := user.Create(ctx, db, user.Policy{}, nu, now)
+	if err != nil {
+		t.Fatalf("creating admin: %s", err)
+	}
+	admin := auth.NewClaims(a.ID, a.Roles, now, time.Hour)
+	admin.OrgID = org.Default
+	admin.Permissions = []string{auth.PermUserImpersonate}
+
+	claims, err := user.Impersonate(ctx, db, admin, seller.ID, now, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("impersonating: %s", err)
+	}
+	if claims.Subject != seller.ID || !claims.Impersonated() || claims.Act.Subject != admin.Subject {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	if len(claims.Permissions) != 0 {
+		t.Fatalf("expected no permissions, got %v", claims.Permissions)
+	}
+	if want := now.Add(15 * time.Minute).Unix(); claims.ExpiresAt != want {
+		t.Fatalf("expected to expire at %d, got %d", want, claims.ExpiresAt)
+	}
+
+	if _, err := user.Impersonate(ctx, db, claims, seller.ID, now, time.Minute); err != user.ErrForbidden {
+		t.Fatalf("expected ErrForbidden impersonating again, got %v", err)
+	}
+
+	list, err := user.ListImpersonations(ctx, db, seller.ID)
+	if err != nil {
+		t.Fatalf("listing impersonations: %s", err)
+	}
+	if len(list) != 1 || list[0].ActorID != admin.Subject || list[0].TokenID != claims.Id {
+		t.Fatalf("expected the impersonation to be recorded, got %+v", list)
+	}
+}
+
+func TestStatus(t *testing.T) {
+
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.October, 1, 8, 0, 0, 0, time.UTC)
+
+	nu := user.NewUser{
+		Name:            "Seller",
+		Email:           "seller@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	seller, err := user.Create(ctx, db, user.Policy{}, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	admin := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleAdmin}, now, time.Hour)
+	admin.OrgID = org.Default
+
+	cache := user.NewStatusCache(db, time.Minute)
+	if active, err := cache.Active(ctx, seller.ID, now); err != nil || !active {
+		t.Fatalf("expected the user to be active, got %v and %v", active, err)
+	}
+
+	// Suspended for a day.
+	reason, until := "spam", now.Add(24*time.Hour)
+	sc := user.StatusChange{Status: user.StatusSuspended, Reason: &reason, Until: &until}
+	u, err := user.SetStatus(ctx, db, admin, seller.ID, sc, now)
+	if err != nil {
+		t.Fatalf("suspending user: %s", err)
+	}
+	if u.Active(now) || !u.Active(until) {
+		t.Fatalf("expected the user to be suspended until %v, got %+v", until, u)
+	}
+
+	if _, err := user.Authenticate(ctx, db, user.Policy{}, now, nu.Email, nu.Password); err != user.ErrInactive {
+		t.Fatalf("expected ErrInactive, got %v", err)
+	}
+	if _, err := user.Authenticate(ctx, db, user.Policy{}, until, nu.Email, nu.Password); err != nil {
+		t.Fatalf("expected the suspension to expire, got %v", err)
+	}
+
+	// The cache only sees the change once forgotten or expired.
+	if active, _ := cache.Active(ctx, seller.ID, now.Add(time.Second)); !active {
+		t.Fatal("expected the cached status to be used")
+	}
+	if active, _ := cache.Active(ctx, seller.ID, now.Add(time.Minute)); active {
+		t.Fatal("expected the status to be looked up again")
+	}
+
+	sc = user.StatusChange{Status: user.StatusActive, Reason: &reason}
+	if u, err = user.SetStatus(ctx, db, admin, seller.ID, sc, now); err != nil {
+		t.Fatalf("reactivating user: %s", err)
+	}
+	if u.StatusReason != nil || u.StatusUntil != nil {
+		t.Fatalf("expected the reason and expiry to be cleared, got %+v", u)
+	}
+	cache.Forget(seller.ID)
+	if active, _ := cache.Active(ctx, seller.ID, now.Add(time.Minute)); !active {
+		t.Fatal("expected the user to be active again")
+	}
+
+	if _, err := user.SetStatus(ctx, db, admin, admin.Subject, sc, now); err != user.ErrForbidden {
+		t.Fatalf("expected ErrForbidden changing their own status, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidToken occurs when a verification or password reset token is
+// malformed, tampered with, expired or already used.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// VerificationToken creates a token, signed with key, that proves its holder
+// received it at the email address of the user, or at the one they are
+// changing to. It is only valid until the expiration time, and only as long
+// as the user keeps that email.
+func VerificationToken(key []byte, u *User, expires time.Time) string {
+
+	email := u.Email
+	if u.PendingEmail != nil {
+		email = *u.PendingEmail
+	}
+
+	payload := strings.Join([]string{u.ID, email, strconv.FormatInt(expires.Unix(), 10)}, "|")
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(key, []byte(payload)))
+}
+
+// Verify marks the user a verification token was issued for as verified. A
+// pending email the token was issued for becomes the email of the user.
+func Verify(ctx context.Context, db *sqlx.DB, key []byte, token string, now time.Time) error {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if !hmac.Equal(sig, sign(key, payload)) {
+		return ErrInvalidToken
+	}
+
+	// The email sits in the middle as it may contain the separator itself.
+	first, last := bytes.IndexByte(payload, '|'), bytes.LastIndexByte(payload, '|')
+	if first < 0 || first == last {
+		return ErrInvalidToken
+	}
+	id, email := string(payload[:first]), string(payload[first+1:last])
+	expires, err := strconv.ParseInt(string(payload[last+1:]), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return ErrInvalidToken
+	}
+
+	const q = `UPDATE users SET verified = true, email = $2, pending_email = NULL, date_updated = $3
+		WHERE user_id = $1 AND (email = $2 OR pending_email = $2)`
+	res, err := db.ExecContext(ctx, q, id, email, now.UTC())
+	if err != nil {
+		if isEmailTaken(err) {
+			return ErrEmailTaken
+		}
+		return errors.Wrap(err, "verifying user")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "verifying user")
+	}
+	if n == 0 {
+		// The user is gone or changed their email since.
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// sign computes the HMAC-SHA256 of data with key.
+func sign(key, data []byte) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
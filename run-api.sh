@@ -2,5 +2,7 @@
 
 export SALES_DB_DISABLE_TLS=true
 
+export SALES_MAIL_SINK=log
+
 go run ./cmd/sales-api
 